@@ -0,0 +1,272 @@
+package FCflash
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrDiverged = errors.New("replay diverged")
+
+// A capture file has one line per transfer:
+//
+//	<seconds since open> <direction> <hex bytes>
+//
+// where direction is '>' for bytes sent to the reader and '<' for bytes received.
+const (
+	CAPTURE_SEND = '>'
+	CAPTURE_RECV = '<'
+)
+
+// recorder copies all traffic of a Transport into a capture file.
+type recorder struct {
+	t     Transport
+	w     *bufio.Writer
+	c     io.Closer
+	start time.Time
+	mu    sync.Mutex
+}
+
+// NewRecorder records the traffic of t into w, which is closed along with t if it is an io.Closer.
+func NewRecorder(t Transport, w io.Writer) Transport {
+	r := &recorder{t: t, w: bufio.NewWriter(w), start: time.Now()}
+	r.c, _ = w.(io.Closer)
+	return r
+}
+
+// Capture records the traffic of t into the file fileName.
+func Capture(t Transport, fileName string) (Transport, error) {
+	f, err := os.Create(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return NewRecorder(t, f), nil
+}
+
+func (r *recorder) record(dir byte, p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	d := time.Since(r.start)
+	fmt.Fprintf(r.w, "%d.%06d %c %x\n", d/time.Second, d%time.Second/time.Microsecond, dir, p)
+}
+
+func (r *recorder) Read(p []byte) (int, error) {
+	n, err := r.t.Read(p)
+	r.record(CAPTURE_RECV, p[:n])
+	return n, err
+}
+
+func (r *recorder) Write(p []byte) (int, error) {
+	n, err := r.t.Write(p)
+	r.record(CAPTURE_SEND, p[:n])
+	return n, err
+}
+
+func (r *recorder) ReadFullContext(ctx context.Context, p []byte) (int, error) {
+	n, err := r.t.ReadFullContext(ctx, p)
+	r.record(CAPTURE_RECV, p[:n])
+	return n, err
+}
+
+func (r *recorder) WriteContext(ctx context.Context, p []byte) (int, error) {
+	n, err := r.t.WriteContext(ctx, p)
+	r.record(CAPTURE_SEND, p[:n])
+	return n, err
+}
+
+// Drain discards input unrecorded, but its size is noted for replay.
+func (r *recorder) Drain(quiet time.Duration) (int, error) {
+	n, err := r.t.Drain(quiet)
+	r.mu.Lock()
+	fmt.Fprintf(r.w, "# drained %d\n", n)
+	r.mu.Unlock()
+	return n, err
+}
+
+func (r *recorder) Close() error {
+	err := r.t.Close()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if ferr := r.w.Flush(); err == nil {
+		err = ferr
+	}
+	if r.c != nil {
+		if cerr := r.c.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+// replayChunk is received data that the reader sent once `after` bytes had been sent to it.
+type replayChunk struct {
+	after int
+	b     []byte
+}
+
+// replay plays a capture back as the reader.
+// Sent bytes are compared against the recorded ones, and a recorded reply
+// is released only after the requests preceding it have been sent again,
+// so the outcome does not depend on timing.
+type replay struct {
+	sent     []byte
+	sentLine []int // capture line of each sent byte
+	recv     []replayChunk
+	drained  []int // sizes of recorded drains, in order
+	pos      int   // bytes sent so far
+	err      error
+}
+
+// OpenReplay loads a capture file written by Capture.
+func OpenReplay(fileName string) (Transport, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewReplay(f)
+}
+
+// NewReplay loads a capture from r.
+func NewReplay(r io.Reader) (Transport, error) {
+	p := &replay{}
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 4*MBED_PACKET_SIZE)
+	for line := 1; s.Scan(); line++ {
+		text := s.Text()
+		var n int
+		if _, err := fmt.Sscanf(text, "# drained %d", &n); err == nil {
+			p.drained = append(p.drained, n)
+			continue
+		}
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		var (
+			t   float64
+			dir byte
+			b   []byte
+		)
+		if _, err := fmt.Sscanf(text, "%f %c %x", &t, &dir, &b); err != nil {
+			return nil, fmt.Errorf("capture line %d: %w", line, err)
+		}
+		switch dir {
+		case CAPTURE_SEND:
+			p.sent = append(p.sent, b...)
+			for range b {
+				p.sentLine = append(p.sentLine, line)
+			}
+		case CAPTURE_RECV:
+			p.recv = append(p.recv, replayChunk{after: len(p.sent), b: b})
+		default:
+			return nil, fmt.Errorf("capture line %d: direction %q", line, dir)
+		}
+	}
+	return p, s.Err()
+}
+
+// available returns the received data due by now.
+func (p *replay) available() []byte {
+	if len(p.recv) == 0 || p.recv[0].after > p.pos {
+		return nil
+	}
+	return p.recv[0].b
+}
+
+func (p *replay) consume(n int) {
+	p.recv[0].b = p.recv[0].b[n:]
+	if len(p.recv[0].b) == 0 {
+		p.recv = p.recv[1:]
+	}
+}
+
+func (p *replay) Write(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	for i, c := range b {
+		if p.pos == len(p.sent) {
+			p.err = fmt.Errorf("%w at byte %d: capture ended, sent %x", ErrDiverged, p.pos, head(b[i:]))
+			return i, p.err
+		}
+		if c != p.sent[p.pos] {
+			p.err = fmt.Errorf("%w at byte %d (capture line %d): sent %x, recorded %x",
+				ErrDiverged, p.pos, p.sentLine[p.pos], head(b[i:]), head(p.sent[p.pos:]))
+			return i, p.err
+		}
+		p.pos++
+	}
+	return len(b), nil
+}
+
+// Read returns io.EOF rather than blocking when no recorded reply is due.
+func (p *replay) Read(b []byte) (int, error) {
+	if p.err != nil {
+		return 0, p.err
+	}
+	a := p.available()
+	if len(a) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, a)
+	p.consume(n)
+	return n, nil
+}
+
+func (p *replay) ReadFullContext(ctx context.Context, b []byte) (int, error) {
+	n := 0
+	for n < len(b) && p.err == nil {
+		a := p.available()
+		if len(a) == 0 {
+			break
+		}
+		m := copy(b[n:], a)
+		p.consume(m)
+		n += m
+	}
+	if p.err != nil {
+		return n, p.err
+	}
+	if n < len(b) {
+		// the reader did not answer this in the capture either
+		ctx, cancel := context.WithDeadline(ctx, time.Now())
+		defer cancel()
+		<-ctx.Done()
+		return n, timeoutError(ctx, n, len(b))
+	}
+	return n, nil
+}
+
+func (p *replay) WriteContext(ctx context.Context, b []byte) (int, error) {
+	return p.Write(b)
+}
+
+// Drain discards nothing: drained bytes are not in the capture, only their count.
+func (p *replay) Drain(quiet time.Duration) (int, error) {
+	n := 0
+	if len(p.drained) > 0 {
+		n, p.drained = p.drained[0], p.drained[1:]
+	}
+	return n, p.err
+}
+
+func (p *replay) Close() error {
+	return nil
+}
+
+// head shortens b for error messages.
+func head(b []byte) []byte {
+	if len(b) > MESSAGE_SIZE*2 {
+		return b[:MESSAGE_SIZE*2]
+	}
+	return b
+}
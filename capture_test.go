@@ -0,0 +1,58 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestCaptureReplay(t *testing.T) {
+	v, err := NewVirtualFC(testImage(t, 0, 2, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var capture bytes.Buffer
+	rec := NewRecorder(v, &capture)
+	want := readCPU(t, NewClient(rec), 0x0100, 0x0800)
+	err = rec.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tr, err := NewReplay(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewClient(tr)
+	if !bytes.Equal(readCPU(t, c, 0x0100, 0x0800), want) {
+		t.Errorf("replayed PRG differs")
+	}
+
+	// a request that was not recorded
+	err = c.CPURead(0, make([]byte, 1))
+	if !errors.Is(err, ErrDiverged) {
+		t.Errorf("past the end: got %v, want ErrDiverged", err)
+	}
+}
+
+func TestReplayDiverged(t *testing.T) {
+	m := Message{Request: REQ_CPU_READ, Value: 0x0100, Length: 2}
+	b, _ := m.MarshalBinary()
+	capture := fmt.Sprintf("0.000000 > %x\n0.001000 < a55a\n", b)
+
+	tr, err := NewReplay(strings.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewClient(tr).CPURead(0x0200, make([]byte, 2))
+	if !errors.Is(err, ErrDiverged) {
+		t.Errorf("other address: got %v, want ErrDiverged", err)
+	}
+
+	_, err = NewReplay(strings.NewReader("0.0 ? 00\n"))
+	if err == nil {
+		t.Errorf("unknown direction loaded")
+	}
+}
@@ -0,0 +1,371 @@
+package FCflash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const DEFAULT_TIMEOUT = 5 * time.Second
+
+var (
+	ErrInvalidLength = errors.New("invalid length")
+	ErrInvalidValue  = errors.New("invalid value")
+	ErrEchoMismatch  = errors.New("echo mismatch")
+	ErrUnsupported   = errors.New("not supported by the firmware")
+)
+
+// RequestError records a failed request and the address it was sent with.
+type RequestError struct {
+	Request Request
+	Value   uint16
+	Err     error
+}
+
+func (e *RequestError) Error() string {
+	return fmt.Sprintf("%v(%04x): %v", e.Request, e.Value, e.Err)
+}
+
+func (e *RequestError) Unwrap() error {
+	return e.Err
+}
+
+// Client speaks the 8-byte Message protocol of firmware/FCflash.
+//
+// If the stream is a Transport, every request is bounded by Timeout and
+// the Client's context, and a read that times out is retried up to Retries
+// times after resynchronising the stream. That takes REQ_ECHO, so with
+// the unversioned firmware a read that times out fails with ErrUnsupported.
+//
+// The write modes read each packet back, and write it again up to
+// WriteRetries times while it differs, see Verifier.
+//
+// After Handshake, requests the firmware cannot handle fail with ErrUnsupported
+// instead of being sent.
+type Client struct {
+	Timeout      time.Duration
+	Retries      int
+	WriteRetries int
+
+	s    io.ReadWriter
+	ctx  context.Context
+	buf  []uint8
+	info *FirmwareInfo
+	pipe *Pipeline
+}
+
+func NewClient(s io.ReadWriter) *Client {
+	buf := make([]uint8, MESSAGE_SIZE+PACKET_SIZE)
+	return &Client{Timeout: DEFAULT_TIMEOUT, s: s, ctx: context.Background(), buf: buf}
+}
+
+// WithContext returns a shallow copy of c whose requests are canceled with ctx.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+func (c *Client) context() (context.Context, context.CancelFunc) {
+	if c.Timeout <= 0 {
+		return context.WithCancel(c.ctx)
+	}
+	return context.WithTimeout(c.ctx, c.Timeout)
+}
+
+func (c *Client) writeFull(p []byte) error {
+	t, ok := c.s.(Transport)
+	if !ok {
+		_, err := c.s.Write(p)
+		return err
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	_, err := t.WriteContext(ctx, p)
+	return err
+}
+
+func (c *Client) readFull(p []byte) error {
+	t, ok := c.s.(Transport)
+	if !ok {
+		_, err := io.ReadFull(c.s, p)
+		return err
+	}
+	ctx, cancel := c.context()
+	defer cancel()
+	_, err := t.ReadFullContext(ctx, p)
+	return err
+}
+
+// Handshake identifies the firmware and keeps its capabilities for checking
+// later requests. Anything but the AVR firmware is an error.
+func (c *Client) Handshake() (FirmwareInfo, error) {
+	t, ok := c.s.(Transport)
+	if !ok {
+		return FirmwareInfo{}, errors.New("handshake: not a Transport")
+	}
+	info, err := Identify(t, PROBE_TIMEOUT)
+	if err != nil {
+		return info, err
+	}
+	if info.Firmware != FIRMWARE_AVR {
+		return info, fmt.Errorf("handshake: %v: %w", info.Firmware, ErrUnsupported)
+	}
+	c.info = &info
+	return info, nil
+}
+
+// Supports tells whether the firmware handles r, assuming it does before Handshake.
+func (c *Client) Supports(r Request) bool {
+	return c.info == nil || c.info.Caps&r.Cap() == r.Cap()
+}
+
+// Caps returns what Handshake found, or CAPS_LEGACY before it.
+func (c *Client) Caps() Caps {
+	if c.info == nil {
+		return CAPS_LEGACY
+	}
+	return c.info.Caps
+}
+
+func (c *Client) send(m Message, payload []byte) error {
+	if c.pipe != nil {
+		c.pipe.wait()
+	}
+	if !c.Supports(m.Request) {
+		return &RequestError{m.Request, m.Value, fmt.Errorf("%w: %v", ErrUnsupported, c.info)}
+	}
+	if len(payload) > PACKET_SIZE {
+		return &RequestError{m.Request, m.Value, fmt.Errorf("%w: %d", ErrInvalidLength, len(payload))}
+	}
+	m.put(c.buf[0:MESSAGE_SIZE])
+	n := copy(c.buf[MESSAGE_SIZE:], payload)
+	err := c.writeFull(c.buf[0 : MESSAGE_SIZE+n])
+	if err != nil {
+		return &RequestError{m.Request, m.Value, err}
+	}
+	return nil
+}
+
+func (c *Client) read(m Message, buf []byte) error {
+	if len(buf) == 0 || len(buf) > PACKET_SIZE {
+		return &RequestError{m.Request, m.Value, fmt.Errorf("%w: %d", ErrInvalidLength, len(buf))}
+	}
+	m.Length = uint16(len(buf))
+	for retry := 0; ; retry++ {
+		err := c.send(m, nil)
+		if err != nil {
+			return err
+		}
+		err = c.readFull(buf)
+		if err == nil {
+			return nil
+		}
+		if retry >= c.Retries || !(errors.Is(err, ErrTimeout) || errors.Is(err, ErrShortRead)) {
+			return &RequestError{m.Request, m.Value, err}
+		}
+		if rerr := c.Resync(); rerr != nil {
+			return &RequestError{m.Request, m.Value, fmt.Errorf("%v: %w", err, rerr)}
+		}
+	}
+}
+
+func (c *Client) write(m Message, data []byte) error {
+	if len(data) == 0 {
+		return &RequestError{m.Request, m.Value, fmt.Errorf("%w: %d", ErrInvalidLength, len(data))}
+	}
+	m.Length = uint16(len(data))
+	return c.send(m, data)
+}
+
+func rawValue(r Request, addr uint32) (uint16, error) {
+	if addr&0xff != 0 || addr > 0xffffff {
+		return 0, &RequestError{r, uint16(addr >> 8), fmt.Errorf("%w: unaligned address %06x", ErrInvalidValue, addr)}
+	}
+	return uint16(addr >> 8), nil
+}
+
+// Echo sends REQ_ECHO and waits for the firmware to return the message.
+func (c *Client) Echo(value uint16) (Message, error) {
+	m := Message{Request: REQ_ECHO, Value: value}
+	err := c.send(m, nil)
+	if err != nil {
+		return m, err
+	}
+	reply := make([]byte, MESSAGE_SIZE)
+	err = c.readFull(reply)
+	if err != nil {
+		return m, &RequestError{m.Request, m.Value, err}
+	}
+	var r Message
+	r.UnmarshalBinary(reply)
+	if r.Request != REQ_ECHO || r.Value != value {
+		return r, &RequestError{m.Request, m.Value, ErrEchoMismatch}
+	}
+	return r, nil
+}
+
+// Resync drops whatever is left of a partial reply and checks with
+// REQ_ECHO that the firmware is waiting for a new request. Firmware that
+// Handshake found unversioned has no REQ_ECHO, so it is ErrUnsupported.
+func (c *Client) Resync() error {
+	t, ok := c.s.(Transport)
+	if !ok {
+		return errors.New("resync: not a Transport")
+	}
+	if c.info != nil && c.info.Version == 0 {
+		return fmt.Errorf("resync: %v: %w", c.info, ErrUnsupported)
+	}
+
+	var err error
+	for i := 0; i < 3; i++ {
+		_, err = t.Drain(100 * time.Millisecond)
+		if err != nil {
+			return err
+		}
+		_, err = c.Echo(uint16(time.Now().UnixNano()))
+		if err == nil {
+			return nil
+		}
+		if c.ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// PHI2Init runs M2 for cycles with nothing on the cartridge selected.
+func (c *Client) PHI2Init(cycles uint16) error {
+	return c.send(Message{Request: REQ_PHI2_INIT, Value: cycles}, nil)
+}
+
+// CPURead reads len(buf) bytes of PRG at 0x8000|addr.
+func (c *Client) CPURead(addr uint16, buf []byte) error {
+	return c.read(Message{Request: REQ_CPU_READ, Value: addr}, buf)
+}
+
+// CPURead6502 reads len(buf) bytes at addr&0x7fff with M2 high and
+// /ROMSEL high, which is how the mapper enables W-RAM at 0x6000-0x7fff.
+func (c *Client) CPURead6502(addr uint16, buf []byte) error {
+	return c.read(Message{Request: REQ_CPU_READ_6502, Value: addr}, buf)
+}
+
+// CPUWrite6502 writes one byte with a single M2 cycle.
+// /ROMSEL is asserted for addr 0x8000-0xffff only, and there
+// A1-A7 are driven only with CAP_CPU_WRITE_ADDR, otherwise they are 0.
+func (c *Client) CPUWrite6502(addr uint16, data uint8) error {
+	return c.send(Message{Request: REQ_CPU_WRITE_6502, Value: addr, Length: uint16(data)}, nil)
+}
+
+// CPUWrite5Bits shifts 5 bits LSB first into an MMC1 register.
+func (c *Client) CPUWrite5Bits(addr uint16, data uint8) error {
+	if data > 0x1f {
+		return &RequestError{REQ_CPU_WRITE_6502_5BITS, addr, fmt.Errorf("%w: %02x", ErrInvalidValue, data)}
+	}
+	return c.send(Message{Request: REQ_CPU_WRITE_6502_5BITS, Value: addr, Length: uint16(data)}, nil)
+}
+
+// PPURead reads len(buf) bytes of CHR at addr&0x1fff.
+func (c *Client) PPURead(addr uint16, buf []byte) error {
+	return c.read(Message{Request: REQ_PPU_READ, Value: addr}, buf)
+}
+
+func (c *Client) CPUWriteEEP(addr uint16, data []byte) error {
+	return c.write(Message{Request: REQ_CPU_WRITE_EEP, Value: addr}, data)
+}
+
+func (c *Client) PPUWriteEEP(addr uint16, data []byte) error {
+	return c.write(Message{Request: REQ_PPU_WRITE_EEP, Value: addr}, data)
+}
+
+// CPUWriteFlash programs data at the 15-bit PRG address addr15.
+// The firmware erases the 4KB sector first if addr15 is on its boundary.
+func (c *Client) CPUWriteFlash(addr15 uint16, data []byte) error {
+	return c.write(Message{Request: REQ_CPU_WRITE_FLASH, Value: addr15}, data)
+}
+
+// PPUWriteFlash programs data at the CHR address addr&0x1fff, with the
+// flash /WE on the EEPROM WE wire. As with CPUWriteFlash the 4KB sector
+// is erased first if addr is on its boundary.
+func (c *Client) PPUWriteFlash(addr uint16, data []byte) error {
+	return c.write(Message{Request: REQ_PPU_WRITE, Value: addr}, data)
+}
+
+// RawRead reads at the 24-bit address addr, which must be 256-byte aligned.
+func (c *Client) RawRead(addr uint32, buf []byte) error {
+	v, err := rawValue(REQ_RAW_READ, addr)
+	if err != nil {
+		return err
+	}
+	return c.read(Message{Request: REQ_RAW_READ, Value: v}, buf)
+}
+
+func (c *Client) RawReadLo(addr uint16, buf []byte) error {
+	return c.read(Message{Request: REQ_RAW_READ_LO, Value: addr}, buf)
+}
+
+func (c *Client) RawReadWOCS(addr uint32, buf []byte) error {
+	v, err := rawValue(REQ_RAW_READ_WO_CS, addr)
+	if err != nil {
+		return err
+	}
+	return c.read(Message{Request: REQ_RAW_READ_WO_CS, Value: v}, buf)
+}
+
+func (c *Client) RawWrite(addr uint32, data []byte) error {
+	v, err := rawValue(REQ_RAW_WRITE, addr)
+	if err != nil {
+		return err
+	}
+	return c.write(Message{Request: REQ_RAW_WRITE, Value: v}, data)
+}
+
+func (c *Client) RawWriteLo(addr uint16, data []byte) error {
+	return c.write(Message{Request: REQ_RAW_WRITE_LO, Value: addr}, data)
+}
+
+func (c *Client) RawWriteWOCS(addr uint32, data []byte) error {
+	v, err := rawValue(REQ_RAW_WRITE_WO_CS, addr)
+	if err != nil {
+		return err
+	}
+	return c.write(Message{Request: REQ_RAW_WRITE_WO_CS, Value: v}, data)
+}
+
+func (c *Client) RawWriteLoWOCS(addr uint16, data []byte) error {
+	return c.write(Message{Request: REQ_RAW_WRITE_LO_WO_CS, Value: addr}, data)
+}
+
+// RawWriteEEP writes one page of a 28C EEPROM at addr, A0-A15, after the
+// software data protection cycles of sdp, INDEX_IMPLIED for none. The
+// firmware takes the next request once the write cycle has ended. With
+// no data, INDEX_SDP_DISABLE only unprotects the chip.
+func (c *Client) RawWriteEEP(addr uint16, sdp Index, data []byte) error {
+	return c.send(Message{Request: REQ_RAW_WRITE_EEP, Value: addr, Index: sdp, Length: uint16(len(data))}, data)
+}
+
+// EraseFlash erases the 4KB sector A18-12 of a raw flash chip.
+// sector 0xff erases the whole chip.
+func (c *Client) EraseFlash(sector uint8) error {
+	return c.send(Message{Request: REQ_RAW_ERASE_FLASH, Value: uint16(sector)}, nil)
+}
+
+func (c *Client) RawWriteFlash(addr uint32, data []byte) error {
+	v, err := rawValue(REQ_RAW_WRITE_FLASH, addr)
+	if err != nil {
+		return err
+	}
+	return c.write(Message{Request: REQ_RAW_WRITE_FLASH, Value: v}, data)
+}
+
+// RawProgramFlash programs a raw flash chip at the 24-bit address addr,
+// which must be 256-byte aligned, without erasing it first. 0xff bytes are skipped.
+func (c *Client) RawProgramFlash(addr uint32, data []byte) error {
+	v, err := rawValue(REQ_RAW_PROGRAM_FLASH, addr)
+	if err != nil {
+		return err
+	}
+	return c.write(Message{Request: REQ_RAW_PROGRAM_FLASH, Value: v}, data)
+}
@@ -0,0 +1,124 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// testLink is the serial line to a reader that has its replies ready:
+// what the Client writes goes to sent, reads come out of replies.
+type testLink struct {
+	sent    bytes.Buffer
+	replies bytes.Buffer
+}
+
+func (l *testLink) Write(b []byte) (int, error) { return l.sent.Write(b) }
+func (l *testLink) Read(b []byte) (int, error)  { return l.replies.Read(b) }
+
+func TestMessageRoundTrip(t *testing.T) {
+	m := Message{Request: REQ_CPU_WRITE_6502, Value: 0xc001, Index: 0x1234, Length: 0x400, _reserverd: 7}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{7, uint8(REQ_CPU_WRITE_6502), 0x01, 0xc0, 0x34, 0x12, 0x00, 0x04}
+	if !bytes.Equal(b, want) {
+		t.Errorf("got % x, want % x", b, want)
+	}
+	var got Message
+	err = got.UnmarshalBinary(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != m {
+		t.Errorf("got %+v, want %+v", got, m)
+	}
+	err = got.UnmarshalBinary(b[:7])
+	if !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("7 bytes: got %v, want ErrInvalidLength", err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	l := &testLink{}
+	l.replies.Write([]byte{1, 2, 3, 4})
+	c := NewClient(l)
+	buf := make([]byte, 4)
+	err := c.CPURead(0x1234, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0, uint8(REQ_CPU_READ), 0x34, 0x12, 0, 0, 4, 0}
+	if !bytes.Equal(l.sent.Bytes(), want) {
+		t.Errorf("sent % x, want % x", l.sent.Bytes(), want)
+	}
+	if !bytes.Equal(buf, []byte{1, 2, 3, 4}) {
+		t.Errorf("read % x", buf)
+	}
+}
+
+func TestReadLength(t *testing.T) {
+	l := &testLink{}
+	c := NewClient(l)
+	for _, n := range []int{0, PACKET_SIZE + 1} {
+		err := c.PPURead(0, make([]byte, n))
+		var r *RequestError
+		if !errors.As(err, &r) || r.Request != REQ_PPU_READ || !errors.Is(err, ErrInvalidLength) {
+			t.Errorf("%d bytes: got %v, want ErrInvalidLength", n, err)
+		}
+	}
+	if l.sent.Len() != 0 {
+		t.Errorf("sent % x", l.sent.Bytes())
+	}
+}
+
+func TestWritePayload(t *testing.T) {
+	l := &testLink{}
+	c := NewClient(l)
+	err := c.CPUWriteFlash(0x1000, []byte{0xaa, 0x55})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []byte{0, uint8(REQ_CPU_WRITE_FLASH), 0x00, 0x10, 0, 0, 2, 0, 0xaa, 0x55}
+	if !bytes.Equal(l.sent.Bytes(), want) {
+		t.Errorf("sent % x, want % x", l.sent.Bytes(), want)
+	}
+	err = c.CPUWriteFlash(0, make([]byte, PACKET_SIZE+1))
+	if !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("oversized payload: got %v, want ErrInvalidLength", err)
+	}
+}
+
+func TestInvalidValue(t *testing.T) {
+	c := NewClient(&testLink{})
+	err := c.RawRead(0x123456, make([]byte, 1))
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("unaligned raw address: got %v, want ErrInvalidValue", err)
+	}
+	err = c.CPUWrite5Bits(0x8000, 0x20)
+	if !errors.Is(err, ErrInvalidValue) {
+		t.Errorf("6 bits for MMC1: got %v, want ErrInvalidValue", err)
+	}
+}
+
+func TestEcho(t *testing.T) {
+	l := &testLink{}
+	c := NewClient(l)
+	reply := Message{Request: REQ_ECHO, Value: 0xbeef}
+	b, _ := reply.MarshalBinary()
+	l.replies.Write(b)
+	m, err := c.Echo(0xbeef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != reply {
+		t.Errorf("got %+v, want %+v", m, reply)
+	}
+
+	l.replies.Write(b)
+	_, err = c.Echo(0xcafe)
+	if !errors.Is(err, ErrEchoMismatch) {
+		t.Errorf("other value: got %v, want ErrEchoMismatch", err)
+	}
+}
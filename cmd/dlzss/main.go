@@ -7,6 +7,7 @@ import (
 	"os"
 
 	"github.com/blacktop/lzss"
+	"github.com/ysh86/FCflash/ines"
 )
 
 func main() {
@@ -54,14 +55,19 @@ func main() {
 	buf := make([]uint8, length)
 
 	// header
-	buf[0] = 'N'
-	buf[1] = 'E'
-	buf[2] = 'S'
-	buf[3] = 0x1a
-	buf[4] = uint8(prg)
-	buf[5] = uint8(chr)
-	buf[6] = uint8((mapper << 4) | mirror)
-	_, err = fdst.Write(buf[0:16])
+	h := ines.Header{
+		Mapper:            mapper,
+		PRGROM:            prg * 16 * 1024,
+		CHRROM:            chr * 8 * 1024,
+		PRGRAM:            8 * 1024,
+		VerticalMirroring: mirror&1 != 0,
+		Battery:           mirror&2 != 0,
+	}
+	header, err := h.MarshalBinary()
+	if err != nil {
+		panic(err)
+	}
+	_, err = fdst.Write(header)
 	if err != nil {
 		panic(err)
 	}
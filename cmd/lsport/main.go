@@ -0,0 +1,23 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/ysh86/FCflash"
+)
+
+func main() {
+	var (
+		baud    int
+		timeout time.Duration
+	)
+	flag.IntVar(&baud, "baud", 500000, "baud rate (the Arduino ignores it, the mbed needs 500000)")
+	flag.DurationVar(&timeout, "timeout", FCflash.PROBE_TIMEOUT, "wait this long for each probe reply")
+	flag.Parse()
+
+	for _, p := range FCflash.Discover(baud, timeout) {
+		fmt.Println(p)
+	}
+}
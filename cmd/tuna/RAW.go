@@ -1,32 +1,25 @@
 package main
 
 import (
-	"encoding/binary"
 	"fmt"
 	"io"
-	"os"
 
 	"github.com/ysh86/FCflash"
 )
 
-func dumpRAW(f io.Writer, s io.ReadWriter, size int, buf []uint8) (err error) {
-	for i := 0; i < size; i += FCflash.PACKET_SIZE {
-		buf[0] = 0 // _reserverd
-		buf[1] = uint8(FCflash.REQ_RAW_READ)
-		binary.LittleEndian.PutUint16(buf[2:4], uint16(i>>8))                  // Value
-		binary.LittleEndian.PutUint16(buf[4:6], uint16(FCflash.INDEX_IMPLIED)) // index
-		binary.LittleEndian.PutUint16(buf[6:8], FCflash.PACKET_SIZE)           // Length
-		_, err = s.Write(buf[0:8])
-		if err != nil {
-			return err
-		}
-
-		_, err = io.ReadFull(s, buf)
-		if err != nil {
-			return err
+func dumpRAW(f io.Writer, c *FCflash.Client, size int, buf []uint8) (err error) {
+	p, err := c.NewPipeline(f)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := p.Close(); err == nil {
+			err = cerr
 		}
+	}()
 
-		_, err = f.Write(buf)
+	for i := 0; i < size; i += FCflash.PACKET_SIZE {
+		err = p.RawRead(uint32(i), len(buf))
 		if err != nil {
 			return err
 		}
@@ -34,57 +27,21 @@ func dumpRAW(f io.Writer, s io.ReadWriter, size int, buf []uint8) (err error) {
 	return nil
 }
 
-func writeFlash(s io.Writer, fileName string, size int, buf []uint8) error {
-	f, err := os.Open(fileName)
+// writeFlash programs r into the flash chip in the raw socket: erase,
+// blank check, then program and verify.
+func writeFlash(f *FCflash.RawFlash, r *io.SectionReader) error {
+	size := int(r.Size())
+
+	fmt.Print("erase: ")
+	err := f.Erase(size)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	// skip header
-	_, err = f.Seek(16, io.SeekStart)
+	fmt.Println("blank check")
+	err = f.BlankCheck(size)
 	if err != nil {
 		return err
 	}
-
-	// erase automatically
-	/*
-		buf[0] = 0 // _reserverd
-		buf[1] = uint8(FCflash.REQ_RAW_ERASE_FLASH)
-		binary.LittleEndian.PutUint16(buf[2:4], 0xff)                  // Value
-		binary.LittleEndian.PutUint16(buf[4:6], uint16(FCflash.INDEX_IMPLIED)) // index
-		binary.LittleEndian.PutUint16(buf[6:8], 0)                     // Length
-		_, err = s.Write(buf[0:8])
-		if err != nil {
-			return err
-		}
-		fmt.Println("erased")
-	*/
-
-	for i := 0; i < size; i += FCflash.PACKET_SIZE {
-		fmt.Printf(".")
-
-		buf[0] = 0 // _reserverd
-		buf[1] = uint8(FCflash.REQ_RAW_WRITE_FLASH)
-		binary.LittleEndian.PutUint16(buf[2:4], uint16(i>>8))                  // Value
-		binary.LittleEndian.PutUint16(buf[4:6], uint16(FCflash.INDEX_IMPLIED)) // index
-		binary.LittleEndian.PutUint16(buf[6:8], FCflash.PACKET_SIZE)           // Length
-		_, err = s.Write(buf[0:8])
-		if err != nil {
-			return err
-		}
-
-		_, err = io.ReadFull(f, buf)
-		if err != nil {
-			return err
-		}
-
-		_, err = s.Write(buf)
-		if err != nil {
-			return err
-		}
-	}
-	fmt.Println("")
-
-	return nil
+	fmt.Print("program: ")
+	return f.Program(r, size)
 }
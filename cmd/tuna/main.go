@@ -6,102 +6,228 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strconv"
+	"path/filepath"
+	"strings"
+	"time"
 
-	"github.com/tarm/serial"
 	"github.com/ysh86/FCflash"
+	"github.com/ysh86/FCflash/ines"
 )
 
 func main() {
+	// exit status, left for last so that the deferred closes flush -capture
+	status := 0
+	defer func() {
+		if status != 0 {
+			os.Exit(status)
+		}
+	}()
+
 	// args
 	var (
+		port     string
+		capture  string
 		com      int
 		baud     int
 		mapper   int
 		prg      int
 		chr      int
 		mirror   int
+		wram     int
+		save     bool
+		detect   bool
+		probe    bool
 		raw      bool
 		eeprom   bool
 		flash    bool
+		rom      string
+		chip     string
+		sdp      bool
+		timeout  time.Duration
+		retries  int
+		wretries int
 		fileName string
 	)
+	flag.StringVar(&port, "port", "", "serial device path, auto, tcp://<host>:<port>, replay:<capture file> or sim:<file.nes>[,sst39sf040|am29f040|at28c64|at28c256] (default /dev/ttyS<com>)")
+	flag.StringVar(&capture, "capture", "", "record the serial traffic into this file")
 	flag.IntVar(&com, "com", 5, "com port")
 	flag.IntVar(&baud, "baud", 115200, "baud rate")
-	flag.IntVar(&mapper, "mapper", 1, "mapper 0:NROM, 1:SxROM, 4:TxROM")
+	flag.DurationVar(&timeout, "timeout", FCflash.DEFAULT_TIMEOUT, "give up a request after this long (0: wait forever)")
+	flag.IntVar(&retries, "retry", 0, "resync and retry a timed out read this many times")
+	flag.IntVar(&wretries, "wretry", 2, "write a packet again this many times when it does not read back")
+	flag.IntVar(&mapper, "mapper", 1, "iNES mapper number "+mapperList())
 	flag.IntVar(&prg, "prg", 16, "Size of PRG ROM in 16KB units")
 	flag.IntVar(&chr, "chr", 0, "Size of CHR ROM in 8KB units (Value 0 means the board uses CHR RAM)")
 	flag.IntVar(&mirror, "mirror", 2, "0:H, 1:V, 2:battery-backed PRG RAM")
+	flag.IntVar(&wram, "wram", 1, "Size of battery-backed PRG RAM in 8KB units")
+	flag.BoolVar(&save, "save", false, "write the .sav next to the file into battery-backed PRG RAM")
+	flag.BoolVar(&detect, "detect", true, "measure the PRG/CHR ROM sizes of a dump instead of trusting -prg and -chr")
+	flag.BoolVar(&probe, "probe", false, "identify the mapper by its registers and use the best guess instead of -mapper")
 	flag.BoolVar(&raw, "raw", false, "raw access to ROM/RAM/EEPROM/Flash ICs")
-	flag.BoolVar(&eeprom, "eeprom", false, "write NROM EEPROM")
+	flag.BoolVar(&eeprom, "eeprom", false, "write EEPROM")
 	flag.BoolVar(&flash, "flash", false, "write Flash")
+	flag.StringVar(&rom, "rom", "both", "raw writes: the part of the image for the chip, prg, chr or both back to back")
+	flag.StringVar(&chip, "chip", "", "raw EEPROM: 28C64 or 28C256 (default: the smallest that holds -rom)")
+	flag.BoolVar(&sdp, "sdp", true, "raw EEPROM: write with software data protection, which leaves it on")
 	flag.Parse()
 	args := flag.Args()
 	if len(args) < 1 {
 		panic(errors.New("no file name"))
 	}
 	fileName = args[0]
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
 
 	// COM
-	comport := "/dev/ttyS" + strconv.Itoa(com)
-	c := &serial.Config{Name: comport, Baud: baud}
-	s, err := serial.OpenPort(c)
+	comport, err := FCflash.ResolvePort(port, com, baud, FCflash.FIRMWARE_AVR)
 	if err != nil {
 		panic(err)
 	}
-	defer s.Close()
+	t, err := FCflash.OpenPort(comport, baud)
+	if err != nil {
+		panic(err)
+	}
+	if capture != "" {
+		t, err = FCflash.Capture(t, capture)
+		if err != nil {
+			panic(err)
+		}
+	}
+	defer t.Close()
 
 	// start
+	c := FCflash.NewClient(t)
+	c.Timeout = timeout
+	c.Retries = retries
+	c.WriteRetries = wretries
+	info, err := c.Handshake()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("firmware: %v\n", info)
 	buf := make([]uint8, FCflash.PACKET_SIZE)
 
 	// EEPROM
 	if eeprom {
 		if raw {
 			// RAW EEPROM
-			panic(errors.New("write RAW EEPROM is NOT implemented"))
+			img, err := openImage(fileName)
+			if err != nil {
+				panic(err)
+			}
+			defer img.Close()
+			r, err := img.raw(rom)
+			if err != nil {
+				panic(err)
+			}
+			var ec FCflash.EEPROMChip
+			if chip != "" {
+				ec, err = FCflash.LookupEEPROMChip(chip)
+			} else {
+				ec, err = FCflash.EEPROMChipFor(int(r.Size()))
+			}
+			if err != nil {
+				panic(err)
+			}
+			if r.Size() > int64(ec.Size) {
+				panic(fmt.Errorf("%d [KB] does not fit in %v", r.Size()/1024, ec))
+			}
+			fmt.Printf("write RAW EEPROM: %v, %s\n", img.h, rom)
+			fmt.Println("chip:", ec)
+			fmt.Println("----")
+			fmt.Println("ready?")
+			io.ReadAtLeast(os.Stdin, buf[0:1], 1)
+			err = FCflash.NewRawEEPROM(c, ec).Program(r, int(r.Size()), sdp)
+			status = verified(err)
+			return
 		}
-		if mapper != 0 {
-			panic(fmt.Errorf("write mapper:%d EEPROM is NOT implemented", mapper))
+		img, err := openImage(fileName)
+		if err != nil {
+			panic(err)
 		}
-		fmt.Printf("write NROM EEPROM: prg:%d, chr:%d\n", prg, chr)
-		fmt.Println("----")
-		fmt.Println("ready?")
-		io.ReadAtLeast(os.Stdin, buf[0:1], 1)
-		err := writeEEPROM(s, fileName, prg, chr, buf)
+		defer img.Close()
+		fc, err := newWriter(c, img.h)
 		if err != nil {
 			panic(err)
 		}
-		fmt.Println("done")
+		fmt.Printf("write %s EEPROM: %v\n", fc.Mapper.Name(), img.h)
+		fmt.Println("----")
+		fmt.Println("ready?")
+		io.ReadAtLeast(os.Stdin, buf[0:1], 1)
+		err = writeCart(fc, img)
+		status = verified(err)
 		return
 	}
 
 	// Flash
 	if flash {
 		if raw {
-			fmt.Printf("write RAW Flash: prg:%d, chr:%d\n", prg, chr)
-			fmt.Println("----")
-			fmt.Println("ready?")
-			io.ReadAtLeast(os.Stdin, buf[0:1], 1)
-			size := prg*16*1024 + chr*8*1024
-			err := writeFlash(s, fileName, size, buf)
+			img, err := openImage(fileName)
+			if err != nil {
+				panic(err)
+			}
+			defer img.Close()
+			r, err := img.raw(rom)
+			if err != nil {
+				panic(err)
+			}
+			rf, err := FCflash.DetectRawFlash(c)
 			if err != nil {
 				panic(err)
 			}
-			fmt.Println("done")
+			if r.Size() > int64(rf.Chip.Size) {
+				panic(fmt.Errorf("%d [KB] does not fit in %v", r.Size()/1024, rf.Chip))
+			}
+			fmt.Printf("write RAW Flash: %v, %s\n", img.h, rom)
+			fmt.Println("chip:", rf.Chip)
+			fmt.Println("----")
+			fmt.Println("ready?")
+			io.ReadAtLeast(os.Stdin, buf[0:1], 1)
+			err = writeFlash(rf, r)
+			status = verified(err)
 			return
 		}
 		// Flash with mapper
-		if mapper != 1 {
-			panic(fmt.Errorf("write mapper:%d Flash is NOT implemented", mapper))
+		img, err := openImage(fileName)
+		if err != nil {
+			panic(err)
+		}
+		defer img.Close()
+		fc, err := newWriter(c, img.h)
+		if err != nil {
+			panic(err)
+		}
+		fmt.Printf("write %s: %v\n", fc.Mapper.Name(), img.h)
+		fmt.Println("----")
+		fmt.Println("ready?")
+		io.ReadAtLeast(os.Stdin, buf[0:1], 1)
+		err = writeCart(fc, img)
+		status = verified(err)
+		return
+	}
+
+	// save RAM
+	if save {
+		m, err := FCflash.LookupMapper(mapper)
+		if err != nil {
+			panic(err)
+		}
+		fc := FCflash.NewFCClient(c, m)
+		name := savName(fileName)
+		sav, err := os.Open(name)
+		if err != nil {
+			panic(err)
 		}
-		if chr > 0 {
-			panic(errors.New("write CHR Flash is NOT implemented"))
+		defer sav.Close()
+		fi, err := sav.Stat()
+		if err != nil {
+			panic(err)
 		}
-		fmt.Printf("write SxROM: prg:%d, chr:%d\n", prg, chr)
+		fmt.Printf("write %s save RAM: %s %d [KB]\n", m.Name(), name, fi.Size()/1024)
 		fmt.Println("----")
 		fmt.Println("ready?")
 		io.ReadAtLeast(os.Stdin, buf[0:1], 1)
-		err := writeSxromPRG(s, fileName, prg, buf)
+		err = fc.WriteRAM(sav, int(fi.Size()))
 		if err != nil {
 			panic(err)
 		}
@@ -109,7 +235,78 @@ func main() {
 		return
 	}
 
+	// mapper
+	if probe && !raw {
+		guesses, err := FCflash.ProbeMapper(c)
+		if err != nil {
+			panic(err)
+		}
+		for i, g := range guesses {
+			if i == 3 || g.Score == 0 {
+				break
+			}
+			fmt.Printf("probe: %d %s %.0f%%\n", g.Mapper, g.Name, g.Score*100)
+		}
+		if !c.Supports(FCflash.REQ_PHI2_INIT) && !c.Supports(FCflash.REQ_CPU_READ_6502) {
+			fmt.Println("probe: MMC5 skip (firmware)")
+		}
+		if set["mapper"] && mapper != guesses[0].Mapper {
+			fmt.Printf("probe: -mapper %d disagrees with the cartridge\n", mapper)
+		}
+		mapper = guesses[0].Mapper
+	}
+
 	// dump
+	var fc *FCflash.FC
+	if !raw {
+		m, err := FCflash.LookupMapper(mapper)
+		if err != nil {
+			panic(err)
+		}
+		fc = FCflash.NewFCClient(c, m)
+	}
+
+	// board revision
+	nes2, submapper := false, 0
+	if !raw {
+		n, sub, err := fc.DetectBoard()
+		if err == nil {
+			fmt.Printf("board: %s, mapper %d.%d\n", fc.Mapper.Name(), n, sub)
+			if set["mapper"] && n != mapper {
+				fmt.Printf("board: -mapper %d disagrees with the cartridge\n", mapper)
+			}
+			nes2, mapper, submapper = true, n, sub
+		} else if !errors.Is(err, FCflash.ErrNotImplemented) {
+			panic(err)
+		}
+	}
+
+	// size
+	if !raw && detect {
+		n, err := measure("PRG", prg, set["prg"], fc.DetectPRG)
+		if err != nil {
+			panic(err)
+		}
+		prg = n
+		n, err = measure("CHR", chr, set["chr"], fc.DetectCHR)
+		if err != nil {
+			panic(err)
+		}
+		// on banked boards 8KB of CHR RAM reads like 8KB of CHR ROM
+		if n != 1 || fc.Mapper.Layout().MaxCHR == 1 {
+			chr = n
+		} else if chr != 1 {
+			fmt.Println("CHR: 8KB of ROM or RAM, keeping -chr", chr)
+		}
+	}
+	ram := 0
+	if mirror&2 != 0 {
+		ram = wram * 8 * 1024
+	}
+	if probe && !raw {
+		fmt.Println("probe: board", FCflash.BoardClass(mapper, prg, chr, ram))
+	}
+
 	f, err := os.Create(fileName)
 	if err != nil {
 		panic(err)
@@ -117,15 +314,27 @@ func main() {
 	defer f.Close()
 
 	// header
-	buf[0] = 'N'
-	buf[1] = 'E'
-	buf[2] = 'S'
-	buf[3] = 0x1a
-	buf[4] = uint8(prg)
-	buf[5] = uint8(chr)
-	buf[6] = uint8((mapper << 4) | mirror)
+	h := ines.Header{
+		Mapper:            mapper,
+		PRGROM:            prg * 16 * 1024,
+		CHRROM:            chr * 8 * 1024,
+		PRGRAM:            wram * 8 * 1024,
+		VerticalMirroring: mirror&1 != 0,
+		Battery:           mirror&2 != 0,
+	}
+	if nes2 {
+		// the submapper tells the wiring
+		h.NES2, h.Submapper = true, submapper
+		if h.Battery {
+			h.PRGRAM, h.PRGNVRAM = 0, h.PRGRAM
+		}
+	}
 	if !raw {
-		_, err = f.Write(buf[0:16])
+		header, err := h.MarshalBinary()
+		if err != nil {
+			panic(err)
+		}
+		_, err = f.Write(header)
 		if err != nil {
 			panic(err)
 		}
@@ -133,7 +342,11 @@ func main() {
 
 	// info
 	fmt.Println("COM:", comport, "@", baud)
-	fmt.Println("Mapper:", mapper)
+	if raw {
+		fmt.Println("Mapper:", mapper)
+	} else {
+		fmt.Println("Mapper:", mapper, fc.Mapper.Name())
+	}
 	fmt.Println("PRG ROM:", prg*16, "[KB]")
 	fmt.Println("CHR ROM:", chr*8, "[KB]")
 	var m string
@@ -151,6 +364,9 @@ func main() {
 		}
 	}
 	fmt.Println("Mirroring:", m)
+	if mirror&2 != 0 {
+		fmt.Println("PRG RAM:", wram*8, "[KB]")
+	}
 	fmt.Println("----")
 	fmt.Println("ready?")
 	io.ReadAtLeast(os.Stdin, buf[0:1], 1)
@@ -159,7 +375,7 @@ func main() {
 	if raw {
 		fmt.Print("RAW: . . .")
 		size := prg*16*1024 + chr*8*1024
-		err = dumpRAW(f, s, size, buf)
+		err = dumpRAW(f, c, size, buf)
 		if err != nil {
 			panic(err)
 		}
@@ -167,18 +383,53 @@ func main() {
 		return
 	}
 
+	err = dumpCart(c, fc, f, fileName, prg, chr, ram)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// dumpCart writes PRG and CHR to f, after ram bytes of save RAM and the
+// ExRAM of the board to the files next to fileName.
+func dumpCart(c *FCflash.Client, fc *FCflash.FC, f io.Writer, fileName string, prg, chr, ram int) error {
+	// save RAM first, before anything else goes wrong
+	if ram != 0 {
+		if _, ok := fc.Mapper.(FCflash.SaveRAM); !ok {
+			fmt.Println("SAV: skip")
+		} else if !c.Supports(FCflash.REQ_CPU_READ_6502) {
+			fmt.Println("SAV: skip (firmware)")
+		} else {
+			fmt.Print("SAV: . . .")
+			err := dumpTo(savName(fileName), func(w io.Writer) error {
+				return fc.DumpRAM(w, ram)
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Println(" done")
+		}
+	}
+
+	// ExRAM
+	if _, ok := fc.Mapper.(FCflash.ExRAM); ok {
+		if !c.Supports(FCflash.REQ_CPU_READ_6502) {
+			fmt.Println("ExRAM: skip (firmware)")
+		} else {
+			fmt.Print("ExRAM: . . .")
+			err := dumpTo(exramName(fileName), fc.DumpExRAM)
+			if err != nil {
+				return err
+			}
+			fmt.Println(" done")
+		}
+	}
+
 	// PRG
 	if prg != 0 {
 		fmt.Print("PRG: . . .")
-		if mapper == 0 {
-			err = dumpNromPRG(f, s, prg, buf)
-		} else if mapper == 1 {
-			err = dumpSxromPRG(f, s, prg, buf)
-		} else {
-			err = dumpTxromPRG(f, s, prg, buf)
-		}
+		err := fc.DumpPRG(f, prg)
 		if err != nil {
-			panic(err)
+			return err
 		}
 		fmt.Println(" done")
 	} else {
@@ -188,18 +439,175 @@ func main() {
 	// CHR
 	if chr != 0 {
 		fmt.Print("CHR: . . .")
-		if mapper == 0 {
-			err = dumpNromCHR(f, s, chr, buf)
-		} else if mapper == 1 {
-			panic(fmt.Errorf("mapper:%d CHR is NOT implemented", mapper))
-		} else {
-			err = dumpTxromCHR(f, s, chr, buf)
-		}
+		err := fc.DumpCHR(f, chr)
 		if err != nil {
-			panic(err)
+			return err
 		}
 		fmt.Println(" done")
 	} else {
 		fmt.Println("CHR: skip")
 	}
+	return nil
+}
+
+func mapperList() string {
+	var l []string
+	for _, n := range FCflash.Mappers() {
+		m, _ := FCflash.LookupMapper(n)
+		l = append(l, fmt.Sprintf("%d:%s", n, m.Name()))
+	}
+	return strings.Join(l, ", ")
+}
+
+// measure reports a detected size, in the units of its flag, against the flag.
+func measure(what string, flagged int, set bool, detect func() (int, error)) (int, error) {
+	n, err := detect()
+	if errors.Is(err, FCflash.ErrNotImplemented) {
+		fmt.Println(what+": cannot be measured on this board, -"+strings.ToLower(what), flagged)
+		return flagged, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if set && n != flagged {
+		fmt.Printf("%s: -%s %d disagrees with the cartridge, measured %d\n", what, strings.ToLower(what), flagged, n)
+	}
+	return n, nil
+}
+
+// savName is the save RAM file next to an image.
+func savName(fileName string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".sav"
+}
+
+// exramName is the mapper RAM file next to an image.
+func exramName(fileName string) string {
+	return strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ".exram"
+}
+
+func dumpTo(name string, dump func(w io.Writer) error) error {
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	err = dump(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// image is an iNES file to be written to a cartridge.
+type image struct {
+	*os.File
+	h ines.Header
+}
+
+func openImage(fileName string) (*image, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	h, err := ines.Read(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", fileName, err)
+	}
+	return &image{f, h}, nil
+}
+
+func (img *image) prg() *io.SectionReader {
+	return io.NewSectionReader(img, img.h.PRGOffset(), int64(img.h.PRGROM))
+}
+
+func (img *image) chr() *io.SectionReader {
+	return io.NewSectionReader(img, img.h.CHROffset(), int64(img.h.CHRROM))
+}
+
+// raw is the part of the image that goes into a chip in the raw socket.
+func (img *image) raw(part string) (*io.SectionReader, error) {
+	var r *io.SectionReader
+	switch part {
+	case "prg":
+		r = img.prg()
+	case "chr":
+		r = img.chr()
+	case "both":
+		// PRG and CHR back to back, as in the file
+		r = io.NewSectionReader(img, img.h.PRGOffset(), int64(img.h.PRGROM+img.h.CHRROM))
+	default:
+		return nil, fmt.Errorf("-rom %s: not prg, chr or both", part)
+	}
+	if r.Size() == 0 {
+		return nil, fmt.Errorf("-rom %s: nothing in the image", part)
+	}
+	return r, nil
+}
+
+// newWriter picks the board of the image and fails before anything is
+// written if it cannot program all of it.
+func newWriter(c *FCflash.Client, h ines.Header) (*FCflash.FC, error) {
+	m, err := FCflash.LookupMapper(h.Mapper)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := m.(FCflash.PRGWriter); h.PRGROM > 0 && !ok {
+		return nil, fmt.Errorf("write %s PRG is NOT implemented", m.Name())
+	}
+	if _, ok := m.(FCflash.CHRWriter); h.CHRROM > 0 && !ok {
+		return nil, fmt.Errorf("write %s CHR is NOT implemented", m.Name())
+	}
+	return FCflash.NewFCClient(c, m), nil
+}
+
+// writeCart programs the PRG and CHR of an iNES image. The CHR is
+// written even if the PRG did not verify, and the mismatches of both returned.
+func writeCart(fc *FCflash.FC, img *image) error {
+	var mismatches []FCflash.Mismatch
+	collect := func(err error) error {
+		var v *FCflash.VerifyError
+		if errors.As(err, &v) {
+			mismatches = append(mismatches, v.Mismatches...)
+			return nil
+		}
+		return err
+	}
+	if img.h.PRGROM > 0 {
+		err := collect(fc.WritePRG(img.prg(), img.h.PRGROM/(16*1024)))
+		if err != nil {
+			return err
+		}
+	}
+	if img.h.CHRROM > 0 {
+		err := collect(fc.WriteCHR(img.chr(), img.h.CHRROM/(8*1024)))
+		if err != nil {
+			return err
+		}
+	}
+	if len(mismatches) > 0 {
+		return &FCflash.VerifyError{Mismatches: mismatches}
+	}
+	return nil
+}
+
+// verified ends a write mode: done, or the packets that did not read back and exit status 1.
+func verified(err error) int {
+	var v *FCflash.VerifyError
+	if errors.As(err, &v) {
+		fmt.Printf("verify: %d packets differ\n", len(v.Mismatches))
+		for _, m := range v.Mismatches {
+			fmt.Println("  ", m)
+		}
+		return 1
+	}
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println("done")
+	return 0
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ysh86/FCflash"
+	"github.com/ysh86/FCflash/ines"
+)
+
+// TestDumpCartOldFirmware dumps with the default -mirror 2, which asks
+// for the save RAM, from firmware that cannot read it.
+func TestDumpCartOldFirmware(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		h       ines.Header
+		version uint8
+		caps    FCflash.Caps
+		sav     bool
+	}{
+		{"SNROM v2", ines.Header{Mapper: 1, PRGROM: 256 * 1024, PRGRAM: 8 * 1024, Battery: true}, 2, FCflash.CAPS_LEGACY | FCflash.CAP_CPU_WRITE_ADDR, false},
+		{"TKROM unversioned", ines.Header{Mapper: 4, PRGROM: 128 * 1024, CHRROM: 128 * 1024, PRGRAM: 8 * 1024, Battery: true}, 0, FCflash.CAPS_LEGACY, false},
+		{"TKROM", ines.Header{Mapper: 4, PRGROM: 128 * 1024, CHRROM: 128 * 1024, PRGRAM: 8 * 1024, Battery: true}, FCflash.FIRMWARE_VERSION, FCflash.FIRMWARE_CAPS, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			header, err := tc.h.MarshalBinary()
+			if err != nil {
+				t.Fatal(err)
+			}
+			body := make([]byte, tc.h.PRGROM+tc.h.CHRROM)
+			rand.New(rand.NewSource(0)).Read(body)
+			v, err := FCflash.NewVirtualFC(append(header, body...))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rand.New(rand.NewSource(1)).Read(v.WRAM)
+			v.Version, v.Caps = tc.version, tc.caps
+
+			c := FCflash.NewClient(v)
+			_, err = c.Handshake()
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := FCflash.LookupMapper(tc.h.Mapper)
+			if err != nil {
+				t.Fatal(err)
+			}
+			fileName := filepath.Join(t.TempDir(), "cart.nes")
+			var b bytes.Buffer
+			err = dumpCart(c, FCflash.NewFCClient(c, m), &b, fileName, tc.h.PRGROM/(16*1024), tc.h.CHRROM/(8*1024), 8*1024)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b.Bytes(), body) {
+				t.Errorf("PRG/CHR differ")
+			}
+
+			sav, err := os.ReadFile(savName(fileName))
+			if !tc.sav {
+				if err == nil {
+					t.Errorf(".sav written")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(sav, v.WRAM) {
+				t.Errorf(".sav differs from the W-RAM")
+			}
+		})
+	}
+}
@@ -7,39 +7,17 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strconv"
 
-	"github.com/tarm/serial"
+	"github.com/ysh86/FCflash"
 )
 
-const PACKET_SIZE = 0x10000
-
-type Request uint32
-
-const (
-	REQ_READ16 Request = iota + 0
-	REQ_WRITE16
-	REQ_WRITE16_RND
-	REQ_READ8_CS2
-	REQ_WRITE8_CS2
-	REQ_WRITE8_CS2_RND
-)
-const (
-	REQ_CPU_READ Request = iota + 16
-	REQ_CPU_WRITE
-	REQ_PPU_READ
-)
-
-type Message struct {
-	Request  Request
-	Value    uint32
-	Length   uint32
-	reserved uint32
-}
+const PACKET_SIZE = FCflash.MBED_PACKET_SIZE
 
 func main() {
 	// args
 	var (
+		port     string
+		capture  string
 		com      int
 		baud     int
 		ram      bool
@@ -49,6 +27,8 @@ func main() {
 		fileName string
 		ramName  string
 	)
+	flag.StringVar(&port, "port", "", "serial device path, auto, tcp://<host>:<port>, replay:<capture file> or sim:<file.gba>[,<file.sav>], sim:<file.nes>,mbed (default /dev/ttyS<com>)")
+	flag.StringVar(&capture, "capture", "", "record the serial traffic into this file")
 	flag.IntVar(&com, "com", 7, "com port")
 	flag.IntVar(&baud, "baud", 500000, "baud rate")
 	flag.BoolVar(&ram, "ram", false, "write RAM in cartridge")
@@ -72,13 +52,28 @@ func main() {
 	}
 
 	// COM
-	comport := "/dev/ttyS" + strconv.Itoa(com)
-	c := &serial.Config{Name: comport, Baud: baud}
-	s, err := serial.OpenPort(c)
+	comport, err := FCflash.ResolvePort(port, com, baud, FCflash.FIRMWARE_MBED)
+	if err != nil {
+		panic(err)
+	}
+	s, err := FCflash.OpenPort(comport, baud)
 	if err != nil {
 		panic(err)
 	}
+	if capture != "" {
+		s, err = FCflash.Capture(s, capture)
+		if err != nil {
+			panic(err)
+		}
+	}
 	defer s.Close()
+	info, err := FCflash.Identify(s, FCflash.PROBE_TIMEOUT)
+	if err != nil {
+		panic(err)
+	}
+	if info.Firmware != FCflash.FIRMWARE_MBED {
+		panic(fmt.Errorf("%v answers instead of %v", info, FCflash.FIRMWARE_MBED))
+	}
 
 	// flash
 	if flash {
@@ -124,10 +119,10 @@ func main() {
 	for addr25 := uint32(0); addr25 < romSize; addr25 += PACKET_SIZE {
 		fmt.Print(".")
 
-		binary.LittleEndian.PutUint32(buf[0:4], uint32(REQ_READ16)) // Request
-		binary.LittleEndian.PutUint32(buf[4:8], (addr25 >> 1))      // Value
-		binary.LittleEndian.PutUint32(buf[8:12], PACKET_SIZE)       // Length
-		binary.LittleEndian.PutUint32(buf[12:16], 0)                // reserved
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(FCflash.MBED_REQ_READ16)) // Request
+		binary.LittleEndian.PutUint32(buf[4:8], (addr25 >> 1))                   // Value
+		binary.LittleEndian.PutUint32(buf[8:12], PACKET_SIZE)                    // Length
+		binary.LittleEndian.PutUint32(buf[12:16], 0)                             // reserved
 		_, err := s.Write(buf[0:16])
 		if err != nil {
 			panic(err)
@@ -178,45 +173,45 @@ func dumpTxromPRG(f io.Writer, s io.ReadWriter, prg int, buf []uint8) (err error
 	for bank := 0; bank < banks; bank += 2 {
 		// MMC3: PRG ROM R6:$8000-$9FFF swappable
 		bankSelect := uint32(0b00000110)
-		binary.LittleEndian.PutUint32(buf[0:4], uint32(REQ_CPU_WRITE)) // Request
-		binary.LittleEndian.PutUint32(buf[4:8], 0x8000)                // Value
-		binary.LittleEndian.PutUint32(buf[8:12], bankSelect)           // Length
-		binary.LittleEndian.PutUint32(buf[12:16], 0)                   // reserved
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(FCflash.MBED_REQ_CPU_WRITE)) // Request
+		binary.LittleEndian.PutUint32(buf[4:8], 0x8000)                             // Value
+		binary.LittleEndian.PutUint32(buf[8:12], bankSelect)                        // Length
+		binary.LittleEndian.PutUint32(buf[12:16], 0)                                // reserved
 		_, err = s.Write(buf[0:16])
 		if err != nil {
 			return err
 		}
-		binary.LittleEndian.PutUint32(buf[0:4], uint32(REQ_CPU_WRITE)) // Request
-		binary.LittleEndian.PutUint32(buf[4:8], 0x8001)                // Value
-		binary.LittleEndian.PutUint32(buf[8:12], uint32(bank+0))       // Length
-		binary.LittleEndian.PutUint32(buf[12:16], 0)                   // reserved
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(FCflash.MBED_REQ_CPU_WRITE)) // Request
+		binary.LittleEndian.PutUint32(buf[4:8], 0x8001)                             // Value
+		binary.LittleEndian.PutUint32(buf[8:12], uint32(bank+0))                    // Length
+		binary.LittleEndian.PutUint32(buf[12:16], 0)                                // reserved
 		_, err = s.Write(buf[0:16])
 		if err != nil {
 			return err
 		}
 		// MMC3: PRG ROM R7:$A000-$BFFF swappable
 		bankSelect = uint32(0b00000111)
-		binary.LittleEndian.PutUint32(buf[0:4], uint32(REQ_CPU_WRITE)) // Request
-		binary.LittleEndian.PutUint32(buf[4:8], 0x8000)                // Value
-		binary.LittleEndian.PutUint32(buf[8:12], bankSelect)           // Length
-		binary.LittleEndian.PutUint32(buf[12:16], 0)                   // reserved
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(FCflash.MBED_REQ_CPU_WRITE)) // Request
+		binary.LittleEndian.PutUint32(buf[4:8], 0x8000)                             // Value
+		binary.LittleEndian.PutUint32(buf[8:12], bankSelect)                        // Length
+		binary.LittleEndian.PutUint32(buf[12:16], 0)                                // reserved
 		_, err = s.Write(buf[0:16])
 		if err != nil {
 			return err
 		}
-		binary.LittleEndian.PutUint32(buf[0:4], uint32(REQ_CPU_WRITE)) // Request
-		binary.LittleEndian.PutUint32(buf[4:8], 0x8001)                // Value
-		binary.LittleEndian.PutUint32(buf[8:12], uint32(bank+1))       // Length
-		binary.LittleEndian.PutUint32(buf[12:16], 0)                   // reserved
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(FCflash.MBED_REQ_CPU_WRITE)) // Request
+		binary.LittleEndian.PutUint32(buf[4:8], 0x8001)                             // Value
+		binary.LittleEndian.PutUint32(buf[8:12], uint32(bank+1))                    // Length
+		binary.LittleEndian.PutUint32(buf[12:16], 0)                                // reserved
 		_, err = s.Write(buf[0:16])
 		if err != nil {
 			return err
 		}
 
-		binary.LittleEndian.PutUint32(buf[0:4], uint32(REQ_CPU_READ)) // Request
-		binary.LittleEndian.PutUint32(buf[4:8], 0x8000)               // Value
-		binary.LittleEndian.PutUint32(buf[8:12], uint32(packetSize))  // Length
-		binary.LittleEndian.PutUint32(buf[12:16], 0)                  // reserved
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(FCflash.MBED_REQ_CPU_READ)) // Request
+		binary.LittleEndian.PutUint32(buf[4:8], 0x8000)                            // Value
+		binary.LittleEndian.PutUint32(buf[8:12], uint32(packetSize))               // Length
+		binary.LittleEndian.PutUint32(buf[12:16], 0)                               // reserved
 		_, err = s.Write(buf[0:16])
 		if err != nil {
 			return err
@@ -242,27 +237,27 @@ func dumpTxromCHR(f io.Writer, s io.ReadWriter, chr int, buf []uint8) (err error
 	for bank := 0; bank < banks; bank += 2 {
 		// MMC3: CHR ROM R0:$0000-$07FF swappable
 		bankSelect := uint32(0b00000000)
-		binary.LittleEndian.PutUint32(buf[0:4], uint32(REQ_CPU_WRITE)) // Request
-		binary.LittleEndian.PutUint32(buf[4:8], 0x8000)                // Value
-		binary.LittleEndian.PutUint32(buf[8:12], bankSelect)           // Length
-		binary.LittleEndian.PutUint32(buf[12:16], 0)                   // reserved
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(FCflash.MBED_REQ_CPU_WRITE)) // Request
+		binary.LittleEndian.PutUint32(buf[4:8], 0x8000)                             // Value
+		binary.LittleEndian.PutUint32(buf[8:12], bankSelect)                        // Length
+		binary.LittleEndian.PutUint32(buf[12:16], 0)                                // reserved
 		_, err = s.Write(buf[0:16])
 		if err != nil {
 			return err
 		}
-		binary.LittleEndian.PutUint32(buf[0:4], uint32(REQ_CPU_WRITE)) // Request
-		binary.LittleEndian.PutUint32(buf[4:8], 0x8001)                // Value
-		binary.LittleEndian.PutUint32(buf[8:12], uint32(bank))         // Length
-		binary.LittleEndian.PutUint32(buf[12:16], 0)                   // reserved
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(FCflash.MBED_REQ_CPU_WRITE)) // Request
+		binary.LittleEndian.PutUint32(buf[4:8], 0x8001)                             // Value
+		binary.LittleEndian.PutUint32(buf[8:12], uint32(bank))                      // Length
+		binary.LittleEndian.PutUint32(buf[12:16], 0)                                // reserved
 		_, err = s.Write(buf[0:16])
 		if err != nil {
 			return err
 		}
 
-		binary.LittleEndian.PutUint32(buf[0:4], uint32(REQ_PPU_READ)) // Request
-		binary.LittleEndian.PutUint32(buf[4:8], 0)                    // Value
-		binary.LittleEndian.PutUint32(buf[8:12], uint32(packetSize))  // Length
-		binary.LittleEndian.PutUint32(buf[12:16], 0)                  // reserved
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(FCflash.MBED_REQ_PPU_READ)) // Request
+		binary.LittleEndian.PutUint32(buf[4:8], 0)                                 // Value
+		binary.LittleEndian.PutUint32(buf[8:12], uint32(packetSize))               // Length
+		binary.LittleEndian.PutUint32(buf[12:16], 0)                               // reserved
 		_, err = s.Write(buf[0:16])
 		if err != nil {
 			return err
@@ -286,19 +281,19 @@ func dumpAxromPRG(f io.Writer, s io.ReadWriter, prg int, buf []uint8) (err error
 
 	banks := (prg * 16 * 1024) >> 15
 	for bank := 0; bank < banks; bank++ {
-		binary.LittleEndian.PutUint32(buf[0:4], uint32(REQ_CPU_WRITE)) // Request
-		binary.LittleEndian.PutUint32(buf[4:8], 0x8000)                // Value
-		binary.LittleEndian.PutUint32(buf[8:12], uint32(bank))         // Length
-		binary.LittleEndian.PutUint32(buf[12:16], 0)                   // reserved
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(FCflash.MBED_REQ_CPU_WRITE)) // Request
+		binary.LittleEndian.PutUint32(buf[4:8], 0x8000)                             // Value
+		binary.LittleEndian.PutUint32(buf[8:12], uint32(bank))                      // Length
+		binary.LittleEndian.PutUint32(buf[12:16], 0)                                // reserved
 		_, err = s.Write(buf[0:16])
 		if err != nil {
 			return err
 		}
 
-		binary.LittleEndian.PutUint32(buf[0:4], uint32(REQ_CPU_READ)) // Request
-		binary.LittleEndian.PutUint32(buf[4:8], 0x8000)               // Value
-		binary.LittleEndian.PutUint32(buf[8:12], uint32(packetSize))  // Length
-		binary.LittleEndian.PutUint32(buf[12:16], 0)                  // reserved
+		binary.LittleEndian.PutUint32(buf[0:4], uint32(FCflash.MBED_REQ_CPU_READ)) // Request
+		binary.LittleEndian.PutUint32(buf[4:8], 0x8000)                            // Value
+		binary.LittleEndian.PutUint32(buf[8:12], uint32(packetSize))               // Length
+		binary.LittleEndian.PutUint32(buf[12:16], 0)                               // reserved
 		_, err = s.Write(buf[0:16])
 		if err != nil {
 			return err
@@ -6,25 +6,32 @@ import (
 	"fmt"
 	"io"
 	"os"
-	"strconv"
+	"time"
 
-	"github.com/tarm/serial"
 	"github.com/ysh86/FCflash"
 )
 
 func main() {
 	// args
 	var (
+		port     string
+		capture  string
 		com      int
 		baud     int
 		ram      bool
 		flash    bool
 		all      bool
+		timeout  time.Duration
+		retries  int
 		fileName string
 		ramName  string
 	)
+	flag.StringVar(&port, "port", "", "serial device path, auto, tcp://<host>:<port>, replay:<capture file> or sim:<file.gb>[,am29f016|m29f160ft] (default /dev/ttyS<com>)")
+	flag.StringVar(&capture, "capture", "", "record the serial traffic into this file")
 	flag.IntVar(&com, "com", 5, "com port")
 	flag.IntVar(&baud, "baud", 115200, "baud rate")
+	flag.DurationVar(&timeout, "timeout", FCflash.DEFAULT_TIMEOUT, "give up a request after this long (0: wait forever)")
+	flag.IntVar(&retries, "retry", 0, "resync and retry a timed out read this many times")
 	flag.BoolVar(&ram, "ram", false, "write RAM in cartridge")
 	flag.BoolVar(&flash, "flash", false, "write Flash")
 	flag.BoolVar(&all, "a", false, "dump both ROM & RAM")
@@ -45,16 +52,32 @@ func main() {
 	}
 
 	// COM
-	comport := "/dev/ttyS" + strconv.Itoa(com)
-	c := &serial.Config{Name: comport, Baud: baud}
-	s, err := serial.OpenPort(c)
+	comport, err := FCflash.ResolvePort(port, com, baud, FCflash.FIRMWARE_AVR)
 	if err != nil {
 		panic(err)
 	}
-	defer s.Close()
+	t, err := FCflash.OpenPort(comport, baud)
+	if err != nil {
+		panic(err)
+	}
+	if capture != "" {
+		t, err = FCflash.Capture(t, capture)
+		if err != nil {
+			panic(err)
+		}
+	}
+	defer t.Close()
 
 	// start
-	gb := FCflash.NewGB(s)
+	c := FCflash.NewClient(t)
+	c.Timeout = timeout
+	c.Retries = retries
+	info, err := c.Handshake()
+	if err != nil {
+		panic(err)
+	}
+	fmt.Printf("firmware: %v\n", info)
+	gb := FCflash.NewGBClient(c)
 
 	// ram
 	if ram {
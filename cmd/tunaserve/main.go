@@ -0,0 +1,48 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net"
+
+	"github.com/ysh86/FCflash"
+)
+
+func main() {
+	// args
+	var (
+		port   string
+		com    int
+		baud   int
+		listen string
+	)
+	flag.StringVar(&port, "port", "", "serial device path, auto or sim:<image> (default /dev/ttyS<com>)")
+	flag.IntVar(&com, "com", 5, "com port")
+	flag.IntVar(&baud, "baud", 500000, "baud rate (the Arduino ignores it, the mbed needs 500000)")
+	flag.StringVar(&listen, "listen", ":5555", "TCP address to serve the reader on")
+	flag.Parse()
+
+	// COM
+	comport, err := FCflash.ResolvePort(port, com, baud, FCflash.FIRMWARE_UNKNOWN)
+	if err != nil {
+		panic(err)
+	}
+	t, err := FCflash.OpenPort(comport, baud)
+	if err != nil {
+		panic(err)
+	}
+	defer t.Close()
+
+	// serve
+	l, err := net.Listen("tcp", listen)
+	if err != nil {
+		panic(err)
+	}
+	log.Printf("serving %s on %s", comport, l.Addr())
+	s := FCflash.NewServer(t)
+	s.Logf = log.Printf
+	err = s.Serve(l)
+	if err != nil {
+		panic(err)
+	}
+}
@@ -0,0 +1,323 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Discrete is a board whose bank register is a latch in discrete logic.
+// On most of them the PRG ROM keeps driving the data bus while the latch
+// is written, so the latch gets the AND of both: a bank number is written
+// where the ROM holds that very value.
+type Discrete struct {
+	name      string
+	prgBank   int    // bytes switched at $8000, 0 if PRG is fixed
+	fixedLast bool   // $C000-$FFFF is the last 16KB bank
+	prgMask   uint8  // register bits of the PRG bank
+	chrMask   uint8  // register bits of the 8KB CHR bank, 0 for CHR RAM
+	reg       uint16 // lowest address of the register
+	conflicts bool
+	maxPRG    int
+	maxCHR    int
+}
+
+func init() {
+	RegisterMapper(2, &Discrete{name: "UxROM", prgBank: 16 * 1024, fixedLast: true, prgMask: 0x0f, reg: 0x8000, conflicts: true, maxPRG: 16})
+	RegisterMapper(3, &Discrete{name: "CNROM", chrMask: 0x03, reg: 0x8000, conflicts: true, maxPRG: 2, maxCHR: 4})
+	// AOROM has no bus conflicts but ANROM and AMROM do
+	RegisterMapper(7, &Discrete{name: "AxROM", prgBank: 32 * 1024, prgMask: 0x07, reg: 0x8000, conflicts: true, maxPRG: 16})
+	RegisterMapper(11, &Discrete{name: "Color Dreams", prgBank: 32 * 1024, prgMask: 0x03, chrMask: 0xf0, reg: 0x8000, conflicts: true, maxPRG: 8, maxCHR: 16})
+	RegisterMapper(66, &Discrete{name: "GxROM", prgBank: 32 * 1024, prgMask: 0x30, chrMask: 0x03, reg: 0x8000, conflicts: true, maxPRG: 8, maxCHR: 4})
+	// BF909x: the ROM is disabled for writes, $8000-$9FFF is mirroring on BF9097
+	RegisterMapper(71, &Discrete{name: "Camerica", prgBank: 16 * 1024, fixedLast: true, prgMask: 0x0f, reg: 0xc000, maxPRG: 16})
+}
+
+func (d *Discrete) Name() string {
+	return d.name
+}
+
+func (d *Discrete) Layout() Layout {
+	l := Layout{PRGBank: d.prgBank, MaxPRG: d.maxPRG, MaxCHR: d.maxCHR}
+	if d.prgBank == 0 {
+		l.PRGBank = d.maxPRG * 16 * 1024
+	}
+	if d.chrMask != 0 {
+		l.CHRBank = 8 * 1024
+	}
+	return l
+}
+
+func (d *Discrete) DumpPRG(c *Client, w io.Writer, prg int) error {
+	if d.prgBank == 0 {
+		return NROM{}.DumpPRG(c, w, prg)
+	}
+
+	banks := prg * 16 * 1024 / d.prgBank
+	l := newLatch(d, c, banks)
+	for bank := 0; bank < banks; bank++ {
+		err := l.selectPRG(bank)
+		if err != nil {
+			return err
+		}
+
+		var b bytes.Buffer
+		err = pipe(c, io.MultiWriter(w, &b), func(p *Pipeline) error {
+			for i := 0; i < d.prgBank; i += PACKET_SIZE {
+				err := p.CPURead(0x8000|uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		l.loaded(bank, b.Bytes())
+	}
+	return nil
+}
+
+func (d *Discrete) DumpCHR(c *Client, w io.Writer, chr int) error {
+	if d.chrMask == 0 {
+		return ErrNotImplemented
+	}
+
+	l := newLatch(d, c, 0)
+	for bank := 0; bank < chr; bank++ {
+		err := l.selectCHR(bank, chr)
+		if err != nil {
+			return err
+		}
+
+		err = NROM{}.DumpCHR(c, w, 1)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Discrete) SamplePRG(c *Client, w io.Writer, offsets []int, n int) error {
+	if d.prgBank == 0 {
+		return NROM{}.SamplePRG(c, w, offsets, n)
+	}
+
+	l := newLatch(d, c, 0)
+	for _, o := range offsets {
+		err := l.selectPRG(o / d.prgBank)
+		if errors.Is(err, ErrInvalidValue) {
+			// no bank the ROM can select has the number: it is past the end
+			_, err = w.Write(bytes.Repeat([]byte{0xff}, n))
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		err = pipe(c, w, func(p *Pipeline) error {
+			return p.CPURead(uint16(o%d.prgBank), n)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Discrete) SampleCHR(c *Client, w io.Writer, offsets []int, n int) error {
+	if d.chrMask == 0 {
+		return ErrNotImplemented
+	}
+
+	l := newLatch(d, c, 0)
+	for _, o := range offsets {
+		err := l.selectCHR(o>>13, d.maxCHR)
+		if err != nil {
+			return err
+		}
+		err = NROM{}.SampleCHR(c, w, []int{o}, n)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// field extracts the register bits of mask from v.
+func field(v, mask uint8) int {
+	return int(v&mask) >> bits.TrailingZeros8(mask)
+}
+
+// fieldMask cuts mask down to the bits that count for n banks.
+func fieldMask(mask uint8, n int) uint8 {
+	if n <= 0 {
+		return mask
+	}
+	used := uint8(1<<bits.Len(uint(n-1)) - 1)
+	return mask & (used << bits.TrailingZeros8(mask))
+}
+
+// latch follows what a Discrete board maps at $8000-$FFFF while its
+// register is switched, so that each write can go where the ROM agrees.
+type latch struct {
+	d       *Discrete
+	c       *Client
+	prgMask uint8
+	addrs   []uint16 // where the register may be written
+	window  []byte   // $8000-$FFFF now, nil until read
+	cur     int      // PRG field of the register, -1 if unknown
+	banks   map[int][]byte
+	last    []byte // $C000-$FFFF of fixedLast boards
+}
+
+// newLatch starts from an unknown register. prgBanks is 0 if the PRG size is unknown.
+func newLatch(d *Discrete, c *Client, prgBanks int) *latch {
+	l := &latch{d: d, c: c, prgMask: fieldMask(d.prgMask, prgBanks), cur: -1, banks: map[int][]byte{}}
+	anyAddr := c.Caps()&CAP_CPU_WRITE_ADDR != 0
+	for a := uint32(d.reg); a <= 0xffff; a++ {
+		// older firmware leaves A1-A7 low
+		if anyAddr || a&0xfe == 0 {
+			l.addrs = append(l.addrs, uint16(a))
+		}
+	}
+	return l
+}
+
+// content is the window with PRG field f, nil if not known.
+func (l *latch) content(f int) []byte {
+	switch {
+	case l.d.prgBank == 0:
+		return l.window
+	case l.banks[f] == nil:
+		return nil
+	case l.d.fixedLast:
+		return append(append([]byte(nil), l.banks[f]...), l.last...)
+	}
+	return l.banks[f]
+}
+
+func (l *latch) readWindow() error {
+	var b bytes.Buffer
+	err := NROM{}.DumpPRG(l.c, &b, 2)
+	if err != nil {
+		return err
+	}
+	l.window = b.Bytes()
+	if l.d.fixedLast {
+		l.last = l.window[0x4000:]
+	}
+	if l.cur >= 0 && l.d.prgBank != 0 {
+		l.banks[l.cur] = l.window[:l.d.prgBank]
+	}
+	return nil
+}
+
+// loaded records the PRG bank just dumped through $8000.
+func (l *latch) loaded(f int, data []byte) {
+	l.banks[f] = data
+	if l.cur == f {
+		l.window = l.content(f)
+	}
+}
+
+func (l *latch) write(addr uint16, data uint8) error {
+	err := l.c.CPUWrite6502(addr, data)
+	if err != nil {
+		return err
+	}
+	if l.d.prgBank != 0 {
+		l.cur = field(data, l.prgMask)
+		l.window = l.content(l.cur)
+	}
+	return nil
+}
+
+func (l *latch) selectPRG(bank int) error {
+	if !l.d.conflicts {
+		return l.write(l.d.reg, uint8(bank<<bits.TrailingZeros8(l.d.prgMask)))
+	}
+	return l.choose("PRG", bank, func(v uint8) bool {
+		return field(v, l.prgMask) == bank
+	})
+}
+
+func (l *latch) selectCHR(bank, banks int) error {
+	mask := fieldMask(l.d.chrMask, banks)
+	if !l.d.conflicts {
+		return l.write(l.d.reg, uint8(bank<<bits.TrailingZeros8(mask)))
+	}
+	return l.choose("CHR", bank, func(v uint8) bool {
+		return field(v, mask) == bank
+	})
+}
+
+type latchWrite struct {
+	addr uint16
+	data uint8
+}
+
+// choose writes a ROM byte that wants a bank. If the window has none,
+// it goes through other PRG banks whose bytes do, reading the ones
+// not known yet.
+func (l *latch) choose(what string, bank int, want func(v uint8) bool) error {
+	type node struct {
+		content []byte
+		path    []latchWrite
+	}
+	for {
+		if l.window == nil {
+			err := l.readWindow()
+			if err != nil {
+				return err
+			}
+		}
+
+		seen := map[int]bool{l.cur: true}
+		queue := []node{{content: l.window}}
+		var unknown []latchWrite
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			for _, a := range l.addrs {
+				v := n.content[a-0x8000]
+				path := append(n.path[:len(n.path):len(n.path)], latchWrite{a, v})
+				if want(v) {
+					for _, w := range path {
+						err := l.write(w.addr, w.data)
+						if err != nil {
+							return err
+						}
+					}
+					return nil
+				}
+				f := field(v, l.prgMask)
+				if l.d.prgBank == 0 || seen[f] {
+					continue
+				}
+				seen[f] = true
+				if c := l.content(f); c != nil {
+					queue = append(queue, node{c, path})
+				} else if unknown == nil {
+					unknown = path
+				}
+			}
+		}
+
+		if unknown == nil {
+			return fmt.Errorf("%s: no ROM byte selects %s bank %d: %w", l.d.name, what, bank, ErrInvalidValue)
+		}
+		// look into a bank not seen yet and try again from there
+		for _, w := range unknown {
+			err := l.write(w.addr, w.data)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package FCflash
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ysh86/FCflash/ines"
+)
+
+// testHeaderImage is an image of h with random bytes from seed.
+func testHeaderImage(t *testing.T, h ines.Header, seed int64) []byte {
+	t.Helper()
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, h.PRGROM+h.CHRROM)
+	rand.New(rand.NewSource(seed)).Read(body)
+	return append(b, body...)
+}
+
+// testHandshakeFC is testFC after a Handshake, so that the mappers see the caps of the simulator.
+func testHandshakeFC(t *testing.T, img []byte) (*FC, *VirtualFC) {
+	t.Helper()
+	fc, v := testFC(t, img)
+	_, err := fc.c.Handshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fc, v
+}
+
+func testDump(t *testing.T, fc *FC, v *VirtualFC, prg, chr int) {
+	t.Helper()
+	var b bytes.Buffer
+	err := fc.DumpPRG(&b, prg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), v.PRG) {
+		t.Errorf("PRG differs")
+	}
+	if chr == 0 {
+		return
+	}
+	b.Reset()
+	err = fc.DumpCHR(&b, chr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), v.CHR) {
+		t.Errorf("CHR differs")
+	}
+}
+
+func TestDiscreteRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		h    ines.Header
+	}{
+		{"UNROM", ines.Header{Mapper: 2, PRGROM: 128 * 1024}},
+		{"UOROM no conflicts", ines.Header{NES2: true, Mapper: 2, Submapper: 2, PRGROM: 256 * 1024}},
+		{"CNROM", ines.Header{Mapper: 3, PRGROM: 32 * 1024, CHRROM: 32 * 1024}},
+		{"AOROM", ines.Header{Mapper: 7, PRGROM: 256 * 1024}},
+		{"ANROM", ines.Header{NES2: true, Mapper: 7, Submapper: 1, PRGROM: 128 * 1024}},
+		{"Color Dreams", ines.Header{Mapper: 11, PRGROM: 128 * 1024, CHRROM: 128 * 1024}},
+		{"GNROM", ines.Header{Mapper: 66, PRGROM: 128 * 1024, CHRROM: 32 * 1024}},
+		{"Camerica", ines.Header{Mapper: 71, PRGROM: 256 * 1024}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, v := testHandshakeFC(t, testHeaderImage(t, tc.h, int64(tc.h.Mapper)))
+			testDump(t, fc, v, tc.h.PRGROM/(16*1024), tc.h.CHRROM/(8*1024))
+		})
+	}
+}
+
+// TestLatchChoose has each 32KB bank hold nothing but the number of the
+// next one, so every bank switch goes through the banks in between.
+func TestLatchChoose(t *testing.T) {
+	h := ines.Header{NES2: true, Mapper: 7, Submapper: 1, PRGROM: 256 * 1024}
+	img := testHeaderImage(t, h, 0)
+	for bank := 0; bank < 8; bank++ {
+		prg := img[ines.HEADER_SIZE+bank*0x8000:][:0x8000]
+		for i := range prg {
+			prg[i] = uint8(bank+1) & 7
+		}
+	}
+	fc, v := testHandshakeFC(t, img)
+	testDump(t, fc, v, 16, 0)
+
+	// every bank is known now: 3 to 2 goes all the way round
+	l := newLatch(fc.Mapper.(*Discrete), fc.c, 8)
+	for bank := 0; bank < 8; bank++ {
+		l.banks[bank] = v.PRG[bank*0x8000:][:0x8000]
+	}
+	l.cur = 3
+	l.window = l.content(3)
+	v.board.(*vAxROM).bank = 3
+	err := l.selectPRG(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := v.board.(*vAxROM).bank; got != 2 {
+		t.Errorf("board at bank %d, want 2", got)
+	}
+	if l.cur != 2 {
+		t.Errorf("latch at bank %d, want 2", l.cur)
+	}
+
+	// bank 3 cannot be reached from a board that is all 0
+	for i := range v.PRG {
+		v.PRG[i] = 0
+	}
+	l = newLatch(fc.Mapper.(*Discrete), fc.c, 8)
+	err = l.selectPRG(3)
+	if err == nil {
+		t.Errorf("bank 3 selected on a blank board")
+	}
+}
@@ -0,0 +1,190 @@
+package FCflash
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// FC is a Famicom cartridge in the Arduino reader, banked by its Mapper.
+type FC struct {
+	Mapper Mapper
+	c      *Client
+}
+
+func NewFC(s io.ReadWriter, m Mapper) *FC {
+	return NewFCClient(NewClient(s), m)
+}
+
+func NewFCClient(c *Client, m Mapper) *FC {
+	return &FC{Mapper: m, c: c}
+}
+
+func (f *FC) checkSize(what string, n, max int) error {
+	if n <= 0 || n > max {
+		return fmt.Errorf("%s: %s %d: %w", f.Mapper.Name(), what, n, ErrInvalidLength)
+	}
+	return nil
+}
+
+// DumpPRG writes prg 16KB units of PRG ROM to w.
+func (f *FC) DumpPRG(w io.Writer, prg int) error {
+	err := f.checkSize("PRG", prg, f.Mapper.Layout().MaxPRG)
+	if err != nil {
+		return err
+	}
+	return f.Mapper.DumpPRG(f.c, w, prg)
+}
+
+// DumpCHR writes chr 8KB units of CHR ROM to w.
+func (f *FC) DumpCHR(w io.Writer, chr int) error {
+	l := f.Mapper.Layout()
+	if l.CHRBank == 0 {
+		return fmt.Errorf("%s: CHR: %w", f.Mapper.Name(), ErrNotImplemented)
+	}
+	err := f.checkSize("CHR", chr, l.MaxCHR)
+	if err != nil {
+		return err
+	}
+	return f.Mapper.DumpCHR(f.c, w, chr)
+}
+
+// WritePRG programs prg 16KB units from r, if the board can.
+func (f *FC) WritePRG(r io.ReaderAt, prg int) error {
+	pw, ok := f.Mapper.(PRGWriter)
+	if !ok {
+		return fmt.Errorf("%s: write PRG: %w", f.Mapper.Name(), ErrNotImplemented)
+	}
+	err := f.checkSize("PRG", prg, f.Mapper.Layout().MaxPRG)
+	if err != nil {
+		return err
+	}
+	return pw.WritePRG(f.c, r, prg)
+}
+
+// WriteCHR programs chr 8KB units from r, if the board can.
+func (f *FC) WriteCHR(r io.ReaderAt, chr int) error {
+	cw, ok := f.Mapper.(CHRWriter)
+	if !ok {
+		return fmt.Errorf("%s: write CHR: %w", f.Mapper.Name(), ErrNotImplemented)
+	}
+	err := f.checkSize("CHR", chr, f.Mapper.Layout().MaxCHR)
+	if err != nil {
+		return err
+	}
+	return cw.WriteCHR(f.c, r, chr)
+}
+
+// DumpRAM writes size bytes of save RAM to w, if the board can reach it.
+func (f *FC) DumpRAM(w io.Writer, size int) error {
+	s, ok := f.Mapper.(SaveRAM)
+	if !ok {
+		return fmt.Errorf("%s: save RAM: %w", f.Mapper.Name(), ErrNotImplemented)
+	}
+	return s.DumpRAM(f.c, w, size)
+}
+
+// WriteRAM restores size bytes of save RAM from r, if the board can reach it.
+func (f *FC) WriteRAM(r io.ReaderAt, size int) error {
+	s, ok := f.Mapper.(SaveRAM)
+	if !ok {
+		return fmt.Errorf("%s: save RAM: %w", f.Mapper.Name(), ErrNotImplemented)
+	}
+	return s.WriteRAM(f.c, r, size)
+}
+
+// DumpExRAM writes the RAM in the mapper to w, if it has any.
+func (f *FC) DumpExRAM(w io.Writer) error {
+	x, ok := f.Mapper.(ExRAM)
+	if !ok {
+		return fmt.Errorf("%s: ExRAM: %w", f.Mapper.Name(), ErrNotImplemented)
+	}
+	return x.DumpExRAM(f.c, w)
+}
+
+// DetectBoard tells the revision of the board apart, if the Mapper has
+// to, and dumps with the Mapper for it from then on.
+func (f *FC) DetectBoard() (mapper, submapper int, err error) {
+	d, ok := f.Mapper.(BoardDetector)
+	if !ok {
+		return 0, 0, fmt.Errorf("%s: detect board: %w", f.Mapper.Name(), ErrNotImplemented)
+	}
+	m, mapper, submapper, err := d.DetectBoard(f.c)
+	if err != nil {
+		return 0, 0, err
+	}
+	f.Mapper = m
+	return mapper, submapper, nil
+}
+
+// DetectPRG measures the PRG ROM in 16KB units.
+func (f *FC) DetectPRG() (int, error) {
+	s, ok := f.Mapper.(Sampler)
+	if !ok {
+		return 0, fmt.Errorf("%s: detect PRG: %w", f.Mapper.Name(), ErrNotImplemented)
+	}
+	return f.detect("PRG", 16*1024, f.Mapper.Layout().MaxPRG, func(w io.Writer, offsets []int) error {
+		return s.SamplePRG(f.c, w, offsets, PACKET_SIZE)
+	})
+}
+
+// DetectCHR measures the CHR ROM in 8KB units, 0 if the board has CHR RAM.
+// 8KB of CHR ROM and CHR RAM that is not banked look the same.
+func (f *FC) DetectCHR() (int, error) {
+	l := f.Mapper.Layout()
+	if l.CHRBank == 0 {
+		return 0, nil
+	}
+	s, ok := f.Mapper.(Sampler)
+	if !ok {
+		return 0, fmt.Errorf("%s: detect CHR: %w", f.Mapper.Name(), ErrNotImplemented)
+	}
+	return f.detect("CHR", 8*1024, l.MaxCHR, func(w io.Writer, offsets []int) error {
+		return s.SampleCHR(f.c, w, offsets, PACKET_SIZE)
+	})
+}
+
+// detect reads two packets, at the start and the middle of a unit, at
+// offset 0 and at each power of two up to max units. The first power of
+// two that repeats offset 0, as the address lines past the ROM are not
+// decoded, or reads open bus, is the size.
+func (f *FC) detect(what string, unit, max int, sample func(w io.Writer, offsets []int) error) (int, error) {
+	sizes := []int{0}
+	for n := 1; n < max; n *= 2 {
+		sizes = append(sizes, n)
+	}
+	var offsets []int
+	for _, n := range sizes {
+		offsets = append(offsets, n*unit, n*unit+unit/2)
+	}
+	var b bytes.Buffer
+	err := sample(&b, offsets)
+	if err != nil {
+		return 0, err
+	}
+
+	samples := b.Bytes()
+	at := func(i int) []byte {
+		return samples[i*2*PACKET_SIZE : (i+1)*2*PACKET_SIZE]
+	}
+	if openBus(at(0)) {
+		return 0, fmt.Errorf("%s: %s: %w", f.Mapper.Name(), what, ErrNoROM)
+	}
+	for i, n := range sizes[1:] {
+		s := at(i + 1)
+		if bytes.Equal(s, at(0)) || openBus(s) {
+			return n, nil
+		}
+	}
+	return max, nil
+}
+
+// openBus is what the pull-ups of the reader return when nothing drives the data bus.
+func openBus(b []byte) bool {
+	for _, d := range b {
+		if d != 0xff {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,209 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/ysh86/FCflash/ines"
+)
+
+// testFC puts img in a simulated reader and returns the FC for its mapper.
+func testFC(t *testing.T, img []byte) (*FC, *VirtualFC) {
+	t.Helper()
+	v, c := testVirtualFC(t, img)
+	m, err := LookupMapper(v.Mapper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewFCClient(c, m), v
+}
+
+func TestDumpRoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		mapper, prg, chr int
+	}{
+		{"NROM", 0, 2, 1},
+		{"NROM-128", 0, 1, 1},
+		{"SEROM", 1, 2, 4},
+		{"SKROM", 1, 16, 16},
+		{"SNROM", 1, 16, 0},
+		{"SUROM", 1, 32, 0},
+		{"TLROM", 4, 32, 32},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, v := testFC(t, testImage(t, tc.mapper, tc.prg, tc.chr, int64(tc.mapper)))
+
+			var prg bytes.Buffer
+			err := fc.DumpPRG(&prg, tc.prg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(prg.Bytes(), v.PRG) {
+				t.Errorf("PRG differs")
+			}
+
+			if tc.chr == 0 {
+				return
+			}
+			var chr bytes.Buffer
+			err = fc.DumpCHR(&chr, tc.chr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(chr.Bytes(), v.CHR) {
+				t.Errorf("CHR differs")
+			}
+		})
+	}
+}
+
+func TestSxromFor(t *testing.T) {
+	for _, tc := range []struct {
+		prg, ram int
+		want     string
+	}{
+		{2, 0, "SEROM/SHROM"},
+		{8, 0, "SNROM"},
+		{16, 8 * 1024, "SNROM"},
+		{16, 16 * 1024, "SOROM"},
+		{32, 8 * 1024, "SUROM"},
+		{32, 32 * 1024, "SXROM"},
+	} {
+		if b := sxromFor(tc.prg, tc.ram); b.name != tc.want {
+			t.Errorf("%d PRG, %d RAM: got %s, want %s", tc.prg, tc.ram, b.name, tc.want)
+		}
+	}
+}
+
+func TestWritePRG(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		mapper, prg int
+	}{
+		{"NROM", 0, 2},
+		{"SNROM", 1, 16},
+		{"SUROM", 1, 32},
+		{"TGROM", 4, 32},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, v := testFC(t, testImage(t, tc.mapper, tc.prg, 0, 1))
+			prg := testImage(t, tc.mapper, tc.prg, 0, 2)[16:]
+
+			err := fc.WritePRG(bytes.NewReader(prg), tc.prg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v.PRG, prg) {
+				t.Errorf("PRG differs from the image")
+			}
+		})
+	}
+}
+
+func TestWriteCHR(t *testing.T) {
+	fc, v := testHandshakeFC(t, testImage(t, 4, 8, 32, 1))
+	chr := testImage(t, 4, 0, 32, 2)[16:]
+	err := fc.WriteCHR(bytes.NewReader(chr), 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v.CHR, chr) {
+		t.Errorf("CHR flash differs from the image")
+	}
+}
+
+func TestFCErrors(t *testing.T) {
+	fc, _ := testFC(t, testImage(t, 2, 2, 0, 0))
+	err := fc.WritePRG(bytes.NewReader(nil), 2)
+	if !errors.Is(err, ErrNotImplemented) {
+		t.Errorf("UxROM WritePRG: got %v, want ErrNotImplemented", err)
+	}
+	err = fc.DumpPRG(&bytes.Buffer{}, fc.Mapper.Layout().MaxPRG+1)
+	if !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("oversized PRG: got %v, want ErrInvalidLength", err)
+	}
+	_, err = LookupMapper(0xff)
+	if !errors.Is(err, ErrUnknownMapper) {
+		t.Errorf("mapper 255: got %v, want ErrUnknownMapper", err)
+	}
+}
+
+func TestSaveRAM(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		h    ines.Header
+	}{
+		{"SNROM", ines.Header{Mapper: 1, PRGROM: 256 * 1024, PRGRAM: 8 * 1024, Battery: true}},
+		{"SOROM", ines.Header{NES2: true, Mapper: 1, PRGROM: 256 * 1024, PRGRAM: 8 * 1024, PRGNVRAM: 8 * 1024}},
+		{"SXROM", ines.Header{NES2: true, Mapper: 1, PRGROM: 512 * 1024, PRGNVRAM: 32 * 1024}},
+		{"TKROM", ines.Header{Mapper: 4, PRGROM: 128 * 1024, CHRROM: 128 * 1024, PRGRAM: 8 * 1024, Battery: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, v := testHandshakeFC(t, testHeaderImage(t, tc.h, 0))
+			size := len(v.WRAM)
+			sav := make([]byte, size)
+			rand.New(rand.NewSource(1)).Read(sav)
+
+			err := fc.WriteRAM(bytes.NewReader(sav), size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v.WRAM, sav) {
+				t.Errorf("W-RAM differs from the .sav")
+			}
+			var b bytes.Buffer
+			err = fc.DumpRAM(&b, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b.Bytes(), sav) {
+				t.Errorf("dump differs from the .sav")
+			}
+
+			err = fc.DumpRAM(&b, 40*1024)
+			if !errors.Is(err, ErrInvalidLength) {
+				t.Errorf("too large: got %v, want ErrInvalidLength", err)
+			}
+		})
+	}
+}
+
+func TestDetectSize(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		mapper, prg, chr int
+	}{
+		{"NROM-128", 0, 1, 1},
+		{"NROM", 0, 2, 1},
+		{"SKROM", 1, 16, 16},
+		{"SUROM", 1, 32, 0},
+		{"TLROM", 4, 16, 16},
+		{"TKROM", 4, 32, 32},
+		{"UNROM", 2, 8, 0},
+		{"CNROM", 3, 2, 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, _ := testHandshakeFC(t, testImage(t, tc.mapper, tc.prg, tc.chr, 3))
+			prg, err := fc.DetectPRG()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if prg != tc.prg {
+				t.Errorf("PRG: got %d, want %d", prg, tc.prg)
+			}
+			if tc.chr == 0 {
+				return
+			}
+			chr, err := fc.DetectCHR()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if chr != tc.chr {
+				t.Errorf("CHR: got %d, want %d", chr, tc.chr)
+			}
+		})
+	}
+}
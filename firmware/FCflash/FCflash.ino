@@ -108,12 +108,20 @@ void setA15A18(uint32_t addr)
 }
 
 // Read one byte out of the cartridge
+// OUT_OE: PIN_PLACEHOLDER reads W-RAM, where M2 alone enables the chip
 uint8_t readByte(uint8_t OUT_OE) {
     // already disabled all chips (PRG, W-RAM & CHR)
     if (OUT_OE == OUT_ROMSEL) {
         // PRG
         ROMSEL(0); // select chip
         PHI2(1);   // enable read & set addr
+    } else if (OUT_OE == PIN_PLACEHOLDER) {
+        // W-RAM: the mapper decodes /CE from M2 after it rises
+        PHI2(1);
+        __asm__(
+            "nop\n\t"
+            "nop\n\t"
+        );
     } else {
         // CHR, RAW
         digitalWrite(OUT_OE, LOW); // enable read
@@ -131,6 +139,9 @@ uint8_t readByte(uint8_t OUT_OE) {
         // PRG
         PHI2(0);
         ROMSEL(1);
+    } else if (OUT_OE == PIN_PLACEHOLDER) {
+        // W-RAM
+        PHI2(0);
     } else {
         // CHR, RAW
         digitalWrite(OUT_OE, HIGH);
@@ -616,6 +627,131 @@ void writeFlash(uint8_t OUT_CE, uint16_t addr15, uint8_t buf[], uint16_t length)
     PORTF |= 0xf0;
     DDRF &= ~0xf0;
 }
+// One bus cycle of a flash command: OUT_WE strobes while CE is held low.
+void flashCycle(uint8_t OUT_WE, uint16_t addr15, uint8_t data) {
+    clearA00A07();
+    for (uint16_t a = 1; a <= (addr15&0xff); a++) {
+        nextA00A07(a);
+    }
+    setA08A14(addr15&0x7fff);
+    digitalWrite(OUT_WE, LOW);
+    PORTD = (PORTD & 0xf0) | (data & 0x0f);
+    PORTF = (PORTF & 0x0f) | (data & 0xf0);
+    __asm__(
+        "nop\n\t"
+    );
+    digitalWrite(OUT_WE, HIGH);
+    __asm__(
+        "nop\n\t"
+    );
+}
+// Program without erasing: 0xff is skipped, and DQ7 is polled until each byte is done.
+// A15-A18 are set by the caller.
+void programFlash(uint8_t OUT_WE, uint16_t addr15, uint8_t buf[], uint16_t length) {
+    for (uint16_t currByte = 0; currByte < length; currByte++) {
+        uint8_t data = buf[currByte];
+        if (data == 0xff) {
+            continue;
+        }
+
+        // I/O pins: output
+        DDRD |= 0x0f;
+        DDRF |= 0xf0;
+
+        noInterrupts();
+        flashCycle(OUT_WE, 0x5555, 0xaa);
+        flashCycle(OUT_WE, 0x2aaa, 0x55);
+        flashCycle(OUT_WE, 0x5555, 0xa0);
+        flashCycle(OUT_WE, addr15 + currByte, data);
+        interrupts();
+
+        // I/O pins: input/pull-up
+        PORTD |= 0x0f;
+        DDRD &= ~0x0f;
+        PORTF |= 0xf0;
+        DDRF &= ~0xf0;
+
+        // DQ7 is the complement of data while busy, DQ5 is set on timeout.
+        // The host verifies, so a byte that never completes is left as it is.
+        for (uint16_t i = 0; i < 1000; i++) {
+            uint8_t status = readByte(RAW_OUT_OE);
+            if (((status ^ data) & 0x80) == 0) {
+                break;
+            }
+            if (status & 0x20) {
+                break;
+            }
+            delayMicroseconds(1);
+        }
+    }
+}
+// Write one page of a 28C EEPROM at addr: the SDP command cycles first
+// (sdp A0H: protect and write, 20H: unprotect), then the bytes within the
+// byte load cycle time, then wait for the write cycle with the toggle bit.
+void writeEEPPage(uint8_t OUT_WE, uint16_t addr, uint8_t buf[], uint16_t length, uint16_t sdp) {
+    uint16_t lo_addr = addr & 0xff;
+
+    // I/O pins: output
+    DDRD |= 0x0f;
+    DDRF |= 0xf0;
+
+    noInterrupts();
+    if (sdp == 0xa0 || sdp == 0x20) {
+        flashCycle(OUT_WE, 0x5555, 0xaa);
+        flashCycle(OUT_WE, 0x2aaa, 0x55);
+        if (sdp == 0x20) {
+            flashCycle(OUT_WE, 0x5555, 0x80);
+            flashCycle(OUT_WE, 0x5555, 0xaa);
+            flashCycle(OUT_WE, 0x2aaa, 0x55);
+        }
+        flashCycle(OUT_WE, 0x5555, sdp);
+    }
+    clearA00A07();
+    for (uint16_t a = 1; a <= lo_addr; a++) {
+        nextA00A07(a);
+    }
+    for (uint16_t currByte = 0; currByte < length; currByte++) {
+        uint8_t data = buf[currByte];
+
+        nextA00A07(lo_addr + currByte);
+        setA08A14(addr + currByte);
+
+        digitalWrite(OUT_WE, LOW);
+        PORTD = (PORTD & 0xf0) | (data & 0x0f);
+        PORTF = (PORTF & 0x0f) | (data & 0xf0);
+        __asm__(
+            "nop\n\t"
+            "nop\n\t"
+        );
+        digitalWrite(OUT_WE, HIGH);
+        __asm__(
+            "nop\n\t"
+            "nop\n\t"
+        );
+    }
+    interrupts();
+
+    // I/O pins: input/pull-up
+    PORTD |= 0x0f;
+    DDRD &= ~0x0f;
+    PORTF |= 0xf0;
+    DDRF &= ~0xf0;
+
+    if (length == 0) {
+        delay(10); // [msec] tWC
+        return;
+    }
+    // DQ6 toggles on every read of the last byte until the write cycle ends
+    uint8_t prev = readByte(RAW_OUT_OE);
+    for (uint16_t i = 0; i < 20000; i++) {
+        uint8_t status = readByte(RAW_OUT_OE);
+        if (((status ^ prev) & 0x40) == 0) {
+            break;
+        }
+        prev = status;
+        delayMicroseconds(1);
+    }
+}
 void writeRaw(uint8_t OUT_WE, uint32_t addr24, uint8_t buf[], uint16_t length) {
     uint16_t lo_addr = addr24 & 0xff;
 
@@ -727,12 +863,30 @@ void writeRegs(uint8_t OUT_WE, uint8_t buf[], uint16_t length) {
 #define REQ_RAW_READ_WO_CS      36
 #define REQ_RAW_WRITE_WO_CS     37
 #define REQ_RAW_WRITE_LO_WO_CS  38
+#define REQ_RAW_WRITE_EEP       39
 
 #define REQ_RAW_ERASE_FLASH     48
 #define REQ_RAW_WRITE_FLASH     49
+#define REQ_RAW_PROGRAM_FLASH   50
 
 #define REQ_GBM_WRITE_REGS      64
 
+// REQ_ECHO returns these in _reserved and index
+#define FIRMWARE_VERSION 7
+#define CAP_CPU_PPU       (1<<0)
+#define CAP_EEP           (1<<1)
+#define CAP_FLASH         (1<<2)
+#define CAP_RAW           (1<<3)
+#define CAP_RAW_FLASH     (1<<4)
+#define CAP_GBM           (1<<5)
+#define CAP_PHI2_INIT     (1<<6)
+#define CAP_CPU_READ_6502 (1<<7)
+#define CAP_PPU_WRITE     (1<<8)
+#define CAP_CPU_WRITE_ADDR (1<<9)
+#define CAP_RAW_PROGRAM   (1<<10)
+#define CAP_RAW_EEP       (1<<11)
+#define CAPS (CAP_CPU_PPU | CAP_EEP | CAP_FLASH | CAP_RAW | CAP_RAW_FLASH | CAP_GBM | CAP_PHI2_INIT | CAP_CPU_READ_6502 | CAP_PPU_WRITE | CAP_CPU_WRITE_ADDR | CAP_RAW_PROGRAM | CAP_RAW_EEP)
+
 // index
 #define INDEX_IMPLIED 0
 #define INDEX_CPU     1
@@ -844,6 +998,33 @@ void loop() {
     Serial.readBytes((uint8_t *)&msg, sizeof(msg));
 
     uint16_t addr = msg.value;
+    if (msg.request == REQ_ECHO) {
+        msg._reserved = FIRMWARE_VERSION;
+        msg.index = CAPS;
+        Serial.write((uint8_t *)&msg, sizeof(msg));
+        return;
+    }
+    if (msg.request == REQ_PHI2_INIT) {
+        // value: M2 cycles at 0x0000, nothing on the cartridge selected
+        // MMC5 keeps its registers in reset until M2 runs
+        clearA00A07();
+        setA08A14(0);
+        noInterrupts();
+        for (uint16_t i = 0; i < addr; i++) {
+            PHI2(1);
+            __asm__(
+                "nop\n\t"
+                "nop\n\t"
+            );
+            PHI2(0);
+            __asm__(
+                "nop\n\t"
+                "nop\n\t"
+            );
+        }
+        interrupts();
+        return;
+    }
     if (msg.request == REQ_CPU_READ) {
         // addr: 0b1xxx_xxxx... 32KB full
         addr = PRG_BASE | addr;
@@ -853,6 +1034,15 @@ void loop() {
         }
         return;
     }
+    if (msg.request == REQ_CPU_READ_6502) {
+        // addr: 0b0xxx_xxxx... W-RAM: 0x6000-0x7fff
+        addr &= 0x7fff;
+        if (msg.length <= PACKET_SIZE) {
+            readBytes(PIN_PLACEHOLDER, addr, readbuf, msg.length);
+            Serial.write(readbuf, msg.length);
+        }
+        return;
+    }
     if (msg.request == REQ_CPU_WRITE_6502) {
         // addr: 32(RAM)+32(ROM) KB full
         uint8_t out = OUT_ROMSEL;
@@ -862,14 +1052,11 @@ void loop() {
         uint8_t data = msg.length & 0xff;
         clearA00A07();
         noInterrupts();
-        if (out == OUT_ROMSEL) {
-            // MMC regs: 0x8000-0xfff1 (LSB: 0 or 1)
-            nextA00A07(addr&1);
-        } else {
-            // W-RAM: 0x6000-0x7fff
-            for (uint16_t a = 1; a <= (addr & 0xff); a++) {
-                nextA00A07(a);
-            }
+        // MMC regs need A0 only, but a discrete latch is written
+        // where the ROM holds the same value: all of A0-A7
+        // W-RAM: 0x6000-0x7fff
+        for (uint16_t a = 1; a <= (addr & 0xff); a++) {
+            nextA00A07(a);
         }
         setA08A14(addr);
         writeByte(out, OUT_CPU_RW, data);
@@ -949,6 +1136,21 @@ void loop() {
         return;
     }
 
+    if (msg.request == REQ_PPU_WRITE) {
+        // addr: 0b000x_xxxx... 8KB full
+        //  first half of a 4KB sector: 0x0000-0x07ff, erased
+        //  second half:                0x1800-0x1fff
+        // PPU /WR is N.C.: the flash /WE is wired to EEP_OPEN_DRAIN_WE
+        addr &= 0x1fff;
+        if (msg.length <= PACKET_SIZE) {
+            Serial.readBytes(readbuf, msg.length);
+            pinMode(EEP_OPEN_DRAIN_WE, OUTPUT); // enable write
+            writeFlash(OUT_PPU_A13, addr, readbuf, msg.length);
+            pinMode(EEP_OPEN_DRAIN_WE, INPUT);
+        }
+        return;
+    }
+
     // RAW
     if (msg.request == REQ_RAW_READ) {
         // addr24: 16bit + zero 8bit = 16MB
@@ -1036,6 +1238,18 @@ void loop() {
         }
         return;
     }
+    if (msg.request == REQ_RAW_WRITE_EEP) {
+        setA15A18(addr);
+        pinMode(RAW_OUT_OE, OUTPUT); // open-drain -> out
+        digitalWrite(RAW_OUT_OE, HIGH);
+        digitalWrite(RAW_OUT_CE, LOW);
+        if (msg.length <= PACKET_SIZE) {
+            Serial.readBytes(readbuf, msg.length);
+            writeEEPPage(RAW_OUT_WE, addr, readbuf, msg.length, msg.index);
+        }
+        digitalWrite(RAW_OUT_CE, HIGH);
+        return;
+    }
     // Raw Flash
     if (msg.request == REQ_RAW_ERASE_FLASH) {
         pinMode(RAW_OUT_OE, OUTPUT); // open-drain -> out
@@ -1061,6 +1275,20 @@ void loop() {
         digitalWrite(RAW_OUT_CE, HIGH);
         return;
     }
+    if (msg.request == REQ_RAW_PROGRAM_FLASH) {
+        // addr24: 16bit + zero 8bit = 16MB
+        uint32_t addr24 = (uint32_t)addr << 8;
+        setA15A18(addr24);
+        pinMode(RAW_OUT_OE, OUTPUT); // open-drain -> out
+        digitalWrite(RAW_OUT_OE, HIGH);
+        digitalWrite(RAW_OUT_CE, LOW);
+        if (msg.length <= PACKET_SIZE) {
+            Serial.readBytes(readbuf, msg.length);
+            programFlash(RAW_OUT_WE, addr24, readbuf, msg.length);
+        }
+        digitalWrite(RAW_OUT_CE, HIGH);
+        return;
+    }
     // GBM
     if (msg.request == REQ_GBM_WRITE_REGS) {
         setA15A18(addr);
@@ -0,0 +1,164 @@
+package FCflash
+
+import (
+	"fmt"
+	"io"
+)
+
+// JxROM is Sunsoft FME-7, or 5B with its sound: $8000 selects a command
+// and $A000 sets it. Commands 0-7 switch 1KB CHR banks, 8 switches
+// $6000 between a PRG ROM bank and the PRG RAM, 9-B 8KB PRG banks.
+type JxROM struct{}
+
+func init() {
+	RegisterMapper(69, JxROM{})
+}
+
+func (JxROM) Name() string {
+	return "JxROM (FME-7)"
+}
+
+func (JxROM) Layout() Layout {
+	return Layout{PRGBank: 8 * 1024, CHRBank: 1 * 1024, MaxPRG: 32, MaxCHR: 32}
+}
+
+// FME-7: command 8 $6000-$7FFF (from MSB)
+// 1:RAM enable, 1:RAM select, 6 bits:ROM bank
+const (
+	jxromRAMDisable = uint8(0b00000000)
+	jxromRAMEnable  = uint8(0b11000000)
+)
+
+// jxromCommand writes an FME-7 command: $8000 selects, $A000 sets.
+func jxromCommand(c *Client, cmd, value uint8) error {
+	err := c.CPUWrite6502(0x8000, cmd)
+	if err != nil {
+		return err
+	}
+	return c.CPUWrite6502(0xA000, value)
+}
+
+func (JxROM) DumpPRG(c *Client, w io.Writer, prg int) error {
+	// the save RAM is left alone while PRG is read
+	err := jxromCommand(c, 8, jxromRAMDisable)
+	if err != nil {
+		return err
+	}
+	// FME-7: PRG ROM command 9:$8000-$9FFF swappable
+	err = c.CPUWrite6502(0x8000, 9)
+	if err != nil {
+		return err
+	}
+
+	return pipe(c, w, func(p *Pipeline) error {
+		banks := (prg * 16 * 1024) >> 13
+		for bank := 0; bank < banks; bank++ {
+			err := c.CPUWrite6502(0xA000, uint8(bank))
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < 0x2000; i += PACKET_SIZE {
+				err = p.CPURead(0x8000|uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (JxROM) DumpCHR(c *Client, w io.Writer, chr int) error {
+	return pipe(c, w, func(p *Pipeline) error {
+		banks := (chr * 8 * 1024) >> 10
+		for bank := 0; bank < banks; bank += 8 {
+			// FME-7: CHR ROM commands 0-7:$0000-$1FFF 1KB each
+			for i := 0; i < 8; i++ {
+				err := jxromCommand(c, uint8(i), uint8(bank+i))
+				if err != nil {
+					return err
+				}
+			}
+
+			for i := 0; i < 0x2000; i += PACKET_SIZE {
+				err := p.PPURead(uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (JxROM) SamplePRG(c *Client, w io.Writer, offsets []int, n int) error {
+	err := jxromCommand(c, 8, jxromRAMDisable)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			err := jxromCommand(c, 9, uint8(o>>13))
+			if err != nil {
+				return err
+			}
+			err = p.CPURead(0x8000|uint16(o&0x1fff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (JxROM) SampleCHR(c *Client, w io.Writer, offsets []int, n int) error {
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			err := jxromCommand(c, 0, uint8(o>>10))
+			if err != nil {
+				return err
+			}
+			err = p.PPURead(uint16(o&0x03ff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DumpRAM reads the 8KB PRG RAM, mapped at $6000 for the while.
+func (JxROM) DumpRAM(c *Client, w io.Writer, size int) error {
+	err := checkRAM(JxROM{}, size, 8*1024)
+	if err != nil {
+		return err
+	}
+	err = jxromCommand(c, 8, jxromRAMEnable)
+	if err != nil {
+		return err
+	}
+	err = pipe(c, w, dumpWRAM)
+	if err != nil {
+		return err
+	}
+	return jxromCommand(c, 8, jxromRAMDisable)
+}
+
+// WriteRAM restores the 8KB PRG RAM from r.
+func (JxROM) WriteRAM(c *Client, r io.ReaderAt, size int) error {
+	err := checkRAM(JxROM{}, size, 8*1024)
+	if err != nil {
+		return err
+	}
+	err = jxromCommand(c, 8, jxromRAMEnable)
+	if err != nil {
+		return err
+	}
+	err = writeWRAM(c, r, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Println("")
+	return jxromCommand(c, 8, jxromRAMDisable)
+}
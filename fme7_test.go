@@ -0,0 +1,59 @@
+package FCflash
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ysh86/FCflash/ines"
+)
+
+func TestFME7RoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		h    ines.Header
+	}{
+		{"JLROM", ines.Header{Mapper: 69, PRGROM: 256 * 1024, CHRROM: 256 * 1024}},
+		{"JSROM", ines.Header{Mapper: 69, PRGROM: 512 * 1024, CHRROM: 256 * 1024, PRGRAM: 8 * 1024, Battery: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, v := testHandshakeFC(t, testHeaderImage(t, tc.h, 69))
+			prg, chr := tc.h.PRGROM/(16*1024), tc.h.CHRROM/(8*1024)
+			testDump(t, fc, v, prg, chr)
+
+			n, err := fc.DetectPRG()
+			if err != nil || n != prg {
+				t.Errorf("PRG detected as %d, %v, want %d", n, err, prg)
+			}
+			n, err = fc.DetectCHR()
+			if err != nil || n != chr {
+				t.Errorf("CHR detected as %d, %v, want %d", n, err, chr)
+			}
+			if got := BoardClass(69, prg, chr, tc.h.PRGRAM); got != tc.name {
+				t.Errorf("board %s, want %s", got, tc.name)
+			}
+		})
+	}
+}
+
+func TestFME7SaveRAM(t *testing.T) {
+	h := ines.Header{Mapper: 69, PRGROM: 256 * 1024, CHRROM: 256 * 1024, PRGRAM: 8 * 1024, Battery: true}
+	fc, v := testHandshakeFC(t, testHeaderImage(t, h, 0))
+	sav := make([]byte, 8*1024)
+	rand.New(rand.NewSource(1)).Read(sav)
+	err := fc.WriteRAM(bytes.NewReader(sav), len(sav))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v.WRAM, sav) {
+		t.Errorf("W-RAM differs from the .sav")
+	}
+	var b bytes.Buffer
+	err = fc.DumpRAM(&b, len(sav))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), sav) {
+		t.Errorf("dump differs from the .sav")
+	}
+}
@@ -2,90 +2,51 @@ package FCflash
 
 import (
 	"bytes"
-	"encoding/binary"
 	"fmt"
 	"io"
 )
 
 type GB struct {
 	Buf []uint8
-	s   io.ReadWriter
+	c   *Client
+	reg [1]uint8
 }
 
 func NewGB(s io.ReadWriter) *GB {
+	return NewGBClient(NewClient(s))
+}
+
+func NewGBClient(c *Client) *GB {
 	buf := make([]uint8, PACKET_SIZE*2)
-	return &GB{Buf: buf, s: s}
+	return &GB{Buf: buf, c: c}
 }
 
 func (g *GB) ReadFull(offset uint32) error {
-	g.Buf[0] = 0 // _reserverd
-	g.Buf[1] = uint8(REQ_RAW_READ)
-	binary.LittleEndian.PutUint16(g.Buf[2:4], uint16(offset>>8))     // Value
-	binary.LittleEndian.PutUint16(g.Buf[4:6], uint16(INDEX_IMPLIED)) // index
-	binary.LittleEndian.PutUint16(g.Buf[6:8], uint16(PACKET_SIZE))   // Length
-	_, err := g.s.Write(g.Buf[0:8])
-	if err != nil {
-		return err
-	}
-
-	_, err = io.ReadFull(g.s, g.Buf[0:PACKET_SIZE])
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return g.c.RawRead(offset, g.Buf[0:PACKET_SIZE])
 }
 
 func (g *GB) WriteRegByte(addr uint32, data int) error {
-	g.Buf[0] = 0 // _reserverd
-	g.Buf[1] = uint8(REQ_RAW_WRITE_WO_CS)
-	binary.LittleEndian.PutUint16(g.Buf[2:4], uint16(addr>>8))       // Value
-	binary.LittleEndian.PutUint16(g.Buf[4:6], uint16(INDEX_IMPLIED)) // index
-	binary.LittleEndian.PutUint16(g.Buf[6:8], 1)                     // Length
-	g.Buf[8] = uint8(data & 0xff)
-	_, err := g.s.Write(g.Buf[0:(8 + 1)])
-	return err
+	g.reg[0] = uint8(data & 0xff)
+	return g.c.RawWriteWOCS(addr, g.reg[:])
 }
 
 func (g *GB) setMemory(addr uint32, value byte, limit uint32) error {
 	if limit > PACKET_SIZE {
 		return fmt.Errorf("too long: %d", limit)
 	}
-	n := uint16(limit)
-
-	g.Buf[0] = 0 // _reserverd
-	g.Buf[1] = uint8(REQ_RAW_WRITE)
-	binary.LittleEndian.PutUint16(g.Buf[2:4], uint16(addr>>8))       // Value
-	binary.LittleEndian.PutUint16(g.Buf[4:6], uint16(INDEX_IMPLIED)) // index
-	binary.LittleEndian.PutUint16(g.Buf[6:8], n)                     // Length
-	for i := range g.Buf[8:(8 + n)] {
-		g.Buf[8+i] = value
+
+	for i := range g.Buf[0:limit] {
+		g.Buf[i] = value
 	}
-	_, err := g.s.Write(g.Buf[0:(8 + n)])
-	return err
+	return g.c.RawWrite(addr, g.Buf[0:limit])
 }
 
 func ParseHeader(buf []byte) (title string, cgb, cartType, romSize, ramSize byte, ok bool) {
 	begin := 0x0134
 	header := buf[begin:0x0150]
 
-	t := header[0:(0x0143 - begin)]
-	i := bytes.IndexByte(t, 0x00)
-	if i != -1 {
-		t = t[:i]
-	}
-	title = string(bytes.TrimSpace(t))
-
-	cgb = header[0x0143-begin]
-	cartType = header[0x0147-begin]
-	romSize = header[0x0148-begin]
-	ramSize = header[0x0149-begin]
-
-	sum := 0
-	for i := 0; i < 0x014d-begin; i++ {
-		sum = sum - int(header[i]) - 1
-	}
-	ok = (header[0x014d-begin] == byte(sum&0xff))
+	title, cgb, cartType, romSize, ramSize, sum := parseHeader(buf)
+	ok = (header[0x014d-begin] == sum)
 
 	fmt.Printf("title:   %s\n", title)
 	fmt.Printf("isCGB:   %02x\n", header[0x0143-begin])
@@ -97,17 +58,53 @@ func ParseHeader(buf []byte) (title string, cgb, cartType, romSize, ramSize byte
 	fmt.Printf("dest:    %02x\n", header[0x014a-begin])
 	fmt.Printf("old:     %02x\n", header[0x014b-begin])
 	fmt.Printf("version: %02x\n", header[0x014c-begin])
-	fmt.Printf("complement: %02x (actual: %02x)\n", header[0x014d-begin], sum&0xff)
+	fmt.Printf("complement: %02x (actual: %02x)\n", header[0x014d-begin], sum)
 	fmt.Printf("checksum:   %02x%02x\n", header[0x014e-begin], header[0x014f-begin])
 	fmt.Printf("\n")
 
 	return
 }
 
+// parseHeader decodes the cartridge header at 0134-014f without printing it.
+// sum is the header checksum computed over 0134-014c.
+func parseHeader(buf []byte) (title string, cgb, cartType, romSize, ramSize, sum byte) {
+	t := buf[0x0134:0x0143]
+	if i := bytes.IndexByte(t, 0x00); i != -1 {
+		t = t[:i]
+	}
+	for _, b := range buf[0x0134:0x014d] {
+		sum = sum - b - 1
+	}
+	return string(bytes.TrimSpace(t)), buf[0x0143], buf[0x0147], buf[0x0148], buf[0x0149], sum
+}
+
+// romSum adds up a ROM image as it is written, except the global checksum at 014e-014f.
+type romSum struct {
+	offset uint32
+	sum    uint32
+}
+
+func (s *romSum) Write(p []byte) (int, error) {
+	for i, b := range p {
+		if a := s.offset + uint32(i); a != 0x014e && a != 0x014f {
+			s.sum += uint32(b)
+		}
+	}
+	s.offset += uint32(len(p))
+	return len(p), nil
+}
+
 func (g *GB) DumpROM(w io.Writer, cartType, romSize byte) (checkSum uint32, err error) {
 	numBanks := 2 << int(romSize) // 16[KB/bank]
 	currAddr := uint32(0)
 
+	// writing and summing overlap the next read
+	var sum romSum
+	p, err := g.c.NewPipeline(io.MultiWriter(w, &sum))
+	if err != nil {
+		return 0, err
+	}
+
 	fmt.Printf("Bank: 00")
 	for currBank := 1; currBank < numBanks; currBank++ {
 		// Set ROM bank
@@ -141,17 +138,10 @@ func (g *GB) DumpROM(w io.Writer, cartType, romSize byte) (checkSum uint32, err
 			currAddr = 0x4000
 		}
 		for ; currAddr < 0x7FFF; currAddr += PACKET_SIZE {
-			err = g.ReadFull(currAddr)
+			err = p.RawRead(currAddr, PACKET_SIZE)
 			if err != nil {
-				return checkSum, err
-			}
-			w.Write(g.Buf[0:PACKET_SIZE])
-
-			// checksum
-			for currByte := uint32(0); currByte < PACKET_SIZE; currByte++ {
-				if currAddr+currByte != 0x014e && currAddr+currByte != 0x014f {
-					checkSum += uint32(g.Buf[currByte])
-				}
+				p.Close()
+				return sum.sum, err
 			}
 		}
 
@@ -159,7 +149,8 @@ func (g *GB) DumpROM(w io.Writer, cartType, romSize byte) (checkSum uint32, err
 	}
 	fmt.Printf("\n")
 
-	return checkSum, nil
+	err = p.Close()
+	return sum.sum, err
 }
 
 func calcRamSize(cartType, ramSize byte) (size uint32, numBanks int, err error) {
@@ -208,22 +199,34 @@ func (g *GB) DumpRAM(w io.Writer, cartType, ramSize byte) (size uint32, err erro
 	// enable RAM
 	g.WriteRegByte(0x0000, 0x0a)
 
+	p, err := g.c.NewPipeline(w)
+	if err != nil {
+		return size, err
+	}
+
 	// Switch RAM banks: 8[KB/bank] @ a000-end
+	limit := uint32(PACKET_SIZE)
+	if size < limit {
+		limit = size
+	}
 	for currBank := 0; currBank < numBanks; currBank++ {
 		g.WriteRegByte(0x4000, currBank)
 
 		for addr := uint32(0); addr < 8192; addr += PACKET_SIZE {
-			err = g.ReadFull(0xa000 + addr)
+			err = p.RawRead(0xa000+addr, int(limit))
 			if err != nil {
+				p.Close()
 				return size, err
 			}
 			if size < PACKET_SIZE {
-				w.Write(g.Buf[0:size])
 				break
 			}
-			w.Write(g.Buf[0:PACKET_SIZE])
 		}
 	}
+	err = p.Close()
+	if err != nil {
+		return size, err
+	}
 
 	// disable RAM
 	g.WriteRegByte(0x0000, 0x00)
@@ -1,9 +1,7 @@
 package FCflash
 
 import (
-	"encoding/binary"
 	"fmt"
-	"io"
 )
 
 var commands map[uint16][3]uint16
@@ -16,33 +14,16 @@ func init() {
 }
 
 func (g *GB) readFlashReg(addr uint16) (byte, error) {
-	g.Buf[0] = 0 // _reserverd
-	g.Buf[1] = uint8(REQ_RAW_READ_LO)
-	binary.LittleEndian.PutUint16(g.Buf[2:4], addr)                  // Value
-	binary.LittleEndian.PutUint16(g.Buf[4:6], uint16(INDEX_IMPLIED)) // index
-	binary.LittleEndian.PutUint16(g.Buf[6:8], 1)                     // Length
-	_, err := g.s.Write(g.Buf[0:8])
+	err := g.c.RawReadLo(addr, g.reg[:])
 	if err != nil {
 		return 0, err
 	}
-
-	_, err = io.ReadFull(g.s, g.Buf[8:(8+1)])
-	if err != nil {
-		return 0, err
-	}
-
-	return g.Buf[8], nil
+	return g.reg[0], nil
 }
 
 func (g *GB) writeFlashReg(addr uint16, data byte) error {
-	g.Buf[0] = 0 // _reserverd
-	g.Buf[1] = uint8(REQ_RAW_WRITE_LO)
-	binary.LittleEndian.PutUint16(g.Buf[2:4], addr)                  // Value
-	binary.LittleEndian.PutUint16(g.Buf[4:6], uint16(INDEX_IMPLIED)) // index
-	binary.LittleEndian.PutUint16(g.Buf[6:8], 1)                     // Length
-	g.Buf[8] = data
-	_, err := g.s.Write(g.Buf[0:(8 + 1)])
-	return err
+	g.reg[0] = data
+	return g.c.RawWriteLo(addr, g.reg[:])
 }
 
 func (g *GB) detectFlash8() (byte, byte, error) {
@@ -1,7 +1,6 @@
 package FCflash
 
 import (
-	"encoding/binary"
 	"errors"
 	"io"
 	"time"
@@ -43,42 +42,12 @@ const (
 )
 
 func (g *GB) Read128(offset uint32) error {
-	g.Buf[0] = 0 // _reserverd
-	g.Buf[1] = uint8(REQ_RAW_READ_WO_CS)
-	binary.LittleEndian.PutUint16(g.Buf[2:4], uint16(offset>>8))     // Value
-	binary.LittleEndian.PutUint16(g.Buf[4:6], uint16(INDEX_IMPLIED)) // index
-	binary.LittleEndian.PutUint16(g.Buf[6:8], 128)                   // Length
-	_, err := g.s.Write(g.Buf[0:8])
-	if err != nil {
-		return err
-	}
-
-	_, err = io.ReadFull(g.s, g.Buf[0:128])
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return g.c.RawReadWOCS(offset, g.Buf[0:128])
 }
 
 func (g *GB) writeGBMReg(addr uint16, data byte) error {
-	g.Buf[0] = 0 // _reserverd
-	g.Buf[1] = uint8(REQ_RAW_WRITE_LO_WO_CS)
-	binary.LittleEndian.PutUint16(g.Buf[2:4], addr)                  // Value
-	binary.LittleEndian.PutUint16(g.Buf[4:6], uint16(INDEX_IMPLIED)) // index
-	binary.LittleEndian.PutUint16(g.Buf[6:8], 1)                     // Length
-	_, err := g.s.Write(g.Buf[0:8])
-	if err != nil {
-		return err
-	}
-
-	g.Buf[8] = data
-	_, err = g.s.Write(g.Buf[8:(8 + 1)])
-	if err != nil {
-		return err
-	}
-
-	return nil
+	g.reg[0] = data
+	return g.c.RawWriteLoWOCS(addr, g.reg[:])
 }
 
 func (g *GB) commandGBM(command byte, addr uint16, data byte) {
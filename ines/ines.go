@@ -0,0 +1,357 @@
+// Package ines reads and writes the 16-byte header of iNES and NES 2.0 images.
+package ines
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const HEADER_SIZE = 16
+
+const TRAINER_SIZE = 512
+
+const MAGIC = "NES\x1a"
+
+var (
+	ErrMagic  = errors.New("not an iNES image")
+	ErrLength = errors.New("file shorter than the header says")
+	ErrSize   = errors.New("size cannot be encoded")
+)
+
+type Console uint8
+
+const (
+	CONSOLE_FC Console = iota
+	CONSOLE_VS
+	CONSOLE_PLAYCHOICE
+	CONSOLE_EXTENDED // NES 2.0 only, the type is in Extended
+)
+
+func (c Console) String() string {
+	switch c {
+	case CONSOLE_FC:
+		return "FC"
+	case CONSOLE_VS:
+		return "VS"
+	case CONSOLE_PLAYCHOICE:
+		return "PlayChoice-10"
+	case CONSOLE_EXTENDED:
+		return "extended"
+	}
+	return fmt.Sprintf("Console(%d)", uint8(c))
+}
+
+type Timing uint8
+
+const (
+	TIMING_NTSC Timing = iota
+	TIMING_PAL
+	TIMING_MULTI
+	TIMING_DENDY
+)
+
+func (t Timing) String() string {
+	switch t {
+	case TIMING_NTSC:
+		return "NTSC"
+	case TIMING_PAL:
+		return "PAL"
+	case TIMING_MULTI:
+		return "multi-region"
+	case TIMING_DENDY:
+		return "Dendy"
+	}
+	return fmt.Sprintf("Timing(%d)", uint8(t))
+}
+
+// Header is an iNES header. All sizes are in bytes.
+// PRGNVRAM, CHRRAM, CHRNVRAM, Submapper, Extended, MiscROMs and Expansion
+// only exist in NES 2.0; an iNES header has PRGRAM alone, 8KB if unset.
+type Header struct {
+	NES2 bool
+
+	Mapper    int
+	Submapper int
+
+	PRGROM   int
+	CHRROM   int
+	PRGRAM   int
+	PRGNVRAM int
+	CHRRAM   int
+	CHRNVRAM int
+
+	VerticalMirroring bool // byte 6 bit 0: horizontal arrangement
+	Battery           bool
+	Trainer           bool
+	FourScreen        bool
+
+	Console   Console
+	Timing    Timing
+	Extended  uint8 // byte 13: VS PPU and hardware type, or extended console type
+	MiscROMs  int
+	Expansion uint8 // default expansion device
+}
+
+// Parse decodes the header at the start of b.
+func Parse(b []byte) (Header, error) {
+	var h Header
+	err := h.UnmarshalBinary(b)
+	return h, err
+}
+
+// Read parses the header of an image of size bytes and checks the size against it.
+func Read(r io.ReaderAt, size int64) (Header, error) {
+	b := make([]byte, HEADER_SIZE)
+	_, err := r.ReadAt(b, 0)
+	if err == io.EOF {
+		return Header{}, ErrMagic
+	}
+	if err != nil {
+		return Header{}, err
+	}
+	h, err := Parse(b)
+	if err != nil {
+		return h, err
+	}
+	return h, h.Validate(size)
+}
+
+func (h *Header) UnmarshalBinary(b []byte) error {
+	if len(b) < HEADER_SIZE || string(b[0:4]) != MAGIC {
+		return ErrMagic
+	}
+	*h = Header{
+		VerticalMirroring: b[6]&0x01 != 0,
+		Battery:           b[6]&0x02 != 0,
+		Trainer:           b[6]&0x04 != 0,
+		FourScreen:        b[6]&0x08 != 0,
+		Console:           Console(b[7] & 0x03),
+		Mapper:            int(b[6] >> 4),
+	}
+
+	if b[7]&0x0c == 0x08 {
+		h.NES2 = true
+		h.Mapper |= int(b[7]&0xf0) | int(b[8]&0x0f)<<8
+		h.Submapper = int(b[8] >> 4)
+		h.PRGROM = romSize(b[4], b[9]&0x0f, 16*1024)
+		h.CHRROM = romSize(b[5], b[9]>>4, 8*1024)
+		h.PRGRAM = ramSize(b[10] & 0x0f)
+		h.PRGNVRAM = ramSize(b[10] >> 4)
+		h.CHRRAM = ramSize(b[11] & 0x0f)
+		h.CHRNVRAM = ramSize(b[11] >> 4)
+		h.Timing = Timing(b[12] & 0x03)
+		h.Extended = b[13]
+		h.MiscROMs = int(b[14] & 0x03)
+		h.Expansion = b[15] & 0x3f
+		return nil
+	}
+
+	// iNES: bytes 12-15 are garbage such as "DiskDude!" in old dumps,
+	// and then so is the upper nibble of the mapper
+	if b[12]|b[13]|b[14]|b[15] == 0 {
+		h.Mapper |= int(b[7] & 0xf0)
+	}
+	if h.Console == CONSOLE_EXTENDED {
+		h.Console = CONSOLE_FC
+	}
+	h.PRGROM = int(b[4]) * 16 * 1024
+	h.CHRROM = int(b[5]) * 8 * 1024
+	h.PRGRAM = int(b[8]) * 8 * 1024
+	if h.PRGRAM == 0 {
+		h.PRGRAM = 8 * 1024
+	}
+	if b[9]&0x01 != 0 {
+		h.Timing = TIMING_PAL
+	}
+	return nil
+}
+
+// romSize decodes an NES 2.0 ROM size: lsb and msb count units,
+// or msb 0xf selects the exponent-multiplier form 2^E*(MM*2+1) of lsb EEEEEEMM.
+func romSize(lsb, msb uint8, unit int) int {
+	if msb == 0x0f {
+		return (1 << (lsb >> 2)) * (int(lsb&0x03)*2 + 1)
+	}
+	return (int(msb)<<8 | int(lsb)) * unit
+}
+
+func ramSize(shift uint8) int {
+	if shift == 0 {
+		return 0
+	}
+	return 64 << shift
+}
+
+func (h *Header) MarshalBinary() ([]byte, error) {
+	b := make([]byte, HEADER_SIZE)
+	copy(b, MAGIC)
+	if h.VerticalMirroring {
+		b[6] |= 0x01
+	}
+	if h.Battery {
+		b[6] |= 0x02
+	}
+	if h.Trainer {
+		b[6] |= 0x04
+	}
+	if h.FourScreen {
+		b[6] |= 0x08
+	}
+	b[6] |= uint8(h.Mapper&0x0f) << 4
+	b[7] = uint8(h.Mapper&0xf0) | uint8(h.Console&0x03)
+
+	if !h.NES2 {
+		if h.Mapper > 0xff || h.Console == CONSOLE_EXTENDED {
+			return nil, fmt.Errorf("%w in iNES: mapper %d, console %v", ErrSize, h.Mapper, h.Console)
+		}
+		if h.PRGROM%(16*1024) != 0 || h.PRGROM/(16*1024) > 0xff {
+			return nil, fmt.Errorf("%w in iNES: PRG ROM %d", ErrSize, h.PRGROM)
+		}
+		if h.CHRROM%(8*1024) != 0 || h.CHRROM/(8*1024) > 0xff {
+			return nil, fmt.Errorf("%w in iNES: CHR ROM %d", ErrSize, h.CHRROM)
+		}
+		if h.PRGRAM%(8*1024) != 0 || h.PRGRAM/(8*1024) > 0xff {
+			return nil, fmt.Errorf("%w in iNES: PRG RAM %d", ErrSize, h.PRGRAM)
+		}
+		b[4] = uint8(h.PRGROM / (16 * 1024))
+		b[5] = uint8(h.CHRROM / (8 * 1024))
+		if h.PRGRAM != 8*1024 {
+			// 0 stands for 8KB
+			b[8] = uint8(h.PRGRAM / (8 * 1024))
+		}
+		if h.Timing == TIMING_PAL {
+			b[9] = 0x01
+		}
+		return b, nil
+	}
+
+	if h.Mapper > 0xfff || h.Submapper > 0x0f {
+		return nil, fmt.Errorf("%w: mapper %d.%d", ErrSize, h.Mapper, h.Submapper)
+	}
+	b[7] |= 0x08
+	b[8] = uint8(h.Submapper)<<4 | uint8(h.Mapper>>8)
+	var msb [2]uint8
+	var err error
+	b[4], msb[0], err = encodeROMSize(h.PRGROM, 16*1024)
+	if err != nil {
+		return nil, fmt.Errorf("PRG ROM: %w", err)
+	}
+	b[5], msb[1], err = encodeROMSize(h.CHRROM, 8*1024)
+	if err != nil {
+		return nil, fmt.Errorf("CHR ROM: %w", err)
+	}
+	b[9] = msb[1]<<4 | msb[0]
+	for i, s := range []int{h.PRGRAM, h.PRGNVRAM, h.CHRRAM, h.CHRNVRAM} {
+		shift, err := encodeRAMSize(s)
+		if err != nil {
+			return nil, err
+		}
+		b[10+i/2] |= shift << (4 * (i % 2))
+	}
+	b[12] = uint8(h.Timing & 0x03)
+	b[13] = h.Extended
+	b[14] = uint8(h.MiscROMs & 0x03)
+	b[15] = h.Expansion & 0x3f
+	return b, nil
+}
+
+func encodeROMSize(size, unit int) (lsb, msb uint8, err error) {
+	if size%unit == 0 && size/unit < 0xf00 {
+		n := size / unit
+		return uint8(n), uint8(n >> 8), nil
+	}
+	for e := 0; e < 64; e++ {
+		for mm := 0; mm < 4; mm++ {
+			if (1<<e)*(mm*2+1) == size {
+				return uint8(e<<2 | mm), 0x0f, nil
+			}
+		}
+	}
+	return 0, 0, fmt.Errorf("%w: %d", ErrSize, size)
+}
+
+func encodeRAMSize(size int) (uint8, error) {
+	if size == 0 {
+		return 0, nil
+	}
+	for shift := uint8(1); shift < 16; shift++ {
+		if 64<<shift == size {
+			return shift, nil
+		}
+	}
+	return 0, fmt.Errorf("%w: RAM %d", ErrSize, size)
+}
+
+// PRGOffset is where PRG ROM starts in the file.
+func (h *Header) PRGOffset() int64 {
+	if h.Trainer {
+		return HEADER_SIZE + TRAINER_SIZE
+	}
+	return HEADER_SIZE
+}
+
+// CHROffset is where CHR ROM starts in the file.
+func (h *Header) CHROffset() int64 {
+	return h.PRGOffset() + int64(h.PRGROM)
+}
+
+// Size is the length of the file up to the end of CHR ROM.
+func (h *Header) Size() int64 {
+	return h.CHROffset() + int64(h.CHRROM)
+}
+
+// Validate checks that the file holds what the header says. Anything
+// after CHR ROM is accepted: miscellaneous ROMs, the INST-ROM of a
+// PlayChoice image, or the title and padding many dumps carry.
+func (h *Header) Validate(size int64) error {
+	if size < h.Size() {
+		return fmt.Errorf("%w: %d bytes, want %d", ErrLength, size, h.Size())
+	}
+	return nil
+}
+
+func (h Header) String() string {
+	var s []string
+	if h.NES2 {
+		s = append(s, fmt.Sprintf("NES 2.0, mapper %d.%d", h.Mapper, h.Submapper))
+	} else {
+		s = append(s, fmt.Sprintf("iNES, mapper %d", h.Mapper))
+	}
+	s = append(s, "PRG "+size(h.PRGROM), "CHR "+size(h.CHRROM))
+	for _, r := range []struct {
+		name string
+		size int
+	}{{"PRG-RAM", h.PRGRAM}, {"PRG-NVRAM", h.PRGNVRAM}, {"CHR-RAM", h.CHRRAM}, {"CHR-NVRAM", h.CHRNVRAM}} {
+		if r.size != 0 {
+			s = append(s, r.name+" "+size(r.size))
+		}
+	}
+	switch {
+	case h.FourScreen:
+		s = append(s, "4-screen")
+	case h.VerticalMirroring:
+		s = append(s, "V")
+	default:
+		s = append(s, "H")
+	}
+	if h.Battery {
+		s = append(s, "battery")
+	}
+	if h.Trainer {
+		s = append(s, "trainer")
+	}
+	if h.Console != CONSOLE_FC {
+		s = append(s, h.Console.String())
+	}
+	s = append(s, h.Timing.String())
+	return strings.Join(s, ", ")
+}
+
+func size(n int) string {
+	if n%1024 != 0 {
+		return fmt.Sprintf("%dB", n)
+	}
+	return fmt.Sprintf("%dKB", n/1024)
+}
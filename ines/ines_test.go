@@ -0,0 +1,123 @@
+package ines
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	for _, h := range []Header{
+		{Mapper: 0, PRGROM: 32 * 1024, CHRROM: 8 * 1024, PRGRAM: 8 * 1024},
+		{Mapper: 4, PRGROM: 512 * 1024, CHRROM: 256 * 1024, PRGRAM: 8 * 1024, Battery: true, VerticalMirroring: true},
+		{Mapper: 1, PRGROM: 256 * 1024, PRGRAM: 32 * 1024, Timing: TIMING_PAL},
+		{NES2: true, Mapper: 23, Submapper: 2, PRGROM: 256 * 1024, CHRROM: 256 * 1024, PRGNVRAM: 8 * 1024},
+		{NES2: true, Mapper: 5, PRGROM: 1024 * 1024, CHRROM: 1024 * 1024, PRGRAM: 32 * 1024, PRGNVRAM: 32 * 1024, Timing: TIMING_MULTI},
+		{NES2: true, Mapper: 0x123, Submapper: 15, PRGROM: 3 * 8192, CHRRAM: 8 * 1024, Trainer: true, FourScreen: true},
+	} {
+		b, err := h.MarshalBinary()
+		if err != nil {
+			t.Fatalf("%v: %v", h, err)
+		}
+		if len(b) != HEADER_SIZE || string(b[0:4]) != MAGIC {
+			t.Fatalf("%v: % x", h, b)
+		}
+		got, err := Parse(b)
+		if err != nil {
+			t.Fatalf("%v: %v", h, err)
+		}
+		if got != h {
+			t.Errorf("round trip of %v: got %v", h, got)
+		}
+	}
+}
+
+func TestParseINES(t *testing.T) {
+	b := []byte("NES\x1a\x02\x01\x13\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	h, err := Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Header{Mapper: 1, PRGROM: 32 * 1024, CHRROM: 8 * 1024, PRGRAM: 8 * 1024, VerticalMirroring: true, Battery: true}
+	if h != want {
+		t.Errorf("got %v, want %v", h, want)
+	}
+
+	// garbage in bytes 12-15 drops the upper nibble of the mapper
+	copy(b[7:], "\x40\x00\x00\x00\x00DiskDude!"[:9])
+	h, err = Parse(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Mapper != 1 {
+		t.Errorf("DiskDude! header: mapper %d, want 1", h.Mapper)
+	}
+}
+
+func TestParseMagic(t *testing.T) {
+	for _, b := range [][]byte{nil, []byte("NES\x1a"), bytes.Repeat([]byte{0}, HEADER_SIZE)} {
+		_, err := Parse(b)
+		if !errors.Is(err, ErrMagic) {
+			t.Errorf("% x: got %v, want ErrMagic", b, err)
+		}
+	}
+}
+
+func TestMarshalSize(t *testing.T) {
+	for _, h := range []Header{
+		{Mapper: 256, PRGROM: 16 * 1024},
+		{PRGROM: 24 * 1024},
+		{PRGROM: 16 * 1024, CHRROM: 256 * 8 * 1024},
+		{NES2: true, Submapper: 16, PRGROM: 16 * 1024},
+		{NES2: true, PRGROM: 16 * 1024, PRGRAM: 3000},
+	} {
+		_, err := h.MarshalBinary()
+		if !errors.Is(err, ErrSize) {
+			t.Errorf("%v: got %v, want ErrSize", h, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	h := Header{PRGROM: 32 * 1024, CHRROM: 8 * 1024}
+	size := int64(HEADER_SIZE + 40*1024)
+	for _, c := range []struct {
+		size int64
+		ok   bool
+	}{
+		{size, true},
+		{size + 128, true}, // a title after CHR ROM
+		{size - 1, false},
+		{HEADER_SIZE, false},
+	} {
+		err := h.Validate(c.size)
+		if c.ok && err != nil || !c.ok && !errors.Is(err, ErrLength) {
+			t.Errorf("Validate(%d): %v", c.size, err)
+		}
+	}
+
+	h.Trainer = true
+	if err := h.Validate(size); !errors.Is(err, ErrLength) {
+		t.Errorf("trainer: Validate(%d): %v", size, err)
+	}
+}
+
+func TestRead(t *testing.T) {
+	h := Header{Mapper: 2, PRGROM: 128 * 1024, PRGRAM: 8 * 1024}
+	b, err := h.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := append(b, make([]byte, h.PRGROM)...)
+	got, err := Read(bytes.NewReader(img), int64(len(img)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != h {
+		t.Errorf("got %v, want %v", got, h)
+	}
+	_, err = Read(bytes.NewReader(img[:8]), 8)
+	if !errors.Is(err, ErrMagic) {
+		t.Errorf("short header: got %v, want ErrMagic", err)
+	}
+}
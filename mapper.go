@@ -0,0 +1,161 @@
+package FCflash
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrUnknownMapper  = errors.New("unknown mapper")
+	ErrNotImplemented = errors.New("not implemented")
+	ErrNoROM          = errors.New("no ROM answers")
+)
+
+// Layout is how a board banks its ROMs into the reader's address spaces.
+type Layout struct {
+	PRGBank int // bytes per switchable PRG bank, the whole PRG if fixed
+	CHRBank int // bytes per switchable CHR bank, 0 if CHR ROM cannot be dumped
+	MaxPRG  int // 16KB units
+	MaxCHR  int // 8KB units
+}
+
+// Mapper is an FC board, named after its iNES mapper.
+// Sizes are in iNES units: 16KB of PRG, 8KB of CHR.
+type Mapper interface {
+	Name() string
+	Layout() Layout
+	DumpPRG(c *Client, w io.Writer, prg int) error
+	DumpCHR(c *Client, w io.Writer, chr int) error
+}
+
+// PRGWriter is a Mapper that can program its PRG EEPROM or flash.
+// r holds the PRG alone, without the iNES header.
+type PRGWriter interface {
+	WritePRG(c *Client, r io.ReaderAt, prg int) error
+}
+
+// CHRWriter is a Mapper that can program its CHR EEPROM or flash.
+type CHRWriter interface {
+	WriteCHR(c *Client, r io.ReaderAt, chr int) error
+}
+
+// SaveRAM is a Mapper that can reach the battery-backed PRG RAM at $6000-$7FFF.
+type SaveRAM interface {
+	DumpRAM(c *Client, w io.Writer, size int) error
+	WriteRAM(c *Client, r io.ReaderAt, size int) error
+}
+
+// ExRAM is a Mapper with RAM of its own on the CPU bus, read whole.
+type ExRAM interface {
+	DumpExRAM(c *Client, w io.Writer) error
+}
+
+// BoardDetector is a Mapper whose board revisions differ in ways its
+// number does not tell. DetectBoard probes the cartridge for the Mapper
+// that dumps it and for its NES 2.0 mapper and submapper numbers.
+type BoardDetector interface {
+	DetectBoard(c *Client) (m Mapper, mapper, submapper int, err error)
+}
+
+// Sampler is a Mapper that can read anywhere in its ROMs, which size
+// detection needs: n bytes at each byte offset go to w in order.
+type Sampler interface {
+	SamplePRG(c *Client, w io.Writer, offsets []int, n int) error
+	SampleCHR(c *Client, w io.Writer, offsets []int, n int) error
+}
+
+var (
+	mappersMu sync.RWMutex
+	mappers   = map[int]Mapper{}
+)
+
+// RegisterMapper makes m available under its iNES mapper number.
+// It panics if the number is taken, so boards are registered in init.
+func RegisterMapper(number int, m Mapper) {
+	mappersMu.Lock()
+	defer mappersMu.Unlock()
+	if m == nil {
+		panic("FCflash: RegisterMapper: nil Mapper")
+	}
+	if old, dup := mappers[number]; dup {
+		panic(fmt.Sprintf("FCflash: RegisterMapper: mapper %d is %s already", number, old.Name()))
+	}
+	mappers[number] = m
+}
+
+// LookupMapper returns the board registered as number.
+func LookupMapper(number int) (Mapper, error) {
+	mappersMu.RLock()
+	defer mappersMu.RUnlock()
+	m, ok := mappers[number]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownMapper, number)
+	}
+	return m, nil
+}
+
+// Mappers returns the registered mapper numbers in order.
+func Mappers() []int {
+	mappersMu.RLock()
+	defer mappersMu.RUnlock()
+	numbers := make([]int, 0, len(mappers))
+	for n := range mappers {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+	return numbers
+}
+
+// checkRAM checks a save RAM size against what a board can bank.
+func checkRAM(m Mapper, size, max int) error {
+	if size <= 0 || size%(8*1024) != 0 || size > max {
+		return fmt.Errorf("%s: save RAM %d: %w", m.Name(), size, ErrInvalidLength)
+	}
+	return nil
+}
+
+// dumpWRAM queues the reads of the 8KB at $6000-$7FFF.
+func dumpWRAM(p *Pipeline) error {
+	for i := 0; i < 0x2000; i += PACKET_SIZE {
+		err := p.CPURead6502(0x6000|uint16(i), PACKET_SIZE)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeWRAM writes the 8KB at offset of r to $6000-$7FFF, one M2 cycle a byte.
+func writeWRAM(c *Client, r io.ReaderAt, offset int64) error {
+	buf := make([]uint8, 0x2000)
+	_, err := r.ReadAt(buf, offset)
+	if err != nil {
+		return err
+	}
+	for i, d := range buf {
+		if i%PACKET_SIZE == 0 {
+			fmt.Printf(".")
+		}
+		err = c.CPUWrite6502(0x6000|uint16(i), d)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pipe queues reads on a Pipeline into w and waits for them.
+func pipe(c *Client, w io.Writer, reads func(p *Pipeline) error) error {
+	p, err := c.NewPipeline(w)
+	if err != nil {
+		return err
+	}
+	err = reads(p)
+	if cerr := p.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package FCflash
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// firmware/FCflash.cpp
+
+const MBED_PACKET_SIZE = 0x10000
+
+const MBED_MESSAGE_SIZE = 16
+
+type MbedRequest uint32
+
+// for GBA
+const (
+	MBED_REQ_READ16 MbedRequest = iota + 0
+	MBED_REQ_WRITE16
+	MBED_REQ_WRITE16_RND
+	MBED_REQ_READ8_CS2
+	MBED_REQ_WRITE8_CS2
+	MBED_REQ_WRITE8_CS2_RND
+)
+
+// for FC
+const (
+	MBED_REQ_CPU_READ MbedRequest = iota + 16
+	MBED_REQ_CPU_WRITE
+	MBED_REQ_PPU_READ
+)
+
+type MbedMessage struct {
+	Request   MbedRequest
+	Value     uint32
+	Length    uint32
+	_reserved uint32
+}
+
+func (m *MbedMessage) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, MBED_MESSAGE_SIZE)
+	binary.LittleEndian.PutUint32(buf[0:4], uint32(m.Request))
+	binary.LittleEndian.PutUint32(buf[4:8], m.Value)
+	binary.LittleEndian.PutUint32(buf[8:12], m.Length)
+	binary.LittleEndian.PutUint32(buf[12:16], m._reserved)
+	return buf, nil
+}
+
+func (m *MbedMessage) UnmarshalBinary(data []byte) error {
+	if len(data) != MBED_MESSAGE_SIZE {
+		return fmt.Errorf("%w: message: %d bytes", ErrInvalidLength, len(data))
+	}
+	m.Request = MbedRequest(binary.LittleEndian.Uint32(data[0:4]))
+	m.Value = binary.LittleEndian.Uint32(data[4:8])
+	m.Length = binary.LittleEndian.Uint32(data[8:12])
+	m._reserved = binary.LittleEndian.Uint32(data[12:16])
+	return nil
+}
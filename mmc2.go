@@ -0,0 +1,182 @@
+package FCflash
+
+import (
+	"fmt"
+	"io"
+)
+
+// PxROM is MMC2: an 8KB PRG bank at $8000 and the last three fixed.
+// Each 4KB half of CHR has two bank registers, FD and FE, and a latch
+// that the PPU flips between them by reading tiles $FD and $FE.
+type PxROM struct{}
+
+// FxROM is MMC4: MMC2 with a 16KB PRG bank at $8000 and 8KB of PRG RAM.
+type FxROM struct{}
+
+func init() {
+	RegisterMapper(9, PxROM{})
+	RegisterMapper(10, FxROM{})
+}
+
+func (PxROM) Name() string {
+	return "PxROM (MMC2)"
+}
+
+func (PxROM) Layout() Layout {
+	return Layout{PRGBank: 8 * 1024, CHRBank: 4 * 1024, MaxPRG: 8, MaxCHR: 16}
+}
+
+func (PxROM) DumpPRG(c *Client, w io.Writer, prg int) error {
+	return dumpLatchPRG(c, w, prg, 8*1024)
+}
+
+func (PxROM) DumpCHR(c *Client, w io.Writer, chr int) error {
+	return dumpLatchCHR(c, w, chr)
+}
+
+func (PxROM) SamplePRG(c *Client, w io.Writer, offsets []int, n int) error {
+	return sampleLatchPRG(c, w, offsets, n, 8*1024)
+}
+
+func (PxROM) SampleCHR(c *Client, w io.Writer, offsets []int, n int) error {
+	return sampleLatchCHR(c, w, offsets, n)
+}
+
+func (FxROM) Name() string {
+	return "FxROM (MMC4)"
+}
+
+func (FxROM) Layout() Layout {
+	return Layout{PRGBank: 16 * 1024, CHRBank: 4 * 1024, MaxPRG: 16, MaxCHR: 16}
+}
+
+func (FxROM) DumpPRG(c *Client, w io.Writer, prg int) error {
+	return dumpLatchPRG(c, w, prg, 16*1024)
+}
+
+func (FxROM) DumpCHR(c *Client, w io.Writer, chr int) error {
+	return dumpLatchCHR(c, w, chr)
+}
+
+func (FxROM) SamplePRG(c *Client, w io.Writer, offsets []int, n int) error {
+	return sampleLatchPRG(c, w, offsets, n, 16*1024)
+}
+
+func (FxROM) SampleCHR(c *Client, w io.Writer, offsets []int, n int) error {
+	return sampleLatchCHR(c, w, offsets, n)
+}
+
+// DumpRAM reads the 8KB PRG RAM, which MMC4 leaves enabled.
+func (FxROM) DumpRAM(c *Client, w io.Writer, size int) error {
+	err := checkRAM(FxROM{}, size, 8*1024)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, dumpWRAM)
+}
+
+// WriteRAM restores the 8KB PRG RAM from r.
+func (FxROM) WriteRAM(c *Client, r io.ReaderAt, size int) error {
+	err := checkRAM(FxROM{}, size, 8*1024)
+	if err != nil {
+		return err
+	}
+	err = writeWRAM(c, r, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Println("")
+	return nil
+}
+
+// dumpLatchPRG reads every bank through $A000 at $8000, the fixed ones too.
+func dumpLatchPRG(c *Client, w io.Writer, prg, prgBank int) error {
+	return pipe(c, w, func(p *Pipeline) error {
+		banks := prg * 16 * 1024 / prgBank
+		for bank := 0; bank < banks; bank++ {
+			// MMC2/MMC4: PRG ROM $A000:$8000 swappable
+			err := c.CPUWrite6502(0xA000, uint8(bank))
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < prgBank; i += PACKET_SIZE {
+				err = p.CPURead(uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// latchSelect points both registers of a CHR half at bank: reading tile
+// $FD or $FE on the way flips the latch but not what is read, so each
+// bank is read once whatever state the latch powered up in.
+func latchSelect(c *Client, half int, bank uint8) error {
+	// MMC2/MMC4: CHR ROM $B000/$C000:$0000 FD/FE, $D000/$E000:$1000 FD/FE
+	fd := uint16(0xB000 + half*0x2000)
+	err := c.CPUWrite6502(fd, bank)
+	if err != nil {
+		return err
+	}
+	return c.CPUWrite6502(fd+0x1000, bank)
+}
+
+// dumpLatchCHR reads two 4KB banks per 8KB, through both halves.
+func dumpLatchCHR(c *Client, w io.Writer, chr int) error {
+	return pipe(c, w, func(p *Pipeline) error {
+		banks := (chr * 8 * 1024) >> 12
+		for bank := 0; bank < banks; bank += 2 {
+			err := latchSelect(c, 0, uint8(bank))
+			if err != nil {
+				return err
+			}
+			err = latchSelect(c, 1, uint8(bank+1))
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < 0x2000; i += PACKET_SIZE {
+				err = p.PPURead(uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func sampleLatchPRG(c *Client, w io.Writer, offsets []int, n, prgBank int) error {
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			err := c.CPUWrite6502(0xA000, uint8(o/prgBank))
+			if err != nil {
+				return err
+			}
+			err = p.CPURead(uint16(o%prgBank), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func sampleLatchCHR(c *Client, w io.Writer, offsets []int, n int) error {
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			err := latchSelect(c, 0, uint8(o>>12))
+			if err != nil {
+				return err
+			}
+			err = p.PPURead(uint16(o&0x0fff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
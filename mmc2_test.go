@@ -0,0 +1,57 @@
+package FCflash
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/ysh86/FCflash/ines"
+)
+
+func TestMMC2RoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		h    ines.Header
+	}{
+		{"PNROM", ines.Header{Mapper: 9, PRGROM: 128 * 1024, CHRROM: 128 * 1024}},
+		{"FJROM", ines.Header{Mapper: 10, PRGROM: 128 * 1024, CHRROM: 64 * 1024, PRGRAM: 8 * 1024, Battery: true}},
+		{"FKROM", ines.Header{Mapper: 10, PRGROM: 256 * 1024, CHRROM: 128 * 1024, PRGRAM: 8 * 1024, Battery: true}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, v := testHandshakeFC(t, testHeaderImage(t, tc.h, int64(tc.h.Mapper)))
+			prg, chr := tc.h.PRGROM/(16*1024), tc.h.CHRROM/(8*1024)
+			testDump(t, fc, v, prg, chr)
+
+			n, err := fc.DetectPRG()
+			if err != nil || n != prg {
+				t.Errorf("PRG detected as %d, %v, want %d", n, err, prg)
+			}
+			n, err = fc.DetectCHR()
+			if err != nil || n != chr {
+				t.Errorf("CHR detected as %d, %v, want %d", n, err, chr)
+			}
+		})
+	}
+}
+
+func TestMMC4SaveRAM(t *testing.T) {
+	h := ines.Header{Mapper: 10, PRGROM: 128 * 1024, CHRROM: 64 * 1024, PRGRAM: 8 * 1024, Battery: true}
+	fc, v := testHandshakeFC(t, testHeaderImage(t, h, 0))
+	sav := make([]byte, 8*1024)
+	rand.New(rand.NewSource(1)).Read(sav)
+	err := fc.WriteRAM(bytes.NewReader(sav), len(sav))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v.WRAM, sav) {
+		t.Errorf("W-RAM differs from the .sav")
+	}
+	var b bytes.Buffer
+	err = fc.DumpRAM(&b, len(sav))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), sav) {
+		t.Errorf("dump differs from the .sav")
+	}
+}
@@ -0,0 +1,301 @@
+package FCflash
+
+import (
+	"fmt"
+	"io"
+)
+
+// ExROM is MMC5: PRG in 8KB banks at $5114-$5117, CHR in 1KB banks at
+// $5120-$512B, up to 64KB of PRG RAM banked at $6000 by $5113 and 1KB of
+// ExRAM at $5C00. MMC5 ignores its registers until it sees M2 run, which
+// the reader leaves idle between requests, so M2 is run before each bank
+// switch.
+type ExROM struct{}
+
+func init() {
+	RegisterMapper(5, ExROM{})
+}
+
+func (ExROM) Name() string {
+	return "ExROM (MMC5)"
+}
+
+func (ExROM) Layout() Layout {
+	return Layout{PRGBank: 8 * 1024, CHRBank: 1 * 1024, MaxPRG: 64, MaxCHR: 128}
+}
+
+// exromM2Cycles is how long M2 runs before the registers are written.
+const exromM2Cycles = 1024
+
+// exromCanWake tells whether the firmware can run M2 at all: REQ_PHI2_INIT
+// came with v7, REQ_CPU_READ_6502 with v3.
+func exromCanWake(c *Client) bool {
+	return c.Supports(REQ_PHI2_INIT) || c.Supports(REQ_CPU_READ_6502)
+}
+
+// exromWake runs M2, by REQ_PHI2_INIT, or on firmware v3-v6 by reading
+// open bus at $0000 with REQ_CPU_READ_6502, one M2 cycle a byte. Earlier
+// firmware has neither, and MMC5 cannot be woken up.
+func exromWake(c *Client) error {
+	if !exromCanWake(c) {
+		return fmt.Errorf("MMC5: running M2 takes firmware v3: %w", ErrUnsupported)
+	}
+	if c.Supports(REQ_PHI2_INIT) {
+		return c.PHI2Init(exromM2Cycles)
+	}
+	buf := make([]uint8, PACKET_SIZE)
+	return c.CPURead6502(0x0000, buf)
+}
+
+// exromInit wakes MMC5 up and sets the bank modes every access uses.
+func exromInit(c *Client) error {
+	err := exromWake(c)
+	if err != nil {
+		return err
+	}
+	for _, r := range []struct {
+		addr uint16
+		data uint8
+	}{
+		{0x5100, 3}, // PRG mode 3: 8KB $8000, $A000, $C000, $E000
+		{0x5101, 3}, // CHR mode 3: 1KB x8
+		{0x5102, 0}, // PRG RAM protect 1
+		{0x5103, 0}, // PRG RAM protect 2
+	} {
+		err = c.CPUWrite6502(r.addr, r.data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exromPRG switches n 8KB banks from bank into $8000 onwards.
+func exromPRG(c *Client, bank, n int) error {
+	err := exromWake(c)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		// MMC5: PRG ROM $5114-$5117, bit 7: ROM
+		err = c.CPUWrite6502(0x5114+uint16(i), 0x80|uint8(bank+i))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// exromCHR switches the 4KB from the 1KB bank into $0000. Both register
+// sets, A for sprites and B for the background, get the same banks: which
+// one MMC5 reads through outside of rendering depends on what it saw last.
+func exromCHR(c *Client, bank int) error {
+	err := exromWake(c)
+	if err != nil {
+		return err
+	}
+	// MMC5: CHR bank upper bits
+	err = c.CPUWrite6502(0x5130, uint8(bank>>8))
+	if err != nil {
+		return err
+	}
+	for i := 0; i < 4; i++ {
+		// MMC5: CHR A $5120-$5127:$0000-$1FFF, B $5128-$512B:$0000-$0FFF
+		for _, reg := range []uint16{0x5120, 0x5124, 0x5128} {
+			err = c.CPUWrite6502(reg+uint16(i), uint8(bank+i))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (ExROM) DumpPRG(c *Client, w io.Writer, prg int) error {
+	err := exromInit(c)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, func(p *Pipeline) error {
+		banks := (prg * 16 * 1024) >> 13
+		for bank := 0; bank < banks; bank += 4 {
+			n := banks - bank
+			if n > 4 {
+				n = 4
+			}
+			err := exromPRG(c, bank, n)
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < n*0x2000; i += PACKET_SIZE {
+				err = p.CPURead(uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (ExROM) DumpCHR(c *Client, w io.Writer, chr int) error {
+	err := exromInit(c)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, func(p *Pipeline) error {
+		banks := (chr * 8 * 1024) >> 10
+		for bank := 0; bank < banks; bank += 4 {
+			err := exromCHR(c, bank)
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < 0x1000; i += PACKET_SIZE {
+				err = p.PPURead(uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (ExROM) SamplePRG(c *Client, w io.Writer, offsets []int, n int) error {
+	err := exromInit(c)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			err := exromPRG(c, o>>13, 1)
+			if err != nil {
+				return err
+			}
+			err = p.CPURead(uint16(o&0x1fff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (ExROM) SampleCHR(c *Client, w io.Writer, offsets []int, n int) error {
+	err := exromInit(c)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			err := exromCHR(c, o>>10&^3)
+			if err != nil {
+				return err
+			}
+			err = p.PPURead(uint16(o&0x0fff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// exromRAMBanks are the $5113 values of size bytes of PRG RAM. Bit 2
+// selects one of two chips: ETROM has two 8KB ones, the 64KB boards two
+// of 32KB.
+func exromRAMBanks(size int) []uint8 {
+	if size == 16*1024 {
+		return []uint8{0, 4}
+	}
+	banks := make([]uint8, size>>13)
+	for i := range banks {
+		banks[i] = uint8(i)
+	}
+	return banks
+}
+
+// DumpRAM reads the PRG RAM write-protected, 8KB a bank.
+func (ExROM) DumpRAM(c *Client, w io.Writer, size int) error {
+	err := checkRAM(ExROM{}, size, 64*1024)
+	if err != nil {
+		return err
+	}
+	err = exromInit(c)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, bank := range exromRAMBanks(size) {
+			err := exromWake(c)
+			if err != nil {
+				return err
+			}
+			// MMC5: PRG RAM $5113:$6000-$7FFF
+			err = c.CPUWrite6502(0x5113, bank)
+			if err != nil {
+				return err
+			}
+			err = dumpWRAM(p)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// WriteRAM restores the PRG RAM from r and protects it again.
+func (ExROM) WriteRAM(c *Client, r io.ReaderAt, size int) error {
+	err := checkRAM(ExROM{}, size, 64*1024)
+	if err != nil {
+		return err
+	}
+	err = exromInit(c)
+	if err != nil {
+		return err
+	}
+	// MMC5: PRG RAM protect 1:0b10, 2:0b01 allow writes
+	err = c.CPUWrite6502(0x5102, 0b10)
+	if err != nil {
+		return err
+	}
+	err = c.CPUWrite6502(0x5103, 0b01)
+	if err != nil {
+		return err
+	}
+	for i, bank := range exromRAMBanks(size) {
+		err = exromWake(c)
+		if err != nil {
+			return err
+		}
+		err = c.CPUWrite6502(0x5113, bank)
+		if err != nil {
+			return err
+		}
+		err = writeWRAM(c, r, int64(i)<<13)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("")
+	return exromInit(c)
+}
+
+// DumpExRAM reads the 1KB of ExRAM in mode 2, where the CPU can read it.
+func (ExROM) DumpExRAM(c *Client, w io.Writer) error {
+	err := exromInit(c)
+	if err != nil {
+		return err
+	}
+	// MMC5: ExRAM mode 2: CPU RAM at $5C00-$5FFF
+	err = c.CPUWrite6502(0x5104, 2)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, func(p *Pipeline) error {
+		return p.CPURead6502(0x5c00, 0x400)
+	})
+}
@@ -0,0 +1,119 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/ysh86/FCflash/ines"
+)
+
+func TestMMC5RoundTrip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		h    ines.Header
+	}{
+		{"EKROM", ines.Header{Mapper: 5, PRGROM: 256 * 1024, CHRROM: 256 * 1024, PRGRAM: 8 * 1024, Battery: true}},
+		{"ELROM", ines.Header{Mapper: 5, PRGROM: 1024 * 1024, CHRROM: 1024 * 1024}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, v := testHandshakeFC(t, testHeaderImage(t, tc.h, 5))
+			prg, chr := tc.h.PRGROM/(16*1024), tc.h.CHRROM/(8*1024)
+			testDump(t, fc, v, prg, chr)
+
+			n, err := fc.DetectPRG()
+			if err != nil || n != prg {
+				t.Errorf("PRG detected as %d, %v, want %d", n, err, prg)
+			}
+			n, err = fc.DetectCHR()
+			if err != nil || n != chr {
+				t.Errorf("CHR detected as %d, %v, want %d", n, err, chr)
+			}
+		})
+	}
+}
+
+func TestMMC5SaveRAM(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		h    ines.Header
+	}{
+		{"EKROM", ines.Header{Mapper: 5, PRGROM: 256 * 1024, CHRROM: 256 * 1024, PRGRAM: 8 * 1024, Battery: true}},
+		{"ETROM", ines.Header{NES2: true, Mapper: 5, PRGROM: 256 * 1024, CHRROM: 256 * 1024, PRGRAM: 8 * 1024, PRGNVRAM: 8 * 1024}},
+		{"EWROM", ines.Header{NES2: true, Mapper: 5, PRGROM: 256 * 1024, CHRROM: 256 * 1024, PRGNVRAM: 32 * 1024}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, v := testHandshakeFC(t, testHeaderImage(t, tc.h, 5))
+			sav := make([]byte, len(v.WRAM))
+			rand.New(rand.NewSource(1)).Read(sav)
+			err := fc.WriteRAM(bytes.NewReader(sav), len(sav))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v.WRAM, sav) {
+				t.Errorf("W-RAM differs from the .sav")
+			}
+			var b bytes.Buffer
+			err = fc.DumpRAM(&b, len(sav))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b.Bytes(), sav) {
+				t.Errorf("dump differs from the .sav")
+			}
+
+			// protected again: a stray write does not land
+			err = fc.c.CPUWrite6502(0x6000, ^sav[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.WRAM[0] != sav[0] {
+				t.Errorf("W-RAM left writable")
+			}
+		})
+	}
+}
+
+func TestMMC5ExRAM(t *testing.T) {
+	h := ines.Header{Mapper: 5, PRGROM: 256 * 1024, CHRROM: 256 * 1024}
+	fc, v := testHandshakeFC(t, testHeaderImage(t, h, 5))
+	var b bytes.Buffer
+	err := fc.Mapper.(ExRAM).DumpExRAM(fc.c, &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), v.board.(*vMMC5).exram[:]) {
+		t.Errorf("ExRAM differs")
+	}
+}
+
+func TestMMC5Firmware(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		version uint8
+		caps    Caps
+		want    error
+	}{
+		{"v6", 6, FIRMWARE_CAPS &^ CAP_PHI2_INIT &^ CAP_RAW_EEP, nil},
+		{"v2", 2, CAPS_LEGACY | CAP_CPU_WRITE_ADDR, ErrUnsupported},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := ines.Header{Mapper: 5, PRGROM: 128 * 1024, CHRROM: 128 * 1024}
+			fc, v := testFC(t, testHeaderImage(t, h, 5))
+			v.Version, v.Caps = tc.version, tc.caps
+			_, err := fc.c.Handshake()
+			if err != nil {
+				t.Fatal(err)
+			}
+			var b bytes.Buffer
+			err = fc.DumpPRG(&b, 8)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+			if err == nil && !bytes.Equal(b.Bytes(), v.PRG) {
+				t.Errorf("PRG differs")
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package FCflash
+
+import (
+	"io"
+)
+
+// NROM has no banking: up to 32KB of PRG and 8KB of CHR.
+// Its ROMs can be replaced with EEPROMs.
+type NROM struct{}
+
+func init() {
+	RegisterMapper(0, NROM{})
+}
+
+func (NROM) Name() string {
+	return "NROM"
+}
+
+func (NROM) Layout() Layout {
+	return Layout{PRGBank: 32 * 1024, CHRBank: 8 * 1024, MaxPRG: 2, MaxCHR: 1}
+}
+
+func (NROM) DumpPRG(c *Client, w io.Writer, prg int) error {
+	return pipe(c, w, func(p *Pipeline) error {
+		for i := 0; i < prg*16*1024; i += PACKET_SIZE {
+			err := p.CPURead(0x8000|uint16(i), PACKET_SIZE)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (NROM) DumpCHR(c *Client, w io.Writer, chr int) error {
+	return pipe(c, w, func(p *Pipeline) error {
+		for i := 0; i < chr*8*1024; i += PACKET_SIZE {
+			err := p.PPURead(uint16(i), PACKET_SIZE)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (NROM) SamplePRG(c *Client, w io.Writer, offsets []int, n int) error {
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			err := p.CPURead(uint16(o&0x7fff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (NROM) SampleCHR(c *Client, w io.Writer, offsets []int, n int) error {
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			err := p.PPURead(uint16(o&0x1fff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (NROM) WritePRG(c *Client, r io.ReaderAt, prg int) error {
+	buf := make([]uint8, PACKET_SIZE)
+	v := c.NewVerifier("PRG")
+	for i := 0; i < 16*1024*prg; i += PACKET_SIZE {
+		_, err := r.ReadAt(buf, int64(i))
+		if err != nil {
+			return err
+		}
+
+		addr := 0x8000 | uint16(i)
+		err = v.Packet(0, i, buf, func(b []byte) error {
+			return c.CPUWriteEEP(addr, b)
+		}, func(b []byte) error {
+			return c.CPURead(addr, b)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return v.Err()
+}
+
+func (NROM) WriteCHR(c *Client, r io.ReaderAt, chr int) error {
+	buf := make([]uint8, PACKET_SIZE)
+	v := c.NewVerifier("CHR")
+	for i := 0; i < 8*1024*chr; i += PACKET_SIZE {
+		_, err := r.ReadAt(buf, int64(i))
+		if err != nil {
+			return err
+		}
+
+		addr := uint16(i)
+		err = v.Packet(0, i, buf, func(b []byte) error {
+			return c.PPUWriteEEP(addr, b)
+		}, func(b []byte) error {
+			return c.PPURead(addr, b)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return v.Err()
+}
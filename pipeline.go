@@ -0,0 +1,221 @@
+package FCflash
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// PIPELINE_DEPTH is the number of read requests a Pipeline keeps outstanding:
+// the one whose reply is being received and the next one, which waits in the
+// firmware's receive buffer. 8 bytes fit even the 64-byte UART buffer of an Uno.
+const PIPELINE_DEPTH = 2
+
+// Pipeline queues read requests ahead of the reply being received, so that
+// the link does not idle while the host handles a packet. Replies are passed
+// to a writer in request order on a separate goroutine, through two buffers.
+//
+// While reads are in flight the stream belongs to the Pipeline: any other
+// request sent through the Client waits for them first, which also keeps
+// the order of reads and bank switches.
+type Pipeline struct {
+	parent *Client
+	c      *Client // a copy of parent without the pipeline
+	w      io.Writer
+
+	slots   chan struct{} // one per outstanding read
+	queue   chan Message  // sent reads awaiting their reply
+	free    chan []byte
+	full    chan []byte
+	pending sync.WaitGroup
+	wmu     sync.Mutex // sending or recovering
+	done    chan struct{}
+
+	mu  sync.Mutex
+	err error
+}
+
+// NewPipeline starts a pipelined read path on c that writes the replies to w.
+// Close the Pipeline to wait for the last of them.
+func (c *Client) NewPipeline(w io.Writer) (*Pipeline, error) {
+	if c.pipe != nil {
+		return nil, errors.New("pipeline already open")
+	}
+	c2 := *c
+	c2.buf = make([]uint8, MESSAGE_SIZE+PACKET_SIZE)
+	p := &Pipeline{
+		parent: c,
+		c:      &c2,
+		w:      w,
+		slots:  make(chan struct{}, PIPELINE_DEPTH),
+		queue:  make(chan Message, PIPELINE_DEPTH),
+		free:   make(chan []byte, 2),
+		full:   make(chan []byte, 2),
+		done:   make(chan struct{}),
+	}
+	p.free <- make([]byte, PACKET_SIZE)
+	p.free <- make([]byte, PACKET_SIZE)
+	c.pipe = p
+	go p.receive()
+	go p.drain()
+	return p, nil
+}
+
+func (p *Pipeline) fail(err error) {
+	p.mu.Lock()
+	if p.err == nil {
+		p.err = err
+	}
+	p.mu.Unlock()
+}
+
+// Err returns the first error of a read or of the writer.
+func (p *Pipeline) Err() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *Pipeline) read(m Message, n int) error {
+	if n <= 0 || n > PACKET_SIZE {
+		return &RequestError{m.Request, m.Value, fmt.Errorf("%w: %d", ErrInvalidLength, n)}
+	}
+	m.Length = uint16(n)
+
+	p.slots <- struct{}{}
+	if err := p.Err(); err != nil {
+		<-p.slots
+		return err
+	}
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	err := p.c.send(m, nil)
+	if err != nil {
+		<-p.slots
+		p.fail(err)
+		return err
+	}
+	p.pending.Add(1)
+	p.queue <- m
+	return nil
+}
+
+// receive reads the replies in the order the requests were sent.
+// Once the writer has failed the replies are still read, to keep the stream
+// in step, but after a failed read the rest are abandoned.
+func (p *Pipeline) receive() {
+	defer close(p.full)
+	broken := false
+	for m := range p.queue {
+		buf := <-p.free
+		if !broken {
+			err := p.readReply(m, buf[:m.Length])
+			if err != nil {
+				p.fail(err)
+				broken = true
+			}
+		}
+		if !broken {
+			p.full <- buf[:m.Length]
+		} else {
+			p.free <- buf
+		}
+		<-p.slots
+		p.pending.Done()
+	}
+}
+
+// readReply retries like Client.read. The stream is resynchronised with
+// sending held off, then the unanswered reads are sent again in order.
+// This recovers a stalled reader; bytes lost inside a reply are only
+// noticed as a short read of the one after it.
+func (p *Pipeline) readReply(m Message, buf []byte) error {
+	for retry := 0; ; retry++ {
+		err := p.c.readFull(buf)
+		if err == nil {
+			return nil
+		}
+		if retry >= p.c.Retries || !(errors.Is(err, ErrTimeout) || errors.Is(err, ErrShortRead)) {
+			return &RequestError{m.Request, m.Value, err}
+		}
+		if rerr := p.resend(m); rerr != nil {
+			return &RequestError{m.Request, m.Value, fmt.Errorf("%v: %w", err, rerr)}
+		}
+	}
+}
+
+func (p *Pipeline) resend(m Message) error {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+
+	err := p.c.Resync()
+	if err != nil {
+		return err
+	}
+	rest := []Message{}
+	for len(p.queue) > 0 {
+		rest = append(rest, <-p.queue)
+	}
+	for _, r := range append([]Message{m}, rest...) {
+		err = p.c.send(r, nil)
+		if err != nil {
+			return err
+		}
+	}
+	for _, r := range rest {
+		p.queue <- r
+	}
+	return nil
+}
+
+func (p *Pipeline) drain() {
+	defer close(p.done)
+	for buf := range p.full {
+		if p.Err() == nil {
+			_, err := p.w.Write(buf)
+			if err != nil {
+				p.fail(err)
+			}
+		}
+		p.free <- buf[:cap(buf)]
+	}
+}
+
+// wait blocks until every queued read has been received.
+func (p *Pipeline) wait() {
+	p.pending.Wait()
+}
+
+// Close waits until every reply is written and detaches the Pipeline from its Client.
+func (p *Pipeline) Close() error {
+	p.wait()
+	close(p.queue)
+	<-p.done
+	p.parent.pipe = nil
+	return p.Err()
+}
+
+// CPURead queues a read of n bytes of PRG at 0x8000|addr.
+func (p *Pipeline) CPURead(addr uint16, n int) error {
+	return p.read(Message{Request: REQ_CPU_READ, Value: addr}, n)
+}
+
+// CPURead6502 queues a read of n bytes of W-RAM at addr&0x7fff.
+func (p *Pipeline) CPURead6502(addr uint16, n int) error {
+	return p.read(Message{Request: REQ_CPU_READ_6502, Value: addr}, n)
+}
+
+// PPURead queues a read of n bytes of CHR at addr&0x1fff.
+func (p *Pipeline) PPURead(addr uint16, n int) error {
+	return p.read(Message{Request: REQ_PPU_READ, Value: addr}, n)
+}
+
+// RawRead queues a read at the 24-bit address addr, which must be 256-byte aligned.
+func (p *Pipeline) RawRead(addr uint32, n int) error {
+	v, err := rawValue(REQ_RAW_READ, addr)
+	if err != nil {
+		return err
+	}
+	return p.read(Message{Request: REQ_RAW_READ, Value: v}, n)
+}
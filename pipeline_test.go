@@ -0,0 +1,118 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// stallingReader is testReader, except that it stops answering at read
+// number stall, counting from 0, until it is sent REQ_ECHO.
+func stallingReader(t *testing.T, stall int) Transport {
+	t.Helper()
+	host, dev := net.Pipe()
+	go func() {
+		defer dev.Close()
+		buf := make([]byte, MESSAGE_SIZE)
+		stalled := false
+		for n := 0; ; {
+			_, err := io.ReadFull(dev, buf)
+			if err != nil {
+				return
+			}
+			var m Message
+			m.UnmarshalBinary(buf)
+			switch m.Request {
+			case REQ_ECHO:
+				stalled = false
+				dev.Write(buf)
+			case REQ_CPU_READ:
+				if n == stall {
+					stalled = true
+				}
+				n++
+				if stalled {
+					continue
+				}
+				reply := make([]byte, m.Length)
+				for i := range reply {
+					reply[i] = uint8(m.Value) + uint8(i)
+				}
+				dev.Write(reply)
+			}
+		}
+	}()
+	tr := NewTransport(host)
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+func TestPipeline(t *testing.T) {
+	v, c := testVirtualFC(t, testImage(t, 0, 2, 1, 0))
+	var b bytes.Buffer
+	p, err := c.NewPipeline(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for addr := 0; addr < 0x8000; addr += PACKET_SIZE {
+		err = p.CPURead(uint16(addr), PACKET_SIZE)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = p.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b.Bytes(), v.PRG) {
+		t.Errorf("PRG differs")
+	}
+}
+
+func TestPipelineRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		retries int
+		want    error
+	}{
+		{"no retry", 0, ErrTimeout},
+		{"retry", 1, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewClient(stallingReader(t, 2))
+			c.Timeout = 50 * time.Millisecond
+			c.Retries = tc.retries
+			var b bytes.Buffer
+			p, err := c.NewPipeline(&b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for addr := 0; addr < 0x40; addr += 0x10 {
+				err = p.CPURead(uint16(addr), 0x10)
+				if err != nil {
+					break
+				}
+			}
+			if cerr := p.Close(); err == nil {
+				err = cerr
+			}
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+			if err != nil {
+				return
+			}
+			want := make([]byte, 0x40)
+			for i := range want {
+				want[i] = uint8(i)
+			}
+			// the stalled read and the one queued behind it were sent again
+			if !bytes.Equal(b.Bytes(), want) {
+				t.Errorf("got % x", b.Bytes())
+			}
+		})
+	}
+}
@@ -0,0 +1,231 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+type Firmware int
+
+const (
+	FIRMWARE_UNKNOWN Firmware = iota
+	FIRMWARE_AVR              // firmware/FCflash/FCflash.ino: 8-byte Message
+	FIRMWARE_MBED             // firmware/FCflash.cpp: 16-byte Message
+)
+
+func (f Firmware) String() string {
+	switch f {
+	case FIRMWARE_AVR:
+		return "Arduino FCflash.ino"
+	case FIRMWARE_MBED:
+		return "mbed FCflash.cpp"
+	}
+	return "unknown"
+}
+
+// FirmwareInfo is what a reader tells about itself on connect.
+// Version is 0 for AVR builds from before versioning, which have CAPS_LEGACY.
+type FirmwareInfo struct {
+	Firmware Firmware
+	Version  uint8
+	Caps     Caps
+}
+
+func (i FirmwareInfo) String() string {
+	if i.Firmware != FIRMWARE_AVR {
+		return i.Firmware.String()
+	}
+	if i.Version == 0 {
+		return fmt.Sprintf("%v (unversioned, %v)", i.Firmware, i.Caps)
+	}
+	return fmt.Sprintf("%v v%d (%v)", i.Firmware, i.Version, i.Caps)
+}
+
+const PROBE_TIMEOUT = 500 * time.Millisecond
+
+// serial reads return this often so that a port can be closed while idle.
+const portPollInterval = 100 * time.Millisecond
+
+// ComPort is the tty of COM<com> under WSL.
+func ComPort(com int) string {
+	return "/dev/ttyS" + strconv.Itoa(com)
+}
+
+// OpenPort opens the serial device at path name.
+// "sim:<image>" opens a simulated reader with the image in it instead,
+// "replay:<capture>" plays back a file written by Capture, and
+// "tcp://<host>:<port>" connects to a reader exported by Server.
+func OpenPort(name string, baud int) (Transport, error) {
+	if strings.HasPrefix(name, "tcp://") {
+		return OpenTCP(name[len("tcp://"):])
+	}
+	if strings.HasPrefix(name, "sim:") {
+		return OpenVirtual(name[len("sim:"):])
+	}
+	if strings.HasPrefix(name, "replay:") {
+		return OpenReplay(name[len("replay:"):])
+	}
+
+	s, err := serial.OpenPort(&serial.Config{Name: name, Baud: baud, ReadTimeout: portPollInterval})
+	if err != nil {
+		return nil, err
+	}
+	return newTransport(s, true), nil
+}
+
+// CandidatePorts lists the tty devices a reader may be attached to.
+// Stable /dev/serial/by-id links are preferred over the devices they point to.
+func CandidatePorts() []string {
+	var ports []string
+	seen := make(map[string]bool)
+	for _, pattern := range []string{"/dev/serial/by-id/*", "/dev/ttyACM*", "/dev/ttyUSB*", "/dev/ttyS*"} {
+		names, _ := filepath.Glob(pattern)
+		sort.Strings(names)
+		for _, name := range names {
+			dev, err := filepath.EvalSymlinks(name)
+			if err != nil || seen[dev] {
+				continue
+			}
+			seen[dev] = true
+			ports = append(ports, name)
+		}
+	}
+	return ports
+}
+
+// Probe tells which firmware is on the other end of t.
+func Probe(t Transport, timeout time.Duration) (Firmware, error) {
+	info, err := Identify(t, timeout)
+	return info.Firmware, err
+}
+
+// Identify tells which firmware is on the other end of t, and its version
+// and capabilities.
+//
+// It sends an 8-byte REQ_ECHO first. The mbed firmware waits for a 16-byte
+// Message instead, so the next 8 bytes complete a REQ_READ16 of one word.
+// An AVR build older than REQ_ECHO answers only the final 1-byte REQ_CPU_READ.
+func Identify(t Transport, timeout time.Duration) (FirmwareInfo, error) {
+	_, err := t.Drain(50 * time.Millisecond)
+	if err != nil {
+		return FirmwareInfo{}, err
+	}
+
+	// all zero, so that it is also the first half of an mbed REQ_READ16
+	echo := Message{Request: REQ_ECHO}
+	req, _ := echo.MarshalBinary()
+	reply, err := probeReply(t, req, MESSAGE_SIZE, timeout)
+	if err != nil {
+		return FirmwareInfo{}, err
+	}
+	if reply != nil && bytes.Equal(reply[1:4], req[1:4]) && bytes.Equal(reply[6:8], req[6:8]) {
+		var m Message
+		m.UnmarshalBinary(reply)
+		// an unversioned build returns the message as it is
+		if m._reserverd == 0 {
+			return FirmwareInfo{FIRMWARE_AVR, 0, CAPS_LEGACY}, nil
+		}
+		return FirmwareInfo{FIRMWARE_AVR, m._reserverd, Caps(m.Index)}, nil
+	}
+
+	// mbed: {REQ_READ16, 0, 2, 0}
+	reply, err = probeReply(t, []byte{2, 0, 0, 0, 0, 0, 0, 0}, 2, timeout)
+	if err != nil {
+		return FirmwareInfo{}, err
+	}
+	if len(reply) == 2 {
+		return FirmwareInfo{Firmware: FIRMWARE_MBED}, nil
+	}
+
+	read := Message{Request: REQ_CPU_READ, Length: 1}
+	req, _ = read.MarshalBinary()
+	reply, err = probeReply(t, req, 1, timeout)
+	if err != nil {
+		return FirmwareInfo{}, err
+	}
+	if len(reply) == 1 {
+		return FirmwareInfo{FIRMWARE_AVR, 0, CAPS_LEGACY}, nil
+	}
+	return FirmwareInfo{}, nil
+}
+
+func probeReply(t Transport, req []byte, n int, timeout time.Duration) ([]byte, error) {
+	_, err := t.Write(req)
+	if err != nil {
+		return nil, err
+	}
+	c := NewClient(t)
+	c.Timeout = timeout
+	reply := make([]byte, n)
+	err = c.readFull(reply)
+	if errors.Is(err, ErrTimeout) || errors.Is(err, ErrShortRead) {
+		return nil, nil
+	}
+	return reply, err
+}
+
+type PortInfo struct {
+	Name string
+	FirmwareInfo
+	Err error
+}
+
+func (p PortInfo) String() string {
+	if p.Err != nil {
+		return fmt.Sprintf("%s: %v", p.Name, p.Err)
+	}
+	return fmt.Sprintf("%s: %v", p.Name, p.FirmwareInfo)
+}
+
+// Discover probes all CandidatePorts concurrently.
+func Discover(baud int, timeout time.Duration) []PortInfo {
+	ports := CandidatePorts()
+	infos := make([]PortInfo, len(ports))
+	var wg sync.WaitGroup
+	for i, name := range ports {
+		infos[i].Name = name
+		wg.Add(1)
+		go func(p *PortInfo) {
+			defer wg.Done()
+			t, err := OpenPort(p.Name, baud)
+			if err != nil {
+				p.Err = err
+				return
+			}
+			defer t.Close()
+			p.FirmwareInfo, p.Err = Identify(t, timeout)
+		}(&infos[i])
+	}
+	wg.Wait()
+	return infos
+}
+
+// ResolvePort maps the -port and -com options of the host tools to a device.
+// "auto" picks the first port running firmware fw, or any reader for FIRMWARE_UNKNOWN.
+func ResolvePort(name string, com int, baud int, fw Firmware) (string, error) {
+	if name == "" {
+		return ComPort(com), nil
+	}
+	if name != "auto" {
+		return name, nil
+	}
+
+	for _, p := range Discover(baud, PROBE_TIMEOUT) {
+		if p.Err == nil && p.Firmware != FIRMWARE_UNKNOWN && (p.Firmware == fw || fw == FIRMWARE_UNKNOWN) {
+			return p.Name, nil
+		}
+	}
+	if fw == FIRMWARE_UNKNOWN {
+		return "", errors.New("no reader found")
+	}
+	return "", fmt.Errorf("no port with %v", fw)
+}
@@ -0,0 +1,50 @@
+package FCflash
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestHandshake(t *testing.T) {
+	v, err := NewVirtualFC(testImage(t, 0, 2, 1, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := NewClient(v).Handshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := FirmwareInfo{FIRMWARE_AVR, FIRMWARE_VERSION, FIRMWARE_CAPS}
+	if info != want {
+		t.Errorf("got %v, want %v", info, want)
+	}
+
+	// a build that echoes the message as it is
+	c := NewClient(testReader(t, 0))
+	info, err = c.Handshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = FirmwareInfo{FIRMWARE_AVR, 0, CAPS_LEGACY}
+	if info != want {
+		t.Errorf("unversioned: got %v, want %v", info, want)
+	}
+	if !c.Supports(REQ_CPU_READ) || c.Supports(REQ_PHI2_INIT) {
+		t.Errorf("unversioned: wrong requests supported")
+	}
+	err = c.PHI2Init(1)
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("unversioned REQ_PHI2_INIT: got %v, want ErrUnsupported", err)
+	}
+}
+
+func TestHandshakeMbed(t *testing.T) {
+	v, err := NewVirtualGBA(make([]byte, 0x1000), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := NewClient(v).Handshake()
+	if info.Firmware != FIRMWARE_MBED || !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v, %v, want mbed and ErrUnsupported", info, err)
+	}
+}
@@ -0,0 +1,426 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Guess is a mapper that the cartridge behaves like.
+type Guess struct {
+	Mapper int
+	Name   string
+	Score  float64 // share of the expected behaviour that was seen, 0-1
+}
+
+// regions of a snapshot
+const (
+	probePRG8 = iota // $8000
+	probePRGA        // $A000
+	probePRGC        // $C000
+	probePRGE        // $E000
+	probeCHR0        // PPU $0000
+	probeCHR1        // PPU $1000
+	probeRegions
+)
+
+const probeSample = 0x100
+
+// snapshot is the start of each 8KB of PRG and 4KB of CHR.
+type snapshot [probeRegions][]byte
+
+func takeSnapshot(c *Client) (s snapshot, err error) {
+	var b bytes.Buffer
+	err = pipe(c, &b, func(p *Pipeline) error {
+		for _, a := range []uint16{0x0000, 0x2000, 0x4000, 0x6000} {
+			err := p.CPURead(a, probeSample)
+			if err != nil {
+				return err
+			}
+		}
+		for _, a := range []uint16{0x0000, 0x1000} {
+			err := p.PPURead(a, probeSample)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return s, err
+	}
+	for i := range s {
+		s[i] = b.Next(probeSample)
+	}
+	return s, nil
+}
+
+// changed tells in which regions two snapshots differ.
+func (s snapshot) changed(t snapshot) (d [probeRegions]bool) {
+	for i := range s {
+		d[i] = !bytes.Equal(s[i], t[i])
+	}
+	return d
+}
+
+// probeObs is what the probe saw, by test. Tests that could not be run are missing.
+type probeObs map[string]bool
+
+// What each board does in the tests of ProbeMapper.
+var probeSignatures = []struct {
+	mapper int
+	want   probeObs
+}{
+	{0, probeObs{"lo prg8": false, "lo chr": false, "hi prg8": false, "hi chr": false, "low half": false, "mmc1": false, "mmc3 prg": false, "8000 prg": false, "8000 prg16": false, "9000 prgC": false, "fme7 prg": false, "mmc5 prg": false, "a000 prg8": false, "a000 prg16": false}},
+	{1, probeObs{"mmc1": true, "mmc3 prg": false, "lo prg8": false, "lo chr": false, "8000 prg": false, "8000 prg16": false, "fme7 prg": false, "mmc5 prg": false, "a000 prg8": false, "a000 prg16": false}},
+	{2, probeObs{"lo prg8": true, "lo prgC": false, "lo chr": false, "low half": true, "mmc3 prg": false, "fme7 prg": false, "mmc5 prg": false}},
+	{3, probeObs{"lo prg8": false, "lo chr": true, "hi prg8": false, "hi chr": false, "low half": false, "8000 prg": false, "8000 prg16": false, "9000 prgC": false, "fme7 prg": false, "mmc5 prg": false, "a000 prg8": false, "a000 prg16": false}},
+	{4, probeObs{"mmc3 prg": true, "mmc1": false, "lo prg8": false, "lo chr": false, "8000 prg": false, "8000 prg16": false, "9000 prgC": false, "fme7 prg": false, "mmc5 prg": false, "a000 prg8": false, "a000 prg16": false}},
+	{5, probeObs{"mmc5 prg": true, "mmc1": false, "mmc3 prg": false, "8000 prg": false, "8000 prg16": false, "fme7 prg": false, "a000 prg8": false, "a000 prg16": false}},
+	{7, probeObs{"lo prg8": true, "lo prgC": true, "lo chr": false, "hi prg8": false, "hi chr": false, "fme7 prg": false, "mmc5 prg": false}},
+	{9, probeObs{"a000 prg8": true, "a000 prg16": false, "8000 prg": false, "fme7 prg": false, "mmc3 prg": false, "mmc5 prg": false}},
+	{10, probeObs{"a000 prg16": true, "a000 prg8": false, "8000 prg": false, "fme7 prg": false, "8000 prg16": false, "mmc3 prg": false, "mmc5 prg": false}},
+	{11, probeObs{"lo prg8": true, "lo prgC": true, "hi chr": true, "hi prg8": false, "fme7 prg": false, "mmc5 prg": false}},
+	{23, probeObs{"8000 prg": true, "9000 prgC": false, "fme7 prg": false, "mmc5 prg": false, "a000 prg8": false, "a000 prg16": false}},
+	{24, probeObs{"8000 prg16": true, "8000 prg": false, "9000 prgC": false, "fme7 prg": false, "mmc5 prg": false, "a000 prg8": false, "a000 prg16": false}},
+	{66, probeObs{"lo prg8": false, "lo chr": true, "hi prg8": true, "hi prgC": true, "fme7 prg": false, "mmc5 prg": false}},
+	{69, probeObs{"fme7 prg": true, "mmc1": false, "mmc3 prg": false, "8000 prg": false, "8000 prg16": false, "mmc5 prg": false, "a000 prg8": false, "a000 prg16": false}},
+	{71, probeObs{"lo prg8": true, "lo prgC": false, "lo chr": false, "low half": false, "8000 prg": false, "8000 prg16": false, "fme7 prg": false, "mmc5 prg": false, "a000 prg8": false, "a000 prg16": false}},
+	{85, probeObs{"8000 prg": true, "9000 prgC": true, "fme7 prg": false, "mmc5 prg": false, "a000 prg8": false, "a000 prg16": false}},
+}
+
+// ProbeMapper switches banks the ways the registered boards do and ranks them
+// by how well the cartridge followed, best first. Nothing but mapper
+// registers is written: no PRG RAM, no flash commands, and latch values
+// go where the ROM holds the same byte.
+//
+// Only the boards of probeSignatures can be guessed: NROM, MMC1-MMC5,
+// FME-7, the discrete-logic mappers 2, 3, 7, 11, 66 and 71, and the Konami
+// VRCs as 23 for VRC2/VRC4, 24 for VRC6 and 85 for VRC7, which DetectBoard
+// narrows down. MMC5 only on firmware that can run M2.
+func ProbeMapper(c *Client) ([]Guess, error) {
+	obs := probeObs{}
+	for _, test := range []func(*Client, probeObs) error{probeLatch, probeMMC1, probeMMC3, probeVRC, probeFME7, probeMMC5} {
+		err := test(c, obs)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var guesses []Guess
+	for _, s := range probeSignatures {
+		m, err := LookupMapper(s.mapper)
+		if err != nil {
+			continue
+		}
+		seen, fit := 0, 0
+		for k, v := range s.want {
+			o, ok := obs[k]
+			if !ok {
+				continue
+			}
+			seen++
+			if o == v {
+				fit++
+			}
+		}
+		g := Guess{Mapper: s.mapper, Name: m.Name()}
+		if seen > 0 {
+			g.Score = float64(fit) / float64(seen)
+		}
+		guesses = append(guesses, g)
+	}
+	sort.SliceStable(guesses, func(i, j int) bool {
+		return guesses[i].Score > guesses[j].Score
+	})
+	return guesses, nil
+}
+
+// probeLatch writes 0x00, 0x01 and 0x10 to a discrete latch at $C000-$FFFF,
+// then 0x00 and 0x01 at $8000-$BFFF, which Camerica ignores.
+func probeLatch(c *Client, obs probeObs) error {
+	// MMC1: reset, so that these few writes never load a register
+	err := c.CPUWrite6502(0x8000, 0x80)
+	if err != nil {
+		return err
+	}
+
+	// every byte value its own 32KB window: right for all the discrete boards
+	d := &Discrete{name: "latch", prgBank: 32 * 1024, prgMask: 0xff, reg: 0xc000, conflicts: true}
+	l := newLatch(d, c, 0)
+	s, err := latchSnapshots(c, l, 0x00, 0x01, 0x10)
+	if err != nil {
+		return err
+	}
+	if len(s) > 1 {
+		lo := s[0].changed(s[1])
+		obs["lo prg8"], obs["lo prgC"], obs["lo chr"] = lo[probePRG8], lo[probePRGC], lo[probeCHR0]
+	}
+	if len(s) > 2 {
+		hi := s[0].changed(s[2])
+		obs["hi prg8"], obs["hi prgC"], obs["hi chr"] = hi[probePRG8], hi[probePRGC], hi[probeCHR0]
+	}
+
+	err = c.CPUWrite6502(0x8000, 0x80)
+	if err != nil {
+		return err
+	}
+	d = &Discrete{name: "latch", prgBank: 32 * 1024, prgMask: 0xff, reg: 0x8000, conflicts: true}
+	l = newLatch(d, c, 0)
+	var low []uint16
+	for _, a := range l.addrs {
+		if a < 0xc000 {
+			low = append(low, a)
+		}
+	}
+	l.addrs = low
+	s, err = latchSnapshots(c, l, 0x00, 0x01)
+	if err != nil {
+		return err
+	}
+	if len(s) > 1 {
+		obs["low half"] = s[0].changed(s[1])[probePRG8]
+	}
+	return nil
+}
+
+// latchSnapshots writes each value in turn, as far as the ROM has it.
+func latchSnapshots(c *Client, l *latch, values ...int) ([]snapshot, error) {
+	var s []snapshot
+	for _, v := range values {
+		err := l.selectPRG(v)
+		if errors.Is(err, ErrInvalidValue) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		t, err := takeSnapshot(c)
+		if err != nil {
+			return nil, err
+		}
+		s = append(s, t)
+	}
+	return s, nil
+}
+
+// probeMMC1 looks for the shift register: one write to the PRG bank
+// register does nothing, five do.
+func probeMMC1(c *Client, obs probeObs) error {
+	// MMC1: reset
+	err := c.CPUWrite6502(0x8000, 0x80)
+	if err != nil {
+		return err
+	}
+	// MMC1: (from LSB)
+	// Mirroring 3: horizontal
+	// PRG ROM bank mode 3:$8000-$BFFF 16KB swappable
+	// CHR ROM bank mode 1:4KB two
+	err = c.CPUWrite5Bits(0x8000, 0b11111)
+	if err != nil {
+		return err
+	}
+	err = c.CPUWrite5Bits(0xA000, 0)
+	if err != nil {
+		return err
+	}
+	err = c.CPUWrite5Bits(0xE000, sxromRAMDisable)
+	if err != nil {
+		return err
+	}
+	s0, err := takeSnapshot(c)
+	if err != nil {
+		return err
+	}
+
+	// PRG bank 1, PRG RAM disable: 0b10001 from LSB
+	bits := []uint8{1, 0, 0, 0, 1}
+	err = c.CPUWrite6502(0xE000, bits[0])
+	if err != nil {
+		return err
+	}
+	s1, err := takeSnapshot(c)
+	if err != nil {
+		return err
+	}
+	for _, b := range bits[1:] {
+		err = c.CPUWrite6502(0xE000, b)
+		if err != nil {
+			return err
+		}
+	}
+	// CHR 4KB bank 1, for SEROM and SHROM
+	err = c.CPUWrite5Bits(0xA000, 1)
+	if err != nil {
+		return err
+	}
+	s2, err := takeSnapshot(c)
+	if err != nil {
+		return err
+	}
+
+	once, five := s0.changed(s1), s0.changed(s2)
+	obs["mmc1"] = once == [probeRegions]bool{} && (five[probePRG8] && !five[probePRGC] || five[probeCHR0])
+	return nil
+}
+
+// probeMMC3 switches R6: only $8000-$9FFF may follow.
+func probeMMC3(c *Client, obs probeObs) error {
+	// MMC1: reset
+	err := c.CPUWrite6502(0x8000, 0x80)
+	if err != nil {
+		return err
+	}
+	err = txromSelect(c, 0b00000110, 0)
+	if err != nil {
+		return err
+	}
+	s0, err := takeSnapshot(c)
+	if err != nil {
+		return err
+	}
+	err = c.CPUWrite6502(0x8001, 1)
+	if err != nil {
+		return err
+	}
+	s1, err := takeSnapshot(c)
+	if err != nil {
+		return err
+	}
+
+	d := s0.changed(s1)
+	obs["mmc3 prg"] = d[probePRG8] && !d[probePRGA] && !d[probePRGE]
+	return nil
+}
+
+// probeVRC switches the PRG bank of $8000: 8KB on VRC2, VRC4 and VRC7, at
+// $C000 instead in the PRG swap mode of VRC4, and 16KB on VRC6. Then the
+// one of $9000, which only VRC7 has.
+func probeVRC(c *Client, obs probeObs) error {
+	d, err := probeWrites(c, 0x8000)
+	if err != nil {
+		return err
+	}
+	obs["8000 prg"] = d[probePRG8] != d[probePRGC] && !d[probePRGA] && !d[probePRGE]
+	obs["8000 prg16"] = d[probePRG8] && d[probePRGA] && !d[probePRGC] && !d[probePRGE]
+	d, err = probeWrites(c, 0x9000)
+	if err != nil {
+		return err
+	}
+	obs["9000 prgC"] = d[probePRGC] && !d[probePRG8] && !d[probePRGE]
+	return nil
+}
+
+// probeWrites writes 0 then 1 to addr and tells what changed in between.
+func probeWrites(c *Client, addr uint16) (d [probeRegions]bool, err error) {
+	var s [2]snapshot
+	for i := range s {
+		// MMC1: reset, so that the writes never load a register
+		err = c.CPUWrite6502(0x8000, 0x80)
+		if err != nil {
+			return d, err
+		}
+		err = c.CPUWrite6502(addr, uint8(i))
+		if err != nil {
+			return d, err
+		}
+		s[i], err = takeSnapshot(c)
+		if err != nil {
+			return d, err
+		}
+	}
+	return s[0].changed(s[1]), nil
+}
+
+// probeFME7 switches command 9, the PRG bank at $8000, and B, the one at
+// $C000. MMC2 and MMC4 switch $8000 by $A000 whatever the command.
+func probeFME7(c *Client, obs probeObs) error {
+	var d [2][probeRegions]bool
+	for i, cmd := range []uint8{9, 0xB} {
+		var s [2]snapshot
+		for j := range s {
+			// MMC1: reset
+			err := c.CPUWrite6502(0x8000, 0x80)
+			if err != nil {
+				return err
+			}
+			err = jxromCommand(c, cmd, uint8(j))
+			if err != nil {
+				return err
+			}
+			s[j], err = takeSnapshot(c)
+			if err != nil {
+				return err
+			}
+		}
+		d[i] = s[0].changed(s[1])
+	}
+	obs["fme7 prg"] = d[0][probePRG8] && !d[0][probePRGA] && !d[0][probePRGE] &&
+		d[1][probePRGC] && !d[1][probePRG8]
+	obs["a000 prg8"] = d[1][probePRG8] && !d[1][probePRGA] && !d[1][probePRGE]
+	obs["a000 prg16"] = d[1][probePRG8] && d[1][probePRGA] && !d[1][probePRGC] && !d[1][probePRGE]
+	return nil
+}
+
+// probeMMC5 switches the PRG bank at $E000 by $5117. Without a way to run
+// M2 first MMC5 would not listen, so the test is left out.
+func probeMMC5(c *Client, obs probeObs) error {
+	if !exromCanWake(c) {
+		return nil
+	}
+	var s [2]snapshot
+	for i := range s {
+		err := exromWake(c)
+		if err != nil {
+			return err
+		}
+		// MMC5: PRG mode 3, 8KB $8000, $A000, $C000, $E000
+		err = c.CPUWrite6502(0x5100, 3)
+		if err != nil {
+			return err
+		}
+		err = c.CPUWrite6502(0x5117, 0x80|uint8(i))
+		if err != nil {
+			return err
+		}
+		s[i], err = takeSnapshot(c)
+		if err != nil {
+			return err
+		}
+	}
+	d := s[0].changed(s[1])
+	obs["mmc5 prg"] = d[probePRGE] && !d[probePRG8]
+	return nil
+}
+
+// BoardClass names the board family of a mapper with prg 16KB units,
+// chr 8KB units and ram bytes of PRG RAM.
+func BoardClass(mapper, prg, chr, ram int) string {
+	m, err := LookupMapper(mapper)
+	if err != nil {
+		return fmt.Sprint("mapper ", mapper)
+	}
+	switch mapper {
+	case 1:
+		b := sxromFor(prg, ram)
+		if chr > 0 && b.name == "SNROM" {
+			return "SKROM/SLROM"
+		}
+		return b.name
+	case 4:
+		if chr == 0 {
+			return "TGROM/TNROM"
+		}
+		if ram > 0 {
+			return "TKROM"
+		}
+		return "TLROM/TSROM"
+	case 69:
+		if ram > 0 {
+			return "JSROM"
+		}
+		return "JLROM"
+	}
+	return m.Name()
+}
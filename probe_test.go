@@ -0,0 +1,90 @@
+package FCflash
+
+import (
+	"testing"
+
+	"github.com/ysh86/FCflash/ines"
+)
+
+func TestProbeMapper(t *testing.T) {
+	for _, tc := range []struct {
+		name             string
+		mapper, prg, chr int
+	}{
+		{"NROM", 0, 2, 1},
+		{"SKROM", 1, 16, 16},
+		{"SNROM", 1, 16, 0},
+		{"UNROM", 2, 8, 0},
+		{"CNROM", 3, 2, 4},
+		{"TLROM", 4, 16, 16},
+		{"AOROM", 7, 8, 0},
+		{"Color Dreams", 11, 8, 8},
+		{"GNROM", 66, 8, 4},
+		{"Camerica", 71, 8, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, _ := testHandshakeFC(t, testImage(t, tc.mapper, tc.prg, tc.chr, 4))
+			guesses, err := ProbeMapper(fc.c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if g := guesses[0]; g.Mapper != tc.mapper || g.Score != 1 {
+				t.Errorf("got %v, want mapper %d", guesses, tc.mapper)
+			}
+		})
+	}
+}
+
+// TestProbeMapperBanked has the boards with registers at $5000-$FFFF
+// that NROM and MMC3 used to pass for. The VRCs come out as one number
+// each, which DetectBoard narrows down.
+func TestProbeMapperBanked(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		h      ines.Header
+		mapper int
+	}{
+		{"EKROM", ines.Header{Mapper: 5, PRGROM: 256 * 1024, CHRROM: 256 * 1024}, 5},
+		{"PNROM", ines.Header{Mapper: 9, PRGROM: 128 * 1024, CHRROM: 128 * 1024}, 9},
+		{"FJROM", ines.Header{Mapper: 10, PRGROM: 128 * 1024, CHRROM: 64 * 1024}, 10},
+		{"VRC4a", ines.Header{NES2: true, Mapper: 21, Submapper: 1, PRGROM: 256 * 1024, CHRROM: 256 * 1024}, 23},
+		{"VRC2a", ines.Header{Mapper: 22, PRGROM: 128 * 1024, CHRROM: 128 * 1024}, 23},
+		{"VRC2b", ines.Header{NES2: true, Mapper: 23, Submapper: 3, PRGROM: 128 * 1024, CHRROM: 128 * 1024}, 23},
+		{"VRC4d", ines.Header{NES2: true, Mapper: 25, Submapper: 2, PRGROM: 256 * 1024, CHRROM: 256 * 1024}, 23},
+		{"VRC6a", ines.Header{Mapper: 24, PRGROM: 256 * 1024, CHRROM: 256 * 1024}, 24},
+		{"VRC6b", ines.Header{Mapper: 26, PRGROM: 256 * 1024, CHRROM: 256 * 1024}, 24},
+		{"VRC7", ines.Header{NES2: true, Mapper: 85, Submapper: 2, PRGROM: 512 * 1024, CHRROM: 128 * 1024}, 85},
+		{"JLROM", ines.Header{Mapper: 69, PRGROM: 256 * 1024, CHRROM: 256 * 1024}, 69},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, _ := testHandshakeFC(t, testHeaderImage(t, tc.h, 4))
+			guesses, err := ProbeMapper(fc.c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// a tie goes to the board first in probeSignatures, NROM
+			if g := guesses[0]; g.Mapper != tc.mapper || g.Score != 1 {
+				t.Errorf("got %v, want mapper %d", guesses, tc.mapper)
+			}
+		})
+	}
+}
+
+func TestBoardClass(t *testing.T) {
+	for _, tc := range []struct {
+		mapper, prg, chr, ram int
+		want                  string
+	}{
+		{1, 16, 16, 8 * 1024, "SKROM/SLROM"},
+		{1, 32, 0, 8 * 1024, "SUROM"},
+		{4, 32, 0, 0, "TGROM/TNROM"},
+		{4, 16, 16, 8 * 1024, "TKROM"},
+		{4, 16, 16, 0, "TLROM/TSROM"},
+		{2, 8, 0, 0, "UxROM"},
+		{255, 2, 1, 0, "mapper 255"},
+	} {
+		if got := BoardClass(tc.mapper, tc.prg, tc.chr, tc.ram); got != tc.want {
+			t.Errorf("%+v: got %s", tc, got)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package FCflash
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// EEPROMChip is a 5V x8 parallel EEPROM for the raw socket. It has no
+// IDs to read, so it is picked by name or by the size of the image.
+type EEPROMChip struct {
+	Name string
+	Size int
+	Page int // bytes loaded for one write cycle
+}
+
+// Smallest first.
+var eepromChips = []EEPROMChip{
+	{"28C64", 8 * 1024, 64},
+	{"28C256", 32 * 1024, 64},
+}
+
+// LookupEEPROMChip finds a chip by name, with or without the maker's prefix: AT28C256 is 28C256.
+func LookupEEPROMChip(name string) (EEPROMChip, error) {
+	for _, chip := range eepromChips {
+		if strings.HasSuffix(strings.ToUpper(name), chip.Name) {
+			return chip, nil
+		}
+	}
+	return EEPROMChip{}, fmt.Errorf("%w: %s", ErrUnknownChip, name)
+}
+
+// EEPROMChipFor picks the smallest chip that holds size bytes.
+func EEPROMChipFor(size int) (EEPROMChip, error) {
+	for _, chip := range eepromChips {
+		if size <= chip.Size {
+			return chip, nil
+		}
+	}
+	return EEPROMChip{}, fmt.Errorf("%w: %d bytes", ErrUnknownChip, size)
+}
+
+func (chip EEPROMChip) String() string {
+	return fmt.Sprintf("%s %dKB, %d byte pages", chip.Name, chip.Size/1024, chip.Page)
+}
+
+// RawEEPROM is a 28C EEPROM in the raw socket.
+type RawEEPROM struct {
+	Chip EEPROMChip
+	c    *Client
+}
+
+func NewRawEEPROM(c *Client, chip EEPROMChip) *RawEEPROM {
+	return &RawEEPROM{Chip: chip, c: c}
+}
+
+// Unprotect turns software data protection off, so that plain writes go through.
+func (e *RawEEPROM) Unprotect() error {
+	return e.c.RawWriteEEP(0x0000, INDEX_SDP_DISABLE, nil)
+}
+
+// Program writes the first size bytes of r a page per write cycle and
+// reads each page back, see Verifier, and all of them at the end. With
+// protect every page goes after the SDP cycles, which leaves the chip
+// protected; without, the chip is unprotected first. Mismatches are by page.
+func (e *RawEEPROM) Program(r io.ReaderAt, size int, protect bool) error {
+	if size > e.Chip.Size {
+		return fmt.Errorf("%s: %d bytes: %w", e.Chip.Name, size, ErrInvalidLength)
+	}
+	sdp := INDEX_SDP_WRITE
+	if !protect {
+		err := e.Unprotect()
+		if err != nil {
+			return err
+		}
+		sdp = INDEX_IMPLIED
+	}
+
+	buf := make([]uint8, e.Chip.Page)
+	v := e.c.NewVerifier("RAW")
+	for i := 0; i < size; i += e.Chip.Page {
+		if i%PACKET_SIZE == 0 {
+			fmt.Printf(".")
+		}
+
+		b := buf
+		if size-i < e.Chip.Page {
+			b = buf[:size-i]
+		}
+		_, err := r.ReadAt(b, int64(i))
+		if err != nil {
+			return err
+		}
+
+		addr := uint16(i)
+		err = v.Packet(i/e.Chip.Page, 0, b, func(b []byte) error {
+			return e.c.RawWriteEEP(addr, sdp, b)
+		}, func(b []byte) error {
+			return e.c.RawReadLo(addr, b)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("")
+
+	// again, for a chip smaller than it was taken for wraps around
+	var got bytes.Buffer
+	err := dumpRaw(e.c, &got, size)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < size; i += e.Chip.Page {
+		b := buf
+		if size-i < e.Chip.Page {
+			b = buf[:size-i]
+		}
+		_, err = r.ReadAt(b, int64(i))
+		if err != nil {
+			return err
+		}
+		if !v.failed(i / e.Chip.Page) {
+			v.Check(i/e.Chip.Page, 0, b, got.Bytes()[i:i+len(b)])
+		}
+	}
+	return v.Err()
+}
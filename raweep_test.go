@@ -0,0 +1,93 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestRawEEPROM(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		protect bool
+	}{
+		{"SDP", true},
+		{"unprotected", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v, c := testRawSocket(t, "at28c256")
+			chip, err := LookupEEPROMChip("AT28C256")
+			if err != nil {
+				t.Fatal(err)
+			}
+			e := NewRawEEPROM(c, chip)
+
+			// an image that ends inside a page
+			size := 0x4000 + 0x21
+			img := make([]byte, size)
+			rand.New(rand.NewSource(0)).Read(img)
+			err = e.Program(bytes.NewReader(img), size, tc.protect)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v.Raw[:size], img) {
+				t.Errorf("chip differs from the image")
+			}
+
+			// a stray write goes through only if the chip is unprotected
+			err = c.RawWriteLo(0x7000, []byte{0x5a})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if written := v.Raw[0x7000] == 0x5a; written == tc.protect {
+				t.Errorf("protected %v, but a plain write changed the chip: %v", tc.protect, written)
+			}
+		})
+	}
+}
+
+func TestRawEEPROMTooSmall(t *testing.T) {
+	_, c := testRawSocket(t, "at28c64")
+	chip, err := EEPROMChipFor(32 * 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img := make([]byte, 16*1024)
+	rand.New(rand.NewSource(0)).Read(img)
+	err = NewRawEEPROM(c, chip).Program(bytes.NewReader(img), len(img), true)
+	var verr *VerifyError
+	if !errors.As(err, &verr) {
+		t.Fatalf("8KB chip taken for %v: got %v, want a VerifyError", chip, err)
+	}
+	if n := len(verr.Mismatches); n != 8*1024/chip.Page {
+		t.Errorf("%d pages differ, want the first %d", n, 8*1024/chip.Page)
+	}
+}
+
+func TestLookupEEPROMChip(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		size int
+		want string
+	}{
+		{"28c64", 8 * 1024, "28C64"},
+		{"AT28C256", 32 * 1024, "28C256"},
+		{"", 9 * 1024, "28C256"},
+	} {
+		if tc.name != "" {
+			chip, err := LookupEEPROMChip(tc.name)
+			if err != nil || chip.Name != tc.want {
+				t.Errorf("%s: got %v, %v", tc.name, chip, err)
+			}
+		}
+		chip, err := EEPROMChipFor(tc.size)
+		if err != nil || chip.Name != tc.want {
+			t.Errorf("%d bytes: got %v, %v", tc.size, chip, err)
+		}
+	}
+	_, err := EEPROMChipFor(64 * 1024)
+	if !errors.Is(err, ErrUnknownChip) {
+		t.Errorf("64KB: got %v, want ErrUnknownChip", err)
+	}
+}
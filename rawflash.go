@@ -0,0 +1,299 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+var ErrUnknownChip = errors.New("unknown chip")
+
+// RAW_FLASH_TIMEOUT bounds a chip erase, the slowest of the flash operations.
+const RAW_FLASH_TIMEOUT = 64 * time.Second
+
+// FlashChip is a 5V x8 flash chip for the raw socket. All of them take
+// their commands at 5555H/2AAAH, which also reaches the chips that
+// decode A0-A10 only.
+type FlashChip struct {
+	Name         string
+	Manufacturer uint8
+	Device       uint8
+	Size         int
+	Sectors      []int // bytes of each sector from address 0
+}
+
+func uniformSectors(size, sector int) []int {
+	s := make([]int, size/sector)
+	for i := range s {
+		s[i] = sector
+	}
+	return s
+}
+
+var flashChips = []FlashChip{
+	{"SST39SF010A", 0xbf, 0xb5, 128 * 1024, uniformSectors(128*1024, 4*1024)},
+	{"SST39SF020A", 0xbf, 0xb6, 256 * 1024, uniformSectors(256*1024, 4*1024)},
+	{"SST39SF040", 0xbf, 0xb7, 512 * 1024, uniformSectors(512*1024, 4*1024)},
+	{"Am29F010", 0x01, 0x20, 128 * 1024, uniformSectors(128*1024, 16*1024)},
+	{"Am29F002BT", 0x01, 0xb0, 256 * 1024, []int{64 * 1024, 64 * 1024, 64 * 1024, 32 * 1024, 8 * 1024, 8 * 1024, 16 * 1024}},
+	{"Am29F002BB", 0x01, 0x34, 256 * 1024, []int{16 * 1024, 8 * 1024, 8 * 1024, 32 * 1024, 64 * 1024, 64 * 1024, 64 * 1024}},
+	{"Am29F040", 0x01, 0xa4, 512 * 1024, uniformSectors(512*1024, 64*1024)},
+	{"MX29F002T", 0xc2, 0xb0, 256 * 1024, []int{64 * 1024, 64 * 1024, 64 * 1024, 32 * 1024, 8 * 1024, 8 * 1024, 16 * 1024}},
+	{"MX29F002B", 0xc2, 0x34, 256 * 1024, []int{16 * 1024, 8 * 1024, 8 * 1024, 32 * 1024, 64 * 1024, 64 * 1024, 64 * 1024}},
+	{"MX29F040", 0xc2, 0xa4, 512 * 1024, uniformSectors(512*1024, 64*1024)},
+}
+
+// LookupFlashChip finds a chip by its JEDEC IDs.
+func LookupFlashChip(manufacturer, device uint8) (FlashChip, error) {
+	for _, chip := range flashChips {
+		if chip.Manufacturer == manufacturer && chip.Device == device {
+			return chip, nil
+		}
+	}
+	return FlashChip{}, fmt.Errorf("%w: %02x %02x", ErrUnknownChip, manufacturer, device)
+}
+
+// sector returns the start and the number of the sector that holds addr.
+func (chip FlashChip) sector(addr int) (start, n int) {
+	for i, size := range chip.Sectors {
+		if addr < start+size {
+			return start, i
+		}
+		start += size
+	}
+	return start, len(chip.Sectors)
+}
+
+func (chip FlashChip) String() string {
+	return fmt.Sprintf("%s %dKB, %d sectors", chip.Name, chip.Size/1024, len(chip.Sectors))
+}
+
+// RawFlash is a flash chip in the raw socket: A0-A18 and /CE, /OE, /WE.
+type RawFlash struct {
+	Chip FlashChip
+	c    *Client
+	reg  [1]uint8
+}
+
+func (f *RawFlash) readReg(addr uint32) (uint8, error) {
+	var err error
+	if addr <= 0xffff {
+		err = f.c.RawReadLo(uint16(addr), f.reg[:])
+	} else {
+		err = f.c.RawRead(addr, f.reg[:])
+	}
+	return f.reg[0], err
+}
+
+func (f *RawFlash) writeReg(addr uint32, data uint8) error {
+	f.reg[0] = data
+	if addr <= 0xffff {
+		return f.c.RawWriteLo(uint16(addr), f.reg[:])
+	}
+	return f.c.RawWrite(addr, f.reg[:])
+}
+
+// command writes the unlock cycles and then data at addr.
+func (f *RawFlash) command(addr uint32, data uint8) error {
+	for _, w := range []struct {
+		addr uint32
+		data uint8
+	}{{0x5555, 0xaa}, {0x2aaa, 0x55}, {addr, data}} {
+		err := f.writeReg(w.addr, w.data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f *RawFlash) reset() error {
+	return f.writeReg(0x0000, 0xf0)
+}
+
+// DetectRawFlash reads the JEDEC IDs of the chip in the raw socket.
+// The chip must be a flash: the autoselect command writes to 5555H and 2AAAH.
+func DetectRawFlash(c *Client) (*RawFlash, error) {
+	f := &RawFlash{c: c}
+	err := f.reset()
+	if err != nil {
+		return nil, err
+	}
+	err = f.command(0x5555, 0x90)
+	if err != nil {
+		return nil, err
+	}
+	var id [2]uint8
+	err = c.RawReadLo(0x0000, id[:])
+	if err != nil {
+		return nil, err
+	}
+	err = f.reset()
+	if err != nil {
+		return nil, err
+	}
+
+	if id == [2]uint8{0xff, 0xff} {
+		return nil, fmt.Errorf("raw socket: %w", ErrNoROM)
+	}
+	f.Chip, err = LookupFlashChip(id[0], id[1])
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// wait polls DQ7 at addr, 256-byte aligned if above 64KB, until it reads
+// as in data. DQ5 tells that the chip gave up.
+func (f *RawFlash) wait(addr uint32, data uint8, what string) error {
+	deadline := time.Now().Add(RAW_FLASH_TIMEOUT)
+	for {
+		status, err := f.readReg(addr)
+		if err != nil {
+			return err
+		}
+		if status&0x80 == data&0x80 {
+			return nil
+		}
+
+		if status&0x20 != 0 {
+			// DQ7 may change along with DQ5
+			status, err := f.readReg(addr)
+			if err != nil {
+				return err
+			}
+			if status&0x80 == data&0x80 {
+				return nil
+			}
+			f.reset()
+			return fmt.Errorf("%s: exceeded time limits: %s: %06x", f.Chip.Name, what, addr)
+		}
+		if time.Now().After(deadline) {
+			f.reset()
+			return fmt.Errorf("%s: %s: %06x: %w", f.Chip.Name, what, addr, ErrTimeout)
+		}
+	}
+}
+
+// EraseChip erases all of the chip.
+func (f *RawFlash) EraseChip() error {
+	err := f.command(0x5555, 0x80)
+	if err != nil {
+		return err
+	}
+	err = f.command(0x5555, 0x10)
+	if err != nil {
+		return err
+	}
+	return f.wait(0x0000, 0xff, "erase chip")
+}
+
+// EraseSector erases the sector that starts at addr.
+func (f *RawFlash) EraseSector(addr int) error {
+	err := f.command(0x5555, 0x80)
+	if err != nil {
+		return err
+	}
+	err = f.command(uint32(addr), 0x30)
+	if err != nil {
+		return err
+	}
+	return f.wait(uint32(addr), 0xff, "erase sector")
+}
+
+// Erase erases the sectors that hold the first size bytes, or the whole
+// chip at once if that is all of it.
+func (f *RawFlash) Erase(size int) error {
+	if size > f.Chip.Size {
+		return fmt.Errorf("%s: %d bytes: %w", f.Chip.Name, size, ErrInvalidLength)
+	}
+	if size == f.Chip.Size {
+		return f.EraseChip()
+	}
+	for addr := 0; addr < size; {
+		fmt.Printf(".")
+		err := f.EraseSector(addr)
+		if err != nil {
+			return err
+		}
+		_, n := f.Chip.sector(addr)
+		addr += f.Chip.Sectors[n]
+	}
+	fmt.Println("")
+	return nil
+}
+
+// Dump writes the first size bytes of the chip to w.
+func (f *RawFlash) Dump(w io.Writer, size int) error {
+	return dumpRaw(f.c, w, size)
+}
+
+// dumpRaw writes the first size bytes in the raw socket to w.
+func dumpRaw(c *Client, w io.Writer, size int) error {
+	return pipe(c, w, func(p *Pipeline) error {
+		for i := 0; i < size; i += PACKET_SIZE {
+			n := size - i
+			if n > PACKET_SIZE {
+				n = PACKET_SIZE
+			}
+			err := p.RawRead(uint32(i), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// BlankCheck tells where the first size bytes are not erased.
+func (f *RawFlash) BlankCheck(size int) error {
+	var b bytes.Buffer
+	err := f.Dump(&b, size)
+	if err != nil {
+		return err
+	}
+	for i, d := range b.Bytes() {
+		if d != 0xff {
+			start, n := f.Chip.sector(i)
+			return fmt.Errorf("%s: not blank: sector %d offset 0x%04x: 0x%02x", f.Chip.Name, n, i-start, d)
+		}
+	}
+	return nil
+}
+
+// Program writes the first size bytes of r into the erased chip, reading
+// each packet back, see Verifier. Mismatches are by sector.
+func (f *RawFlash) Program(r io.ReaderAt, size int) error {
+	if size > f.Chip.Size {
+		return fmt.Errorf("%s: %d bytes: %w", f.Chip.Name, size, ErrInvalidLength)
+	}
+	buf := make([]uint8, PACKET_SIZE)
+	v := f.c.NewVerifier("RAW")
+	for i := 0; i < size; i += PACKET_SIZE {
+		fmt.Printf(".")
+
+		b := buf
+		if size-i < PACKET_SIZE {
+			b = buf[:size-i]
+		}
+		_, err := r.ReadAt(b, int64(i))
+		if err != nil {
+			return err
+		}
+
+		addr := uint32(i)
+		start, n := f.Chip.sector(i)
+		err = v.Packet(n, i-start, b, func(b []byte) error {
+			return f.c.RawProgramFlash(addr, b)
+		}, func(b []byte) error {
+			return f.c.RawRead(addr, b)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("")
+	return v.Err()
+}
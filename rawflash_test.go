@@ -0,0 +1,114 @@
+package FCflash
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// testRawSocket is a reader with chip in the raw socket.
+func testRawSocket(t *testing.T, chip string) (*VirtualFC, *Client) {
+	t.Helper()
+	v, c := testVirtualFC(t, testImage(t, 0, 2, 1, 0))
+	err := v.InsertRaw(chip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = c.Handshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, c
+}
+
+func TestRawFlash(t *testing.T) {
+	for _, tc := range []struct {
+		chip, name string
+	}{
+		{"sst39sf040", "SST39SF040"},
+		{"am29f040", "Am29F040"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v, c := testRawSocket(t, tc.chip)
+			f, err := DetectRawFlash(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if f.Chip.Name != tc.name {
+				t.Fatalf("detected %v", f.Chip)
+			}
+
+			// an image that ends inside a packet
+			size := 0x11000 + 0x123
+			img := make([]byte, size)
+			rand.New(rand.NewSource(0)).Read(img)
+			err = f.Program(bytes.NewReader(img), size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v.Raw[:size], img) {
+				t.Errorf("chip differs from the image")
+			}
+			var b bytes.Buffer
+			err = f.Dump(&b, size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b.Bytes(), img) {
+				t.Errorf("dump differs from the image")
+			}
+
+			if f.BlankCheck(size) == nil {
+				t.Errorf("programmed chip is blank")
+			}
+			err = f.Erase(size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.BlankCheck(size)
+			if err != nil {
+				t.Error(err)
+			}
+
+			err = f.Program(bytes.NewReader(img), size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.Erase(f.Chip.Size)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = f.BlankCheck(f.Chip.Size)
+			if err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestRawFlashEmpty(t *testing.T) {
+	_, c := testVirtualFC(t, testImage(t, 0, 2, 1, 0))
+	_, err := DetectRawFlash(c)
+	if err == nil {
+		t.Errorf("detected a chip in an empty socket")
+	}
+}
+
+func TestFlashChipSector(t *testing.T) {
+	chip, err := LookupFlashChip(0x01, 0xb0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct{ addr, start, n int }{
+		{0, 0, 0},
+		{0x2ffff, 0x20000, 2},
+		{0x30000, 0x30000, 3},
+		{0x3a000, 0x3a000, 5},
+		{0x3ffff, 0x3c000, 6},
+	} {
+		start, n := chip.sector(tc.addr)
+		if start != tc.start || n != tc.n {
+			t.Errorf("%s 0x%05x: sector %d at 0x%05x, want %d at 0x%05x", chip.Name, tc.addr, n, start, tc.n, tc.start)
+		}
+	}
+}
@@ -1,7 +1,15 @@
 package FCflash
 
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
 const PACKET_SIZE = 0x400
 
+const MESSAGE_SIZE = 8
+
 type Request uint8
 
 const (
@@ -27,15 +35,122 @@ const (
 	REQ_RAW_READ_WO_CS
 	REQ_RAW_WRITE_WO_CS
 	REQ_RAW_WRITE_LO_WO_CS
+	REQ_RAW_WRITE_EEP
 )
 const (
 	REQ_RAW_ERASE_FLASH Request = iota + 48
 	REQ_RAW_WRITE_FLASH
+	REQ_RAW_PROGRAM_FLASH
 )
 const (
 	REQ_GBM_WRITE_REGS Request = iota + 64
 )
 
+var requestNames = map[Request]string{
+	REQ_ECHO:                 "REQ_ECHO",
+	REQ_PHI2_INIT:            "REQ_PHI2_INIT",
+	REQ_CPU_READ_6502:        "REQ_CPU_READ_6502",
+	REQ_CPU_READ:             "REQ_CPU_READ",
+	REQ_CPU_WRITE_6502:       "REQ_CPU_WRITE_6502",
+	REQ_CPU_WRITE_6502_5BITS: "REQ_CPU_WRITE_6502_5BITS",
+	REQ_PPU_READ:             "REQ_PPU_READ",
+	REQ_PPU_WRITE:            "REQ_PPU_WRITE",
+	REQ_CPU_WRITE_EEP:        "REQ_CPU_WRITE_EEP",
+	REQ_PPU_WRITE_EEP:        "REQ_PPU_WRITE_EEP",
+	REQ_CPU_WRITE_FLASH:      "REQ_CPU_WRITE_FLASH",
+	REQ_RAW_READ:             "REQ_RAW_READ",
+	REQ_RAW_READ_LO:          "REQ_RAW_READ_LO",
+	REQ_RAW_WRITE:            "REQ_RAW_WRITE",
+	REQ_RAW_WRITE_LO:         "REQ_RAW_WRITE_LO",
+	REQ_RAW_READ_WO_CS:       "REQ_RAW_READ_WO_CS",
+	REQ_RAW_WRITE_WO_CS:      "REQ_RAW_WRITE_WO_CS",
+	REQ_RAW_WRITE_LO_WO_CS:   "REQ_RAW_WRITE_LO_WO_CS",
+	REQ_RAW_WRITE_EEP:        "REQ_RAW_WRITE_EEP",
+	REQ_RAW_ERASE_FLASH:      "REQ_RAW_ERASE_FLASH",
+	REQ_RAW_WRITE_FLASH:      "REQ_RAW_WRITE_FLASH",
+	REQ_RAW_PROGRAM_FLASH:    "REQ_RAW_PROGRAM_FLASH",
+	REQ_GBM_WRITE_REGS:       "REQ_GBM_WRITE_REGS",
+}
+
+func (r Request) String() string {
+	if s, ok := requestNames[r]; ok {
+		return s
+	}
+	return fmt.Sprintf("Request(%d)", uint8(r))
+}
+
+// Caps tells which groups of requests a firmware build handles.
+// REQ_ECHO returns them in Index, with the firmware version in the first byte.
+type Caps uint16
+
+const (
+	CAP_CPU_PPU        Caps = 1 << iota // REQ_CPU_READ, REQ_CPU_WRITE_6502(_5BITS), REQ_PPU_READ
+	CAP_EEP                             // REQ_CPU_WRITE_EEP, REQ_PPU_WRITE_EEP
+	CAP_FLASH                           // REQ_CPU_WRITE_FLASH
+	CAP_RAW                             // REQ_RAW_READ*, REQ_RAW_WRITE*
+	CAP_RAW_FLASH                       // REQ_RAW_ERASE_FLASH, REQ_RAW_WRITE_FLASH
+	CAP_GBM                             // REQ_GBM_WRITE_REGS
+	CAP_PHI2_INIT                       // REQ_PHI2_INIT
+	CAP_CPU_READ_6502                   // REQ_CPU_READ_6502
+	CAP_PPU_WRITE                       // REQ_PPU_WRITE
+	CAP_CPU_WRITE_ADDR                  // REQ_CPU_WRITE_6502 drives A1-A7 for $8000-$FFFF too
+	CAP_RAW_PROGRAM                     // REQ_RAW_PROGRAM_FLASH
+	CAP_RAW_EEP                         // REQ_RAW_WRITE_EEP
+)
+
+// CAPS_LEGACY is what builds from before versioning handle.
+const CAPS_LEGACY = CAP_CPU_PPU | CAP_EEP | CAP_FLASH | CAP_RAW | CAP_RAW_FLASH | CAP_GBM
+
+// The firmware/FCflash/FCflash.ino of this tree.
+const (
+	FIRMWARE_VERSION = 7
+	FIRMWARE_CAPS    = CAPS_LEGACY | CAP_PHI2_INIT | CAP_CPU_READ_6502 | CAP_PPU_WRITE | CAP_CPU_WRITE_ADDR | CAP_RAW_PROGRAM | CAP_RAW_EEP
+)
+
+var capNames = []string{"CPU_PPU", "EEP", "FLASH", "RAW", "RAW_FLASH", "GBM", "PHI2_INIT", "CPU_READ_6502", "PPU_WRITE", "CPU_WRITE_ADDR", "RAW_PROGRAM", "RAW_EEP"}
+
+func (c Caps) String() string {
+	var names []string
+	for i, name := range capNames {
+		if c&(1<<i) != 0 {
+			names = append(names, name)
+		}
+	}
+	return strings.Join(names, "|")
+}
+
+// Cap is the capability r needs, 0 for REQ_ECHO.
+func (r Request) Cap() Caps {
+	switch r {
+	case REQ_ECHO:
+		return 0
+	case REQ_PHI2_INIT:
+		return CAP_PHI2_INIT
+	case REQ_CPU_READ_6502:
+		return CAP_CPU_READ_6502
+	case REQ_CPU_READ, REQ_CPU_WRITE_6502, REQ_CPU_WRITE_6502_5BITS, REQ_PPU_READ:
+		return CAP_CPU_PPU
+	case REQ_PPU_WRITE:
+		return CAP_PPU_WRITE
+	case REQ_CPU_WRITE_EEP, REQ_PPU_WRITE_EEP:
+		return CAP_EEP
+	case REQ_CPU_WRITE_FLASH:
+		return CAP_FLASH
+	case REQ_RAW_READ, REQ_RAW_READ_LO, REQ_RAW_WRITE, REQ_RAW_WRITE_LO,
+		REQ_RAW_READ_WO_CS, REQ_RAW_WRITE_WO_CS, REQ_RAW_WRITE_LO_WO_CS:
+		return CAP_RAW
+	case REQ_RAW_ERASE_FLASH, REQ_RAW_WRITE_FLASH:
+		return CAP_RAW_FLASH
+	case REQ_RAW_PROGRAM_FLASH:
+		return CAP_RAW_PROGRAM
+	case REQ_RAW_WRITE_EEP:
+		return CAP_RAW_EEP
+	case REQ_GBM_WRITE_REGS:
+		return CAP_GBM
+	}
+	return 1 << 15 // unknown to every build
+}
+
 type Index uint16
 
 const (
@@ -45,10 +160,43 @@ const (
 	INDEX_BOTH
 )
 
+// Index of REQ_RAW_WRITE_EEP: the software data protection command
+// cycles before the page, at 5555H/2AAAH.
+const (
+	INDEX_SDP_WRITE   Index = 0xa0 // AAH 55H A0H: protect, and write the page
+	INDEX_SDP_DISABLE Index = 0x20 // AAH 55H 80H AAH 55H 20H: unprotect
+)
+
 type Message struct {
 	_reserverd uint8
 	Request    Request
 	Value      uint16
-	index      Index
+	Index      Index
 	Length     uint16
 }
+
+func (m *Message) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, MESSAGE_SIZE)
+	m.put(buf)
+	return buf, nil
+}
+
+func (m *Message) UnmarshalBinary(data []byte) error {
+	if len(data) != MESSAGE_SIZE {
+		return fmt.Errorf("%w: message: %d bytes", ErrInvalidLength, len(data))
+	}
+	m._reserverd = data[0]
+	m.Request = Request(data[1])
+	m.Value = binary.LittleEndian.Uint16(data[2:4])
+	m.Index = Index(binary.LittleEndian.Uint16(data[4:6]))
+	m.Length = binary.LittleEndian.Uint16(data[6:8])
+	return nil
+}
+
+func (m *Message) put(buf []byte) {
+	buf[0] = m._reserverd
+	buf[1] = uint8(m.Request)
+	binary.LittleEndian.PutUint16(buf[2:4], m.Value)
+	binary.LittleEndian.PutUint16(buf[4:6], uint16(m.Index))
+	binary.LittleEndian.PutUint16(buf[6:8], m.Length)
+}
@@ -0,0 +1,480 @@
+package FCflash
+
+import (
+	"fmt"
+	"io"
+)
+
+// SxROM is MMC1 with 16KB PRG banks and 4KB CHR banks. The boards
+// differ in what the upper bits of the CHR bank registers drive while
+// CHR is RAM, see sxromBoards.
+type SxROM struct{}
+
+func init() {
+	RegisterMapper(1, SxROM{})
+}
+
+func (SxROM) Name() string {
+	return "SxROM (MMC1)"
+}
+
+func (SxROM) Layout() Layout {
+	return Layout{PRGBank: 16 * 1024, CHRBank: 4 * 1024, MaxPRG: 32, MaxCHR: 16}
+}
+
+// sxromBoard is an SxROM variant.
+type sxromBoard struct {
+	name     string
+	maxPRG   int  // 16KB units
+	prg32    bool // PRG A14 is CPU A14, only 32KB mode reads all of it
+	ramSize  int  // bytes of PRG RAM
+	ramShift int  // lowest bit of the 8KB PRG RAM bank in the CHR bank registers, 0 if one bank
+}
+
+// Smallest first. SKROM, SLROM and the other CHR ROM boards are SNROM here:
+// their CHR bank bit 4 is CHR A16 instead of the PRG RAM disable, which
+// is 0 on both while the PRG is read.
+var sxromBoards = []sxromBoard{
+	{name: "SEROM/SHROM", maxPRG: 2, prg32: true},
+	{name: "SNROM", maxPRG: 16, ramSize: 8 * 1024},
+	{name: "SOROM", maxPRG: 16, ramSize: 16 * 1024, ramShift: 3},
+	{name: "SUROM", maxPRG: 32, ramSize: 8 * 1024},
+	{name: "SXROM", maxPRG: 32, ramSize: 32 * 1024, ramShift: 2},
+}
+
+// sxromFor picks the smallest board with prg 16KB units and ram bytes of PRG RAM.
+func sxromFor(prg, ram int) sxromBoard {
+	for _, b := range sxromBoards {
+		if prg <= b.maxPRG && ram <= b.ramSize {
+			return b
+		}
+	}
+	return sxromBoards[len(sxromBoards)-1]
+}
+
+// chrReg is the CHR bank register value for a 256KB PRG half and an 8KB PRG RAM bank.
+func (b sxromBoard) chrReg(outer, ram int) uint8 {
+	return uint8(outer<<4 | ram<<b.ramShift)
+}
+
+func (SxROM) DumpPRG(c *Client, w io.Writer, prg int) error {
+	b := sxromFor(prg, 0)
+	return pipe(c, w, func(p *Pipeline) error {
+		return b.dumpPRG(c, p, prg)
+	})
+}
+
+func (SxROM) DumpCHR(c *Client, w io.Writer, chr int) error {
+	// MMC1: reset
+	resetValue := uint8(0xff)
+	err := c.CPUWrite6502(0x8000, resetValue)
+	if err != nil {
+		return err
+	}
+	// MMC1: (from LSB)
+	// Mirroring 3: horizontal
+	// PRG ROM bank mode 3:$8000-$BFFF 16KB swappable
+	// CHR ROM bank mode 1:4KB two
+	bankControl := uint8(0b11111)
+	err = c.CPUWrite5Bits(0x8000, bankControl)
+	if err != nil {
+		return err
+	}
+
+	return pipe(c, w, func(p *Pipeline) error {
+		banks := (chr * 8 * 1024) >> 12
+		for bank := 0; bank < banks; bank += 2 {
+			err := c.CPUWrite5Bits(0xA000, uint8(bank))
+			if err != nil {
+				return err
+			}
+			err = c.CPUWrite5Bits(0xC000, uint8(bank+1))
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < 0x2000; i += PACKET_SIZE {
+				err = p.PPURead(uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// SamplePRG reads in 32KB mode, which SEROM and SHROM need too.
+func (SxROM) SamplePRG(c *Client, w io.Writer, offsets []int, n int) error {
+	// MMC1: reset
+	resetValue := uint8(0xff)
+	err := c.CPUWrite6502(0x8000, resetValue)
+	if err != nil {
+		return err
+	}
+	// MMC1: (from LSB)
+	// Mirroring 3: horizontal
+	// PRG ROM bank mode 0:$8000-$FFFF 32KB
+	// CHR ROM bank mode 0:8KB single
+	bankControl := uint8(0b00011)
+	err = c.CPUWrite5Bits(0x8000, bankControl)
+	if err != nil {
+		return err
+	}
+
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			err := c.CPUWrite5Bits(0xA000, sxromBoard{}.chrReg(o>>18, 0))
+			if err != nil {
+				return err
+			}
+			err = c.CPUWrite5Bits(0xE000, sxromRAMDisable|uint8(o>>14&0x0e))
+			if err != nil {
+				return err
+			}
+			err = p.CPURead(uint16(o&0x7fff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (SxROM) SampleCHR(c *Client, w io.Writer, offsets []int, n int) error {
+	// MMC1: reset
+	resetValue := uint8(0xff)
+	err := c.CPUWrite6502(0x8000, resetValue)
+	if err != nil {
+		return err
+	}
+	// MMC1: (from LSB)
+	// Mirroring 3: horizontal
+	// PRG ROM bank mode 3:$8000-$BFFF 16KB swappable
+	// CHR ROM bank mode 1:4KB two
+	bankControl := uint8(0b11111)
+	err = c.CPUWrite5Bits(0x8000, bankControl)
+	if err != nil {
+		return err
+	}
+
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			err := c.CPUWrite5Bits(0xA000, uint8(o>>12&0x1f))
+			if err != nil {
+				return err
+			}
+			err = p.PPURead(uint16(o&0x0fff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b sxromBoard) dumpPRG(c *Client, p *Pipeline, prg int) (err error) {
+	// MMC1: reset
+	resetValue := uint8(0xff)
+	err = c.CPUWrite6502(0x8000, resetValue)
+	if err != nil {
+		return err
+	}
+
+	if b.prg32 {
+		// MMC1: (from LSB)
+		// Mirroring 3: horizontal
+		// PRG ROM bank mode 0:$8000-$FFFF 32KB
+		// CHR ROM bank mode 0:8KB single
+		bankControl := uint8(0b00011)
+		err = c.CPUWrite5Bits(0x8000, bankControl)
+		if err != nil {
+			return err
+		}
+		err = c.CPUWrite5Bits(0xE000, sxromRAMDisable)
+		if err != nil {
+			return err
+		}
+		for i := 0; i < prg*16*1024; i += PACKET_SIZE {
+			err = p.CPURead(0x8000|uint16(i), PACKET_SIZE)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// MMC1: (from LSB)
+	// Mirroring 3: horizontal
+	// PRG ROM bank mode 3:$8000-$BFFF 16KB swappable
+	// CHR ROM bank mode 0:8KB single
+	bankControl := uint8(0b01111)
+	err = c.CPUWrite5Bits(0x8000, bankControl)
+	if err != nil {
+		return err
+	}
+
+	// SUROM, SXROM: 256KB halves
+	for outer := 0; outer*16 < prg; outer++ {
+		// MMC1: (from LSB)
+		// CHR RAM bank 0:(ignored in 8KB mode)
+		// PRG RAM bank 0
+		// PRG 256KB bank (PRG RAM disable on SNROM, 0:enable)
+		err = c.CPUWrite5Bits(0xA000, b.chrReg(outer, 0))
+		if err != nil {
+			return err
+		}
+		for bank := 0; bank < 16 && outer*16+bank < prg; bank++ {
+			err = c.CPUWrite5Bits(0xE000, sxromRAMDisable|uint8(bank))
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < 0x4000; i += PACKET_SIZE {
+				err = p.CPURead(0x8000|uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// MMC1B: PRG bank register bit 4, the save RAM is left alone while PRG is read
+const sxromRAMDisable = 0b10000
+
+// enableRAM maps the PRG RAM at $6000-$7FFF, or unmaps it.
+func (b sxromBoard) enableRAM(c *Client, enable bool) error {
+	// MMC1: reset
+	resetValue := uint8(0xff)
+	err := c.CPUWrite6502(0x8000, resetValue)
+	if err != nil {
+		return err
+	}
+	// MMC1: (from LSB)
+	// Mirroring 3: horizontal
+	// PRG ROM bank mode 3:$8000-$BFFF 16KB swappable
+	// CHR ROM bank mode 0:8KB single
+	bankControl := uint8(0b01111)
+	err = c.CPUWrite5Bits(0x8000, bankControl)
+	if err != nil {
+		return err
+	}
+	prgBank := uint8(sxromRAMDisable)
+	if enable {
+		prgBank = 0
+	}
+	return c.CPUWrite5Bits(0xE000, prgBank)
+}
+
+// DumpRAM reads size bytes of PRG RAM, in 8KB banks on SOROM and SXROM.
+func (SxROM) DumpRAM(c *Client, w io.Writer, size int) error {
+	err := checkRAM(SxROM{}, size, 32*1024)
+	if err != nil {
+		return err
+	}
+	b := sxromFor(0, size)
+	err = b.enableRAM(c, true)
+	if err != nil {
+		return err
+	}
+
+	err = pipe(c, w, func(p *Pipeline) error {
+		for bank := 0; bank < size>>13; bank++ {
+			// MMC1: (from LSB)
+			// PRG RAM bank (PRG RAM disable on SNROM, 0:enable)
+			err := c.CPUWrite5Bits(0xA000, b.chrReg(0, bank))
+			if err != nil {
+				return err
+			}
+			err = dumpWRAM(p)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return b.enableRAM(c, false)
+}
+
+// WriteRAM restores size bytes of PRG RAM from r.
+func (SxROM) WriteRAM(c *Client, r io.ReaderAt, size int) error {
+	err := checkRAM(SxROM{}, size, 32*1024)
+	if err != nil {
+		return err
+	}
+	b := sxromFor(0, size)
+	err = b.enableRAM(c, true)
+	if err != nil {
+		return err
+	}
+
+	for bank := 0; bank < size>>13; bank++ {
+		err = c.CPUWrite5Bits(0xA000, b.chrReg(0, bank))
+		if err != nil {
+			return err
+		}
+		err = writeWRAM(c, r, int64(bank)<<13)
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("")
+	return b.enableRAM(c, false)
+}
+
+// writeSxromBanks programs every other 16KB bank of one 256KB half.
+// Even banks go through $8000 (addr15 0x0000-), odd banks through $C000 (addr15 0x4000-).
+func writeSxromBanks(c *Client, r io.ReaderAt, first, banks int, offset int64, window uint16, v *Verifier, buf []uint8) (err error) {
+	for bank := first; bank < banks; bank += 2 {
+		err = c.CPUWrite5Bits(0xE000, uint8(bank))
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < 0x4000; i += PACKET_SIZE {
+			fmt.Printf(".")
+
+			_, err = r.ReadAt(buf, offset+16*1024*int64(bank)+int64(i))
+			if err != nil {
+				return err
+			}
+
+			addr15 := window | uint16(i)
+			err = v.Packet(int(offset>>14)+bank, i, buf, func(b []byte) error {
+				return c.CPUWriteFlash(addr15, b)
+			}, func(b []byte) error {
+				return c.CPURead(addr15, b)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Println("")
+	return nil
+}
+
+func (SxROM) WritePRG(c *Client, r io.ReaderAt, prg int) (err error) {
+	buf := make([]uint8, PACKET_SIZE)
+	v := c.NewVerifier("PRG")
+
+	// MMC1: reset
+	resetValue := uint8(0xff)
+	err = c.CPUWrite6502(0x8000, resetValue)
+	if err != nil {
+		return err
+	}
+
+	// for SUROM
+	banks1st := 0
+	banks2nd := 0
+	if prg <= 16 {
+		banks1st = prg
+	} else {
+		banks1st = 16
+		banks2nd = prg - 16
+	}
+
+	// ------------------------
+	// for even banks
+	// ------------------------
+
+	// MMC1: (from LSB)
+	// Mirroring 3: horizontal
+	// PRG ROM bank mode 3:$8000-$BFFF 16KB swappable
+	// CHR ROM bank mode 0:8KB single
+	bankControl := uint8(0b01111)
+	err = c.CPUWrite5Bits(0x8000, bankControl)
+	if err != nil {
+		return err
+	}
+
+	// 1st
+	//
+	// MMC1: (from LSB)
+	// CHR RAM bank 0:(ignored in 8KB mode)
+	// xxx
+	// PRG 256KB bank 0:1st (PRG RAM disable 0:enable)
+	chrBank := uint8(0b00000)
+	err = c.CPUWrite5Bits(0xA000, chrBank)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("even 1st: ")
+	err = writeSxromBanks(c, r, 0, banks1st, 0, 0x0000, v, buf)
+	if err != nil {
+		return err
+	}
+
+	// 2nd
+	//
+	// MMC1: (from LSB)
+	// CHR RAM bank 0:(ignored in 8KB mode)
+	// xxx
+	// PRG 256KB bank 1:2nd (PRG RAM disable 1:open bus)
+	chrBank = uint8(0b10000)
+	err = c.CPUWrite5Bits(0xA000, chrBank)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("even 2nd: ")
+	err = writeSxromBanks(c, r, 0, banks2nd, 256*1024, 0x0000, v, buf)
+	if err != nil {
+		return err
+	}
+
+	// ------------------------
+	// for odd banks
+	// ------------------------
+
+	// MMC1: (from LSB)
+	// Mirroring 3: horizontal
+	// PRG ROM bank mode 2:$C000-$FFFF 16KB swappable
+	// CHR ROM bank mode 0:8KB single
+	bankControl = uint8(0b01011)
+	err = c.CPUWrite5Bits(0x8000, bankControl)
+	if err != nil {
+		return err
+	}
+
+	// 1st
+	//
+	// MMC1: (from LSB)
+	// CHR RAM bank 0:(ignored in 8KB mode)
+	// xxx
+	// PRG 256KB bank 0:1st (PRG RAM disable 0:enable)
+	chrBank = uint8(0b00000)
+	err = c.CPUWrite5Bits(0xA000, chrBank)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("odd  1st: ")
+	err = writeSxromBanks(c, r, 1, banks1st, 0, 0x4000, v, buf)
+	if err != nil {
+		return err
+	}
+
+	// 2nd
+	//
+	// MMC1: (from LSB)
+	// CHR RAM bank 0:(ignored in 8KB mode)
+	// xxx
+	// PRG 256KB bank 1:2nd (PRG RAM disable 1:open bus)
+	chrBank = uint8(0b10000)
+	err = c.CPUWrite5Bits(0xA000, chrBank)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("odd  2nd: ")
+	err = writeSxromBanks(c, r, 1, banks2nd, 256*1024, 0x4000, v, buf)
+	if err != nil {
+		return err
+	}
+
+	return v.Err()
+}
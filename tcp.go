@@ -0,0 +1,219 @@
+package FCflash
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrBusy = errors.New("reader busy")
+
+// The server greets each connection with one line before any reader traffic:
+// TCP_GREETING, or "busy <client>" just before hanging up.
+const TCP_GREETING = "FCflash"
+
+const TCP_DIAL_TIMEOUT = 5 * time.Second
+
+// OpenTCP connects to a reader exported by Server.
+func OpenTCP(addr string) (Transport, error) {
+	conn, err := net.DialTimeout("tcp", addr, TCP_DIAL_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(TCP_DIAL_TIMEOUT))
+	line, err := readLine(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%s: %w", addr, err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if line != TCP_GREETING {
+		conn.Close()
+		if strings.HasPrefix(line, "busy ") {
+			return nil, fmt.Errorf("%s: %w: in use by %s", addr, ErrBusy, line[len("busy "):])
+		}
+		return nil, fmt.Errorf("%s: not an FCflash server: %q", addr, line)
+	}
+	return NewTransport(conn), nil
+}
+
+// readLine reads byte by byte so that nothing after the line is consumed.
+func readLine(r io.Reader) (string, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < 256 {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return "", err
+		}
+		if b[0] == '\n' {
+			return string(line), nil
+		}
+		line = append(line, b[0])
+	}
+	return "", errors.New("greeting too long")
+}
+
+// Server exports a reader over TCP to one client at a time.
+// It only moves bytes, so it works with any firmware. Between clients
+// it resynchronises the AVR firmware from REQ_ECHO on, see resync.
+type Server struct {
+	// Logf reports connections if set.
+	Logf func(format string, v ...interface{})
+
+	t    Transport
+	info FirmwareInfo
+	mu   sync.Mutex
+	conn net.Conn
+	link *io.PipeWriter // replies go here instead while resync runs
+	err  error
+}
+
+// NewServer identifies the firmware on t and starts forwarding its
+// replies. Replies that arrive while no client is connected are dropped.
+func NewServer(t Transport) *Server {
+	info, _ := Identify(t, PROBE_TIMEOUT)
+	s := &Server{t: t, info: info}
+	go s.forward()
+	return s
+}
+
+func (s *Server) logf(format string, v ...interface{}) {
+	if s.Logf != nil {
+		s.Logf(format, v...)
+	}
+}
+
+func (s *Server) forward() {
+	buf := make([]byte, 4096)
+	for {
+		n, err := s.t.Read(buf)
+		if n > 0 {
+			s.mu.Lock()
+			switch {
+			case s.link != nil:
+				s.link.Write(buf[:n])
+			case s.conn != nil:
+				s.conn.Write(buf[:n])
+			}
+			s.mu.Unlock()
+		}
+		if err == io.EOF && n == 0 {
+			// a simulated reader with nothing to say
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		if err != nil {
+			s.mu.Lock()
+			s.err = err
+			if s.conn != nil {
+				s.conn.Close()
+			}
+			s.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Serve accepts connections on l until it fails or the reader is gone.
+func (s *Server) Serve(l net.Listener) error {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+
+		var link *io.PipeReader
+		s.mu.Lock()
+		err = s.err
+		busy := s.conn
+		if err == nil && busy == nil {
+			if s.info.Firmware == FIRMWARE_AVR && s.info.Version > 0 {
+				link, s.link = io.Pipe()
+			} else {
+				// greet before any reply can be forwarded
+				fmt.Fprintf(conn, "%s\n", TCP_GREETING)
+			}
+			s.conn = conn
+		}
+		s.mu.Unlock()
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		if busy != nil {
+			s.logf("%s: refused, in use by %s", conn.RemoteAddr(), busy.RemoteAddr())
+			fmt.Fprintf(conn, "busy %s\n", busy.RemoteAddr())
+			conn.Close()
+			continue
+		}
+
+		s.logf("%s: connected", conn.RemoteAddr())
+		go s.session(conn, link)
+	}
+}
+
+func (s *Server) session(conn net.Conn, link *io.PipeReader) {
+	if link != nil {
+		err := s.resync(link)
+		if err != nil {
+			s.logf("%s: resync: %v", conn.RemoteAddr(), err)
+		}
+		// unblocks forward if it is writing to the link
+		link.Close()
+		s.mu.Lock()
+		s.link = nil
+		// greet before any reply can be forwarded
+		fmt.Fprintf(conn, "%s\n", TCP_GREETING)
+		s.mu.Unlock()
+	}
+
+	_, err := io.Copy(s.t, conn)
+	s.mu.Lock()
+	s.conn = nil
+	s.mu.Unlock()
+	conn.Close()
+	if err != nil {
+		s.logf("%s: %v", conn.RemoteAddr(), err)
+	}
+	s.logf("%s: disconnected", conn.RemoteAddr())
+}
+
+// resync brings the reader back to the start of a message: the last client
+// may have hung up halfway through a request or before its reply. All zero
+// is REQ_ECHO, so zeros first make up a payload the firmware still waits
+// for, then one at a time finish the message it is in the middle of,
+// which it answers. The replies and any left over are dropped.
+func (s *Server) resync(r io.Reader) error {
+	t := NewTransport(struct {
+		io.Reader
+		io.Writer
+	}{r, s.t})
+	defer t.Close()
+
+	_, err := t.Write(make([]byte, MESSAGE_SIZE+PACKET_SIZE))
+	if err != nil {
+		return err
+	}
+	_, err = t.Drain(50 * time.Millisecond)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < MESSAGE_SIZE; i++ {
+		_, err = t.Write([]byte{0})
+		if err != nil {
+			return err
+		}
+		n, err := t.Drain(50 * time.Millisecond)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			break
+		}
+	}
+	return NewClient(t).Resync()
+}
@@ -0,0 +1,118 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// testServer exports a simulated reader of img on a local port.
+func testServer(t *testing.T, img []byte) (*VirtualFC, string) {
+	t.Helper()
+	v, err := NewVirtualFC(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go NewServer(v).Serve(l)
+	return v, l.Addr().String()
+}
+
+func TestServer(t *testing.T) {
+	v, addr := testServer(t, testImage(t, 0, 2, 1, 0))
+	tr, err := OpenTCP(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(readCPU(t, NewClient(tr), 0x0000, 0x0800), v.PRG[:0x0800]) {
+		t.Errorf("PRG over TCP differs")
+	}
+
+	// one client at a time
+	_, err = OpenTCP(addr)
+	if !errors.Is(err, ErrBusy) {
+		t.Errorf("second client: got %v, want ErrBusy", err)
+	}
+	tr.Close()
+}
+
+func TestOpenTCPGreeting(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		conn.Write([]byte("SSH-2.0-OpenSSH\r\n"))
+		conn.Close()
+	}()
+	_, err = OpenTCP(l.Addr().String())
+	if err == nil || errors.Is(err, ErrBusy) {
+		t.Errorf("got %v, want not an FCflash server", err)
+	}
+}
+
+// TestServerResync has a client hang up in the middle of a request, and
+// the next one read PRG through the same server.
+func TestServerResync(t *testing.T) {
+	read := Message{Request: REQ_CPU_READ, Value: 0x0000, Length: PACKET_SIZE}
+	write := Message{Request: REQ_PPU_WRITE, Value: 0x0000, Length: PACKET_SIZE}
+	for _, tc := range []struct {
+		name string
+		left func() []byte
+	}{
+		{"unread reply", func() []byte {
+			b, _ := read.MarshalBinary()
+			return b
+		}},
+		{"half a message", func() []byte {
+			b, _ := read.MarshalBinary()
+			return b[:3]
+		}},
+		{"half a payload", func() []byte {
+			b, _ := write.MarshalBinary()
+			return append(b, make([]byte, 100)...)
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v, addr := testServer(t, testImage(t, 0, 2, 0, 0))
+			tr, err := OpenTCP(addr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = tr.Write(tc.left())
+			if err != nil {
+				t.Fatal(err)
+			}
+			tr.Close()
+
+			// the server may not have seen the first client go yet
+			for i := 0; ; i++ {
+				tr, err = OpenTCP(addr)
+				if !errors.Is(err, ErrBusy) || i == 100 {
+					break
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tr.Close()
+			c := NewClient(tr)
+			c.Timeout = time.Second
+			if !bytes.Equal(readCPU(t, c, 0x0000, 0x0800), v.PRG[:0x0800]) {
+				t.Errorf("PRG over TCP differs")
+			}
+		})
+	}
+}
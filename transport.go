@@ -0,0 +1,220 @@
+package FCflash
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTimeout   = errors.New("timeout")
+	ErrShortRead = errors.New("short read")
+	ErrClosed    = errors.New("transport closed")
+)
+
+// Transport is a byte stream to a reader whose reads and writes can be bounded by a context.
+type Transport interface {
+	io.ReadWriteCloser
+	// ReadFullContext reads exactly len(p) bytes unless ctx is done first.
+	ReadFullContext(ctx context.Context, p []byte) (int, error)
+	WriteContext(ctx context.Context, p []byte) (int, error)
+	// Drain discards input until nothing has arrived for quiet.
+	Drain(quiet time.Duration) (int, error)
+}
+
+type chunk struct {
+	b   []byte
+	err error
+}
+
+type streamTransport struct {
+	rw      io.ReadWriter
+	ch      chan chunk
+	done    chan struct{}
+	once    sync.Once
+	idleEOF bool
+	pending []byte
+	err     error
+	wmu     sync.Mutex
+}
+
+// NewTransport wraps a blocking stream such as a serial port.
+// A goroutine keeps reading rw so that callers can give up on a stuck device.
+func NewTransport(rw io.ReadWriter) Transport {
+	return newTransport(rw, false)
+}
+
+// idleEOF is for ports opened with a read timeout, which return io.EOF
+// when nothing arrived in time.
+func newTransport(rw io.ReadWriter, idleEOF bool) *streamTransport {
+	t := &streamTransport{rw: rw, ch: make(chan chunk, 16), done: make(chan struct{}), idleEOF: idleEOF}
+	go t.pump()
+	return t
+}
+
+func (t *streamTransport) pump() {
+	defer close(t.ch)
+	for {
+		buf := make([]byte, 4096)
+		n, err := t.rw.Read(buf)
+		select {
+		case <-t.done:
+			return
+		default:
+		}
+		if n > 0 {
+			select {
+			case t.ch <- chunk{b: buf[:n]}:
+			case <-t.done:
+				return
+			}
+		}
+		if err == io.EOF && n == 0 && t.idleEOF {
+			continue
+		}
+		if err != nil {
+			select {
+			case t.ch <- chunk{err: err}:
+			case <-t.done:
+			}
+			return
+		}
+	}
+}
+
+func (t *streamTransport) recv(c chunk, ok bool) {
+	if !ok {
+		t.err = ErrClosed
+		return
+	}
+	if c.err != nil {
+		t.err = c.err
+		return
+	}
+	t.pending = append(t.pending, c.b...)
+}
+
+func timeoutError(ctx context.Context, n, want int) error {
+	if ctx.Err() != context.DeadlineExceeded {
+		return ctx.Err()
+	}
+	if n == 0 {
+		return fmt.Errorf("%w: no reply", ErrTimeout)
+	}
+	return fmt.Errorf("%w: %d/%d bytes", ErrShortRead, n, want)
+}
+
+func (t *streamTransport) ReadFullContext(ctx context.Context, p []byte) (int, error) {
+	n := 0
+	for {
+		m := copy(p[n:], t.pending)
+		t.pending = t.pending[m:]
+		n += m
+		if n == len(p) {
+			return n, nil
+		}
+		if t.err != nil {
+			return n, t.err
+		}
+
+		select {
+		case c, ok := <-t.ch:
+			t.recv(c, ok)
+		case <-ctx.Done():
+			return n, timeoutError(ctx, n, len(p))
+		}
+	}
+}
+
+func (t *streamTransport) Read(p []byte) (int, error) {
+	if len(t.pending) == 0 && t.err == nil {
+		c, ok := <-t.ch
+		t.recv(c, ok)
+	}
+	if len(t.pending) == 0 {
+		return 0, t.err
+	}
+	n := copy(p, t.pending)
+	t.pending = t.pending[n:]
+	return n, nil
+}
+
+func (t *streamTransport) Write(p []byte) (int, error) {
+	t.wmu.Lock()
+	defer t.wmu.Unlock()
+	return t.rw.Write(p)
+}
+
+// writeDeadliner is a stream whose blocked writes can be cut short, such as a net.Conn.
+type writeDeadliner interface {
+	SetWriteDeadline(t time.Time) error
+}
+
+// WriteContext does not return before the write does, so that a message it
+// gave up on never reaches the wire after the next one. When ctx is done
+// first, the write is cut short by a deadline in the past, or on streams
+// without deadlines by closing the transport.
+func (t *streamTransport) WriteContext(ctx context.Context, p []byte) (int, error) {
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		n, err := t.Write(p)
+		done <- result{n, err}
+	}()
+	select {
+	case r := <-done:
+		return r.n, r.err
+	case <-ctx.Done():
+	}
+	if d, ok := t.rw.(writeDeadliner); ok {
+		d.SetWriteDeadline(time.Now())
+		r := <-done
+		d.SetWriteDeadline(time.Time{})
+		if r.err == nil {
+			return r.n, nil
+		}
+		return r.n, timeoutError(ctx, 0, len(p))
+	}
+	t.Close()
+	r := <-done
+	if r.err == nil {
+		return r.n, nil
+	}
+	return r.n, fmt.Errorf("%w, closed", timeoutError(ctx, 0, len(p)))
+}
+
+func (t *streamTransport) Drain(quiet time.Duration) (int, error) {
+	n := len(t.pending)
+	t.pending = nil
+	timer := time.NewTimer(quiet)
+	defer timer.Stop()
+	for t.err == nil {
+		select {
+		case c, ok := <-t.ch:
+			t.recv(c, ok)
+			n += len(t.pending)
+			t.pending = nil
+			if !timer.Stop() {
+				<-timer.C
+			}
+			timer.Reset(quiet)
+		case <-timer.C:
+			return n, nil
+		}
+	}
+	return n, t.err
+}
+
+func (t *streamTransport) Close() error {
+	t.once.Do(func() { close(t.done) })
+	if c, ok := t.rw.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
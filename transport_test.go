@@ -0,0 +1,206 @@
+package FCflash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// testReader is a reader on the far end of a pipe that echoes REQ_ECHO
+// and answers REQ_CPU_READ with the low bytes of the addresses. It
+// ignores the first lose reads, as if their requests had been garbled.
+func testReader(t *testing.T, lose int) Transport {
+	t.Helper()
+	host, dev := net.Pipe()
+	go func() {
+		defer dev.Close()
+		buf := make([]byte, MESSAGE_SIZE)
+		for {
+			_, err := io.ReadFull(dev, buf)
+			if err != nil {
+				return
+			}
+			var m Message
+			m.UnmarshalBinary(buf)
+			switch m.Request {
+			case REQ_ECHO:
+				dev.Write(buf)
+			case REQ_CPU_READ:
+				if lose > 0 {
+					lose--
+					continue
+				}
+				reply := make([]byte, m.Length)
+				for i := range reply {
+					reply[i] = uint8(m.Value) + uint8(i)
+				}
+				dev.Write(reply)
+			}
+		}
+	}()
+	tr := NewTransport(host)
+	t.Cleanup(func() { tr.Close() })
+	return tr
+}
+
+func TestTransportTimeout(t *testing.T) {
+	tr := testReader(t, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := tr.ReadFullContext(ctx, make([]byte, 1))
+	if !errors.Is(err, ErrTimeout) {
+		t.Errorf("no reply: got %v, want ErrTimeout", err)
+	}
+
+	// a reply shorter than asked for
+	m := Message{Request: REQ_CPU_READ, Length: 4}
+	b, _ := m.MarshalBinary()
+	// the first read is lost
+	tr.Write(b)
+	tr.Write(b)
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	n, err := tr.ReadFullContext(ctx, make([]byte, 8))
+	if n != 4 || !errors.Is(err, ErrShortRead) {
+		t.Errorf("4 of 8 bytes: got %d, %v, want ErrShortRead", n, err)
+	}
+}
+
+func TestTransportCancel(t *testing.T) {
+	tr := testReader(t, 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := tr.ReadFullContext(ctx, make([]byte, 1))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestTransportDrain(t *testing.T) {
+	tr := testReader(t, 0)
+	m := Message{Request: REQ_CPU_READ, Length: 16}
+	b, _ := m.MarshalBinary()
+	tr.Write(b)
+	n, err := tr.Drain(50 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 16 {
+		t.Errorf("drained %d bytes, want 16", n)
+	}
+}
+
+// blockingPort is a stream without deadlines to a device that never reads.
+// Its writes block until it is closed.
+type blockingPort struct {
+	r, w *io.PipeReader
+	pw   *io.PipeWriter
+}
+
+func newBlockingPort() *blockingPort {
+	r, _ := io.Pipe()
+	w, pw := io.Pipe()
+	return &blockingPort{r: r, w: w, pw: pw}
+}
+
+func (p *blockingPort) Read(b []byte) (int, error)  { return p.r.Read(b) }
+func (p *blockingPort) Write(b []byte) (int, error) { return p.pw.Write(b) }
+func (p *blockingPort) Close() error {
+	p.r.Close()
+	return p.w.Close()
+}
+
+func TestTransportWriteTimeout(t *testing.T) {
+	// a pipe has write deadlines: the write is cut short and the stream stays open
+	host, dev := net.Pipe()
+	tr := NewTransport(host)
+	defer tr.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := tr.WriteContext(ctx, []byte{1, 2, 3, 4})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("got %v, want ErrTimeout", err)
+	}
+	go tr.WriteContext(context.Background(), []byte{5, 6})
+	buf := make([]byte, 4)
+	n, err := dev.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf[:n], []byte{5, 6}) {
+		t.Errorf("got % x after the timeout, want 05 06", buf[:n])
+	}
+
+	// without deadlines the transport is closed, and with it the write
+	tr = NewTransport(newBlockingPort())
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = tr.WriteContext(ctx, []byte{1, 2, 3, 4})
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("no deadlines: got %v, want ErrTimeout", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = tr.WriteContext(ctx, []byte{5, 6})
+	if !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("no deadlines: got %v after the timeout, want io.ErrClosedPipe", err)
+	}
+}
+
+func TestClientRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		retries int
+		want    error
+	}{
+		{"no retry", 0, ErrTimeout},
+		{"retry", 1, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewClient(testReader(t, 1))
+			c.Timeout = 50 * time.Millisecond
+			c.Retries = tc.retries
+			buf := make([]byte, 4)
+			err := c.CPURead(0x10, buf)
+			if !errors.Is(err, tc.want) {
+				t.Fatalf("got %v, want %v", err, tc.want)
+			}
+			if err == nil && !bytes.Equal(buf, []byte{0x10, 0x11, 0x12, 0x13}) {
+				t.Errorf("retried read: % x", buf)
+			}
+		})
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := NewClient(testReader(t, 1)).WithContext(ctx)
+	c.Timeout = 0
+	c.Retries = 3
+	time.AfterFunc(50*time.Millisecond, cancel)
+	err := c.CPURead(0, make([]byte, 1))
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestClientRetryUnversioned(t *testing.T) {
+	c := NewClient(testReader(t, 1))
+	info, err := c.Handshake()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Version != 0 {
+		t.Fatalf("got %v, want unversioned", info)
+	}
+	c.Timeout = 50 * time.Millisecond
+	c.Retries = 1
+	err = c.CPURead(0x10, make([]byte, 4))
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v, want ErrUnsupported", err)
+	}
+}
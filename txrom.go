@@ -0,0 +1,301 @@
+package FCflash
+
+import (
+	"fmt"
+	"io"
+)
+
+// TxROM is MMC3: 8KB PRG banks at R6/R7, 1KB and 2KB CHR banks.
+// Reproduction boards with PRG and CHR flash can be written.
+type TxROM struct{}
+
+func init() {
+	RegisterMapper(4, TxROM{})
+}
+
+func (TxROM) Name() string {
+	return "TxROM (MMC3)"
+}
+
+func (TxROM) Layout() Layout {
+	return Layout{PRGBank: 8 * 1024, CHRBank: 2 * 1024, MaxPRG: 32, MaxCHR: 32}
+}
+
+func (TxROM) DumpPRG(c *Client, w io.Writer, prg int) error {
+	// MMC3: PRG RAM disable, the save RAM is left alone while PRG is read
+	err := c.CPUWrite6502(0xA001, txromRAMDisable)
+	if err != nil {
+		return err
+	}
+	// MMC3: PRG ROM R6:$8000-$9FFF swappable
+	bankSelect := uint8(0b00000110)
+	err = c.CPUWrite6502(0x8000, bankSelect)
+	if err != nil {
+		return err
+	}
+
+	return pipe(c, w, func(p *Pipeline) error {
+		banks := (prg * 16 * 1024) >> 13
+		for bank := 0; bank < banks; bank++ {
+			err := c.CPUWrite6502(0x8001, uint8(bank))
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < 0x2000; i += PACKET_SIZE {
+				err = p.CPURead(0x8000|uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (TxROM) DumpCHR(c *Client, w io.Writer, chr int) error {
+	// MMC3: CHR ROM R0:$0000-$07FF swappable
+	bankSelect := uint8(0b00000000)
+	err := c.CPUWrite6502(0x8000, bankSelect)
+	if err != nil {
+		return err
+	}
+
+	return pipe(c, w, func(p *Pipeline) error {
+		banks := (chr * 8 * 1024) >> 10
+		for bank := 0; bank < banks; bank += 2 {
+			err := c.CPUWrite6502(0x8001, uint8(bank))
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < 0x800; i += PACKET_SIZE {
+				err = p.PPURead(uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (TxROM) SamplePRG(c *Client, w io.Writer, offsets []int, n int) error {
+	err := c.CPUWrite6502(0xA001, txromRAMDisable)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			// MMC3: PRG ROM R6:$8000-$9FFF swappable
+			err := txromSelect(c, 0b00000110, uint8(o>>13))
+			if err != nil {
+				return err
+			}
+			err = p.CPURead(uint16(o&0x1fff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (TxROM) SampleCHR(c *Client, w io.Writer, offsets []int, n int) error {
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			// MMC3: CHR ROM R2:$1000-$13FF swappable
+			err := txromSelect(c, 0b00000010, uint8(o>>10))
+			if err != nil {
+				return err
+			}
+			err = p.PPURead(0x1000|uint16(o&0x03ff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// txromSelect writes an MMC3 bank register: $8000 selects, $8001 sets.
+func txromSelect(c *Client, sel, value uint8) error {
+	err := c.CPUWrite6502(0x8000, sel)
+	if err != nil {
+		return err
+	}
+	return c.CPUWrite6502(0x8001, value)
+}
+
+// WritePRG programs a PRG flash 8KB at a time through R6 at $8000.
+// The unlock cycles land on R7 at $A000 (0x2AAA: bank 1) and on the fixed
+// second-last bank at $C000 (0x5555: A14 1, A13 0).
+func (TxROM) WritePRG(c *Client, r io.ReaderAt, prg int) error {
+	buf := make([]uint8, PACKET_SIZE)
+	v := c.NewVerifier("PRG")
+
+	err := c.CPUWrite6502(0xA001, txromRAMDisable)
+	if err != nil {
+		return err
+	}
+	// MMC3: PRG ROM R7:$A000-$BFFF
+	err = txromSelect(c, 0b00000111, 1)
+	if err != nil {
+		return err
+	}
+	// MMC3: PRG ROM R6:$8000-$9FFF swappable
+	bankSelect := uint8(0b00000110)
+	err = c.CPUWrite6502(0x8000, bankSelect)
+	if err != nil {
+		return err
+	}
+
+	banks := (prg * 16 * 1024) >> 13
+	for bank := 0; bank < banks; bank++ {
+		err = c.CPUWrite6502(0x8001, uint8(bank))
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < 0x2000; i += PACKET_SIZE {
+			fmt.Printf(".")
+
+			_, err = r.ReadAt(buf, int64(bank)<<13+int64(i))
+			if err != nil {
+				return err
+			}
+
+			addr15 := uint16(i)
+			err = v.Packet(bank, i, buf, func(b []byte) error {
+				return c.CPUWriteFlash(addr15, b)
+			}, func(b []byte) error {
+				return c.CPURead(addr15, b)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Println("")
+	return v.Err()
+}
+
+// WriteCHR programs a CHR flash 2KB at a time like SxROM does the PRG:
+// the first halves of the 4KB sectors go through R0 at $0000, which
+// erases them, then the second halves through R1 at $1800 with A12
+// inverted. In both modes 1KB banks route 0x5555 and 0x2AAA.
+func (TxROM) WriteCHR(c *Client, r io.ReaderAt, chr int) error {
+	buf := make([]uint8, PACKET_SIZE)
+	v := c.NewVerifier("CHR")
+	banks := (chr * 8 * 1024) >> 10
+
+	// even
+	//
+	// MMC3: CHR R1:$0800-$0FFF 0x2AAA, R3:$1400-$17FF 0x5555
+	err := txromSelect(c, 0b00000001, 0x0a)
+	if err != nil {
+		return err
+	}
+	err = txromSelect(c, 0b00000011, 0x15)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("even: ")
+	err = writeTxromCHR(c, r, 0, banks, 0b00000000, 0x0000, v, buf)
+	if err != nil {
+		return err
+	}
+
+	// odd
+	//
+	// MMC3: CHR A12 inversion
+	// R0:$1000-$17FF 0x5555, R4:$0800-$0BFF 0x2AAA
+	err = txromSelect(c, 0b10000000, 0x14)
+	if err != nil {
+		return err
+	}
+	err = txromSelect(c, 0b10000100, 0x0a)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("odd:  ")
+	err = writeTxromCHR(c, r, 2, banks, 0b10000001, 0x1800, v, buf)
+	if err != nil {
+		return err
+	}
+	return v.Err()
+}
+
+// writeTxromCHR programs every other 2KB bank through the 2KB register sel at window.
+func writeTxromCHR(c *Client, r io.ReaderAt, first, banks int, sel uint8, window uint16, v *Verifier, buf []uint8) error {
+	for bank := first; bank < banks; bank += 4 {
+		err := txromSelect(c, sel, uint8(bank))
+		if err != nil {
+			return err
+		}
+
+		for i := 0; i < 0x800; i += PACKET_SIZE {
+			fmt.Printf(".")
+
+			_, err = r.ReadAt(buf, int64(bank)<<10+int64(i))
+			if err != nil {
+				return err
+			}
+
+			addr := window | uint16(i)
+			err = v.Packet(bank, i, buf, func(b []byte) error {
+				return c.PPUWriteFlash(addr, b)
+			}, func(b []byte) error {
+				return c.PPURead(addr, b)
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Println("")
+	return nil
+}
+
+// MMC3: PRG RAM protect $A001 (from MSB)
+// 1:enable, 1:deny writes
+const (
+	txromRAMDisable = uint8(0b00000000)
+	txromRAMRead    = uint8(0b11000000)
+	txromRAMWrite   = uint8(0b10000000)
+)
+
+// DumpRAM reads the 8KB PRG RAM write-protected.
+func (TxROM) DumpRAM(c *Client, w io.Writer, size int) error {
+	err := checkRAM(TxROM{}, size, 8*1024)
+	if err != nil {
+		return err
+	}
+	err = c.CPUWrite6502(0xA001, txromRAMRead)
+	if err != nil {
+		return err
+	}
+	err = pipe(c, w, dumpWRAM)
+	if err != nil {
+		return err
+	}
+	return c.CPUWrite6502(0xA001, txromRAMDisable)
+}
+
+// WriteRAM restores the 8KB PRG RAM from r.
+func (TxROM) WriteRAM(c *Client, r io.ReaderAt, size int) error {
+	err := checkRAM(TxROM{}, size, 8*1024)
+	if err != nil {
+		return err
+	}
+	err = c.CPUWrite6502(0xA001, txromRAMWrite)
+	if err != nil {
+		return err
+	}
+	err = writeWRAM(c, r, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Println("")
+	return c.CPUWrite6502(0xA001, txromRAMDisable)
+}
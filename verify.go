@@ -0,0 +1,116 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var ErrVerify = errors.New("verify failed")
+
+// Mismatch is a packet that did not read back as written: its first
+// differing byte and how many differ.
+type Mismatch struct {
+	What   string // PRG, CHR or RAW
+	Bank   int    // in the units the write mode switches
+	Offset int    // in the bank
+	Want   uint8
+	Got    uint8
+	Bytes  int
+}
+
+func (m Mismatch) String() string {
+	return fmt.Sprintf("%s bank %d offset 0x%04x: want 0x%02x, got 0x%02x (%d bytes differ)", m.What, m.Bank, m.Offset, m.Want, m.Got, m.Bytes)
+}
+
+// VerifyError lists the packets that still differed after the retries.
+type VerifyError struct {
+	Mismatches []Mismatch
+}
+
+func (e *VerifyError) Error() string {
+	l := make([]string, len(e.Mismatches))
+	for i, m := range e.Mismatches {
+		l[i] = m.String()
+	}
+	return fmt.Sprintf("%v: %d packets: %s", ErrVerify, len(e.Mismatches), strings.Join(l, "; "))
+}
+
+func (e *VerifyError) Unwrap() error {
+	return ErrVerify
+}
+
+// Verifier reads back each packet a write mode programs and writes it
+// again while it differs, Client.WriteRetries times at most. The firmware
+// erases a flash sector when a packet starts on its 4KB boundary, so a
+// retry of that packet erases the sector again and programs it afresh; a
+// retry of any other packet can only clear the bits that did not program.
+type Verifier struct {
+	c          *Client
+	what       string
+	buf        []uint8
+	mismatches []Mismatch
+}
+
+func (c *Client) NewVerifier(what string) *Verifier {
+	return &Verifier{c: c, what: what, buf: make([]uint8, PACKET_SIZE)}
+}
+
+// Packet writes data and reads it back through the same window.
+// A packet that never reads back is recorded, not returned: see Err.
+func (v *Verifier) Packet(bank, offset int, data []byte, write, read func(buf []byte) error) error {
+	got := v.buf[:len(data)]
+	for try := 0; ; try++ {
+		err := write(data)
+		if err != nil {
+			return err
+		}
+		err = read(got)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(got, data) {
+			return nil
+		}
+		if try == v.c.WriteRetries {
+			break
+		}
+	}
+	v.Check(bank, offset, data, got)
+	return nil
+}
+
+// Check records a mismatch if got is not want.
+func (v *Verifier) Check(bank, offset int, want, got []byte) {
+	m := Mismatch{What: v.what, Bank: bank, Offset: -1}
+	for i := range want {
+		if got[i] != want[i] {
+			if m.Offset < 0 {
+				m.Offset, m.Want, m.Got = offset+i, want[i], got[i]
+			}
+			m.Bytes++
+		}
+	}
+	if m.Bytes > 0 {
+		v.mismatches = append(v.mismatches, m)
+	}
+}
+
+// failed tells if a packet of bank did not read back.
+func (v *Verifier) failed(bank int) bool {
+	for _, m := range v.mismatches {
+		if m.Bank == bank {
+			return true
+		}
+	}
+	return false
+}
+
+// Err is a *VerifyError if any packet did not read back.
+func (v *Verifier) Err() error {
+	if len(v.mismatches) == 0 {
+		return nil
+	}
+	return &VerifyError{Mismatches: v.mismatches}
+}
@@ -0,0 +1,92 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestVerifier(t *testing.T) {
+	c := NewClient(&testLink{})
+	c.WriteRetries = 2
+	v := c.NewVerifier("PRG")
+	data := []byte{0x00, 0x11, 0x22, 0x33}
+
+	// reads back on the second write
+	var mem []byte
+	writes := 0
+	err := v.Packet(0, 0, data, func(b []byte) error {
+		writes++
+		mem = append([]byte(nil), b...)
+		if writes == 1 {
+			mem[1] = 0xff
+		}
+		return nil
+	}, func(b []byte) error {
+		copy(b, mem)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writes != 2 || v.Err() != nil {
+		t.Errorf("%d writes, %v", writes, v.Err())
+	}
+
+	// never reads back: written 1+WriteRetries times, then recorded
+	writes = 0
+	err = v.Packet(3, 0x400, data, func(b []byte) error {
+		writes++
+		return nil
+	}, func(b []byte) error {
+		copy(b, []byte{0x00, 0x10, 0x22, 0x30})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if writes != 3 {
+		t.Errorf("%d writes, want 3", writes)
+	}
+	err = v.Err()
+	var verr *VerifyError
+	if !errors.As(err, &verr) || !errors.Is(err, ErrVerify) {
+		t.Fatalf("got %v, want a VerifyError", err)
+	}
+	want := Mismatch{What: "PRG", Bank: 3, Offset: 0x401, Want: 0x11, Got: 0x10, Bytes: 2}
+	if len(verr.Mismatches) != 1 || verr.Mismatches[0] != want {
+		t.Errorf("got %v, want %v", verr.Mismatches, want)
+	}
+
+	// errors of the link are returned as they are
+	failed := errors.New("link down")
+	err = v.Packet(0, 0, data, func(b []byte) error { return failed }, nil)
+	if err != failed {
+		t.Errorf("got %v, want %v", err, failed)
+	}
+}
+
+func TestWritePRGVerify(t *testing.T) {
+	fc, v := testFC(t, testImage(t, 1, 16, 0, 1))
+	prg := testImage(t, 1, 16, 0, 2)[16:]
+	// bit 0 of one byte in bank 5 no longer programs
+	stuck := 5*0x4000 + 0x123
+	prg[stuck] |= 0x01
+	v.prgFlash.hook = func(f *vflash, addr int, data uint8) bool {
+		if f.state != vflashProgram || addr != stuck {
+			return false
+		}
+		f.mem[addr] &= data &^ 0x01
+		f.state = vflashRead
+		return true
+	}
+
+	err := fc.WritePRG(bytes.NewReader(prg), 16)
+	var verr *VerifyError
+	if !errors.As(err, &verr) {
+		t.Fatalf("got %v, want a VerifyError", err)
+	}
+	if len(verr.Mismatches) != 1 || verr.Mismatches[0].Bank != 5 {
+		t.Errorf("got %v", verr.Mismatches)
+	}
+}
@@ -0,0 +1,1026 @@
+package FCflash
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/ysh86/FCflash/ines"
+)
+
+// vboard is the mapper of a simulated FC cartridge.
+// It maps CPU/PPU addresses to offsets in PRG/CHR and latches register writes.
+type vboard interface {
+	prg(addr uint16) int
+	chr(addr uint16) int
+	// wram returns the W-RAM offset of $6000-$7fff, or -1 while disabled.
+	wram(addr uint16) int
+	write(addr uint16, data uint8)
+}
+
+// VirtualFC is an FC cartridge in an Arduino reader, decoding the request
+// stream exactly like loop() in firmware/FCflash/FCflash.ino.
+// PRG is an SST39SF040-style flash, so REQ_CPU_WRITE_FLASH works as well.
+type VirtualFC struct {
+	*vport
+	Mapper    int
+	Submapper int
+	PRG       []byte
+	CHR       []byte // CHR RAM if the image has no CHR ROM
+	WRAM      []byte
+	Raw       []byte // the chip in the raw socket, nil if empty
+
+	board    vboard
+	prgFlash *vflash
+	chrFlash *vflash
+	raw      vraw
+}
+
+// vraw is the chip in the raw socket.
+type vraw interface {
+	read(addr int) uint8
+	write(addr int, data uint8)
+}
+
+// NewVirtualFC loads an iNES image.
+func NewVirtualFC(nes []byte) (*VirtualFC, error) {
+	h, err := ines.Parse(nes)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(nes)) < h.Size() {
+		return nil, fmt.Errorf("short iNES image: %d bytes", len(nes))
+	}
+
+	v := &VirtualFC{Mapper: h.Mapper, Submapper: h.Submapper}
+	v.PRG = append([]byte(nil), nes[h.PRGOffset():h.CHROffset()]...)
+	v.CHR = append([]byte(nil), nes[h.CHROffset():h.Size()]...)
+	if h.CHRROM == 0 {
+		v.CHR = make([]byte, 8*1024)
+		if h.CHRRAM+h.CHRNVRAM > 0 {
+			v.CHR = make([]byte, h.CHRRAM+h.CHRNVRAM)
+		}
+	}
+	v.WRAM = make([]byte, 8*1024)
+	if h.PRGRAM+h.PRGNVRAM > 0 {
+		v.WRAM = make([]byte, h.PRGRAM+h.PRGNVRAM)
+	}
+	board, err := newVBoard(v)
+	if err != nil {
+		return nil, err
+	}
+	v.board = board
+	v.prgFlash = newVFlash(v.PRG, sst39sf040)
+	v.chrFlash = newVFlash(v.CHR, sst39sf040)
+	v.vport = newAVRPort(v)
+	return v, nil
+}
+
+// NewVirtualFCMbed puts the cartridge of NewVirtualFC in the mbed board
+// of firmware/FCflash.cpp instead.
+func NewVirtualFCMbed(nes []byte) (*VirtualFC, error) {
+	v, err := NewVirtualFC(nes)
+	if err != nil {
+		return nil, err
+	}
+	v.vport = newMbedPort(v)
+	return v, nil
+}
+
+var vrawFlashChips = map[string]vflashChip{
+	"sst39sf040": sst39sf040,
+	"am29f040":   am29f040,
+}
+
+var vrawEEPROMSizes = map[string]int{
+	"at28c64":  8 * 1024,
+	"at28c256": 32 * 1024,
+}
+
+// InsertRaw puts an erased chip, by its simulator name, in the raw socket.
+func (v *VirtualFC) InsertRaw(name string) error {
+	if chip, ok := vrawFlashChips[name]; ok {
+		v.Raw = bytes.Repeat([]byte{0xff}, chip.size)
+		v.raw = newVFlash(v.Raw, chip)
+		return nil
+	}
+	if size, ok := vrawEEPROMSizes[name]; ok {
+		v.Raw = bytes.Repeat([]byte{0xff}, size)
+		v.raw = &veeprom{mem: v.Raw, protected: true}
+		return nil
+	}
+	return fmt.Errorf("unknown raw chip: %s", name)
+}
+
+func newVBoard(v *VirtualFC) (vboard, error) {
+	switch v.Mapper {
+	case 0:
+		return &vNROM{v}, nil
+	case 1:
+		return &vMMC1{v: v, control: 0x0c}, nil
+	case 2:
+		return &vUxROM{v: v, conflict: v.Submapper != 2}, nil
+	case 3:
+		return &vCNROM{conflict: v.Submapper != 2}, nil
+	case 4:
+		return &vMMC3{v: v}, nil
+	case 5:
+		return newVMMC5(v), nil
+	case 7:
+		return &vAxROM{conflict: v.Submapper == 1}, nil
+	case 9:
+		return &vMMC2{v: v, prgBank: 8 * 1024}, nil
+	case 10:
+		return &vMMC2{v: v, prgBank: 16 * 1024, mmc4: true}, nil
+	case 11:
+		return &vColorDreams{}, nil
+	case 21, 22, 23, 25:
+		return newVVRC24(v)
+	case 24:
+		return &vVRC{v: v, chip: 6, a0: 1 << 0, a1: 1 << 1}, nil
+	case 26:
+		return &vVRC{v: v, chip: 6, a0: 1 << 1, a1: 1 << 0}, nil
+	case 66:
+		return &vGxROM{}, nil
+	case 69:
+		return &vFME7{v: v}, nil
+	case 71:
+		return &vCamerica{v: v}, nil
+	case 85:
+		if v.Submapper == 1 {
+			return &vVRC{v: v, chip: 7, a0: 1 << 3}, nil
+		}
+		return &vVRC{v: v, chip: 7, a0: 1 << 4}, nil
+	}
+	return nil, fmt.Errorf("mapper %d is not simulated", v.Mapper)
+}
+
+func (v *VirtualFC) cpuRead(addr uint16) uint8 {
+	if addr&0x8000 != 0 {
+		return v.prgFlash.read(v.board.prg(addr))
+	}
+	if r, ok := v.board.(vregs); ok && addr >= 0x4020 && addr < 0x6000 {
+		if d, ok := r.readReg(addr); ok {
+			return d
+		}
+	}
+	if addr >= 0x6000 {
+		if i := v.board.wram(addr); i >= 0 {
+			return v.WRAM[i%len(v.WRAM)]
+		}
+	}
+	return uint8(addr >> 8) // open bus
+}
+
+func (v *VirtualFC) cpuWrite(addr uint16, data uint8) {
+	if addr&0x8000 != 0 {
+		if c, ok := v.board.(vconflict); ok && c.conflicts() {
+			data &= v.cpuRead(addr)
+		}
+		v.board.write(addr, data)
+		return
+	}
+	if r, ok := v.board.(vregs); ok && addr >= 0x4020 && addr < 0x6000 {
+		r.writeReg(addr, data)
+		return
+	}
+	if p, ok := v.board.(vprotect); ok && p.protected() {
+		return
+	}
+	if addr >= 0x6000 {
+		if i := v.board.wram(addr); i >= 0 {
+			v.WRAM[i%len(v.WRAM)] = data
+		}
+	}
+}
+
+func (v *VirtualFC) ppuRead(addr uint16) uint8 {
+	d := v.chrFlash.read(v.board.chr(addr & 0x1fff))
+	if l, ok := v.board.(vlatch); ok {
+		l.fetched(addr & 0x1fff)
+	}
+	return d
+}
+
+func (v *VirtualFC) request(m Message, payload []byte) []byte {
+	addr := m.Value
+	switch m.Request {
+	case REQ_PHI2_INIT:
+		if c, ok := v.board.(vm2); ok {
+			c.m2(int(addr))
+		}
+	case REQ_CPU_READ_6502:
+		if c, ok := v.board.(vm2); ok {
+			c.m2(int(m.Length))
+		}
+		reply := make([]byte, m.Length)
+		for i := range reply {
+			reply[i] = v.cpuRead((addr + uint16(i)) & 0x7fff)
+		}
+		return reply
+	case REQ_CPU_READ:
+		reply := make([]byte, m.Length)
+		for i := range reply {
+			reply[i] = v.cpuRead(0x8000 | (addr + uint16(i)))
+		}
+		return reply
+	case REQ_CPU_WRITE_6502:
+		// all of A0-A7 are driven: CAP_CPU_WRITE_ADDR
+		v.cpuWrite(addr, uint8(m.Length))
+	case REQ_CPU_WRITE_6502_5BITS:
+		addr = 0x8000 | (addr & 0xff01)
+		five := uint8(m.Length & 0x1f)
+		for i := 0; i < 5; i++ {
+			v.cpuWrite(addr, five&1)
+			five >>= 1
+		}
+	case REQ_PPU_READ:
+		reply := make([]byte, m.Length)
+		for i := range reply {
+			reply[i] = v.ppuRead(addr + uint16(i))
+		}
+		return reply
+	case REQ_CPU_WRITE_EEP:
+		for i, d := range payload {
+			v.PRG[v.board.prg(0x8000|(addr+uint16(i)))%len(v.PRG)] = d
+		}
+	case REQ_PPU_WRITE_EEP:
+		for i, d := range payload {
+			v.CHR[v.board.chr((addr+uint16(i))&0x1fff)%len(v.CHR)] = d
+		}
+	case REQ_CPU_WRITE_FLASH:
+		// M2 stays low, so the mapper does not see these cycles.
+		if addr&0x0fff == 0 {
+			v.flashCPU(0x5555, 0xaa)
+			v.flashCPU(0x2aaa, 0x55)
+			v.flashCPU(0x5555, 0x80)
+			v.flashCPU(0x5555, 0xaa)
+			v.flashCPU(0x2aaa, 0x55)
+			v.flashCPU(addr&0x7000, 0x30)
+		}
+		for i, d := range payload {
+			v.flashCPU(0x5555, 0xaa)
+			v.flashCPU(0x2aaa, 0x55)
+			v.flashCPU(0x5555, 0xa0)
+			v.flashCPU(addr+uint16(i), d)
+		}
+		v.prgFlash.busy = 0
+	case REQ_PPU_WRITE:
+		addr &= 0x1fff
+		if addr&0x0fff == 0 {
+			v.flashPPU(0x5555, 0xaa)
+			v.flashPPU(0x2aaa, 0x55)
+			v.flashPPU(0x5555, 0x80)
+			v.flashPPU(0x5555, 0xaa)
+			v.flashPPU(0x2aaa, 0x55)
+			v.flashPPU(addr&0x1000, 0x30)
+		}
+		for i, d := range payload {
+			v.flashPPU(0x5555, 0xaa)
+			v.flashPPU(0x2aaa, 0x55)
+			v.flashPPU(0x5555, 0xa0)
+			v.flashPPU(addr+uint16(i), d)
+		}
+		v.chrFlash.busy = 0
+	case REQ_RAW_READ, REQ_RAW_READ_LO, REQ_RAW_READ_WO_CS:
+		raw := int(addr)
+		if m.Request != REQ_RAW_READ_LO {
+			raw <<= 8
+		}
+		reply := make([]byte, m.Length)
+		for i := range reply {
+			reply[i] = 0xff
+			if m.Request != REQ_RAW_READ_WO_CS {
+				reply[i] = v.rawRead(raw + i)
+			}
+		}
+		return reply
+	case REQ_RAW_WRITE, REQ_RAW_WRITE_LO:
+		raw := int(addr)
+		if m.Request == REQ_RAW_WRITE {
+			raw <<= 8
+		}
+		for i, d := range payload {
+			v.rawWrite(raw+i, d)
+		}
+	case REQ_RAW_WRITE_EEP:
+		switch Index(m.Index) {
+		case INDEX_SDP_WRITE:
+			v.rawCommand(0, 0xa0)
+		case INDEX_SDP_DISABLE:
+			v.rawCommand(0, 0x80)
+			v.rawCommand(0, 0x20)
+		}
+		for i, d := range payload {
+			v.rawWrite(int(addr)+i, d)
+		}
+		if len(payload) > 0 {
+			// toggle bit polling
+			last := int(addr) + len(payload) - 1
+			prev := v.rawRead(last)
+			for i := 0; i < 8; i++ {
+				status := v.rawRead(last)
+				if (status^prev)&0x40 == 0 {
+					break
+				}
+				prev = status
+			}
+		}
+	case REQ_RAW_ERASE_FLASH:
+		// A15-A18 are left as they were: the first 32KB
+		if addr == 0xff {
+			v.rawCommand(0, 0x80)
+			v.rawCommand(0, 0x10)
+		} else {
+			v.rawCommand(0, 0x80)
+			v.rawCommand(int(addr&0x7f)<<12, 0x30)
+		}
+		v.rawRead(0)
+		v.rawRead(0)
+	case REQ_RAW_WRITE_FLASH, REQ_RAW_PROGRAM_FLASH:
+		raw := int(addr) << 8
+		hi := raw &^ 0x7fff
+		for i, d := range payload {
+			a := raw + i
+			if m.Request == REQ_RAW_WRITE_FLASH && a&0x0fff == 0 {
+				v.rawCommand(hi, 0x80)
+				v.rawCommand(a, 0x30)
+				v.rawRead(a)
+				v.rawRead(a)
+			}
+			if m.Request == REQ_RAW_PROGRAM_FLASH && d == 0xff {
+				continue
+			}
+			v.rawCommand(hi, 0xa0)
+			v.rawWrite(a, d)
+			// DQ7 polling
+			for i := 0; i < 8 && (v.rawRead(a)^d)&0x80 != 0; i++ {
+			}
+		}
+	}
+	return nil
+}
+
+func (v *VirtualFC) rawRead(addr int) uint8 {
+	if v.raw == nil {
+		return 0xff // nothing in the raw socket
+	}
+	return v.raw.read(addr & 0x7ffff)
+}
+
+func (v *VirtualFC) rawWrite(addr int, data uint8) {
+	if v.raw != nil {
+		v.raw.write(addr&0x7ffff, data)
+	}
+}
+
+// rawCommand writes the unlock cycles at 5555H/2AAAH with A15-A18 of hi, then data at addr.
+func (v *VirtualFC) rawCommand(addr int, data uint8) {
+	hi := addr &^ 0x7fff
+	v.rawWrite(hi|0x5555, 0xaa)
+	v.rawWrite(hi|0x2aaa, 0x55)
+	if data == 0x30 {
+		v.rawWrite(addr, data)
+		return
+	}
+	v.rawWrite(hi|0x5555, data)
+}
+
+func (v *VirtualFC) mbedRequest(m MbedMessage) []byte {
+	addr := uint16(m.Value)
+	switch m.Request {
+	case MBED_REQ_CPU_READ:
+		// AD15 is PPU_A13, ROMSEL stands in for CPU A15
+		reply := make([]byte, m.Length)
+		for i := range reply {
+			reply[i] = v.cpuRead(0x8000 | (addr + uint16(i)))
+		}
+		return reply
+	case MBED_REQ_CPU_WRITE:
+		// A13-A14 are not driven: 0x8000-0x9fff only
+		v.cpuWrite(0x8000|addr&^0x6000, uint8(m.Length))
+	case MBED_REQ_PPU_READ:
+		reply := make([]byte, m.Length)
+		for i := range reply {
+			reply[i] = v.ppuRead(addr&0x1fff + uint16(i))
+		}
+		return reply
+	case MBED_REQ_READ16, MBED_REQ_READ8_CS2:
+		// no GBA cartridge on the bus
+		return bytes.Repeat([]byte{0xff}, int(m.Length))
+	}
+	return nil
+}
+
+func (v *VirtualFC) flashCPU(addr15 uint16, data uint8) {
+	v.prgFlash.write(v.board.prg(0x8000|(addr15&0x7fff)), data)
+}
+
+// flashPPU drives A8-A12 of addr on the PPU bus, like setA08A14 does.
+func (v *VirtualFC) flashPPU(addr uint16, data uint8) {
+	v.chrFlash.write(v.board.chr(addr&0x1fff), data)
+}
+
+type vNROM struct {
+	v *VirtualFC
+}
+
+func (b *vNROM) prg(addr uint16) int           { return int(addr & 0x7fff) }
+func (b *vNROM) chr(addr uint16) int           { return int(addr) }
+func (b *vNROM) wram(addr uint16) int          { return int(addr & 0x1fff) }
+func (b *vNROM) write(addr uint16, data uint8) {}
+
+// vMMC1 covers SNROM/SUROM/SOROM/SXROM: PRG A18 and the W-RAM bank come
+// from CHR bank 0 when the board has CHR RAM.
+type vMMC1 struct {
+	v          *VirtualFC
+	shift      uint8
+	count      int
+	control    uint8
+	chr0, chr1 uint8
+	prgBank    uint8
+}
+
+func (b *vMMC1) write(addr uint16, data uint8) {
+	if data&0x80 != 0 {
+		b.shift, b.count = 0, 0
+		b.control |= 0x0c
+		return
+	}
+	b.shift |= (data & 1) << b.count
+	b.count++
+	if b.count < 5 {
+		return
+	}
+	switch addr & 0xe000 {
+	case 0x8000:
+		b.control = b.shift
+	case 0xa000:
+		b.chr0 = b.shift
+	case 0xc000:
+		b.chr1 = b.shift
+	case 0xe000:
+		b.prgBank = b.shift
+	}
+	b.shift, b.count = 0, 0
+}
+
+func (b *vMMC1) prg(addr uint16) int {
+	if len(b.v.PRG) <= 32*1024 {
+		// SEROM, SHROM: PRG A14 is CPU A14
+		return int(addr & 0x7fff)
+	}
+	outer := 0
+	if len(b.v.PRG) > 256*1024 {
+		outer = int(b.chr0&0x10) << 14
+	}
+	bank := int(b.prgBank & 0x0f)
+	last := (len(b.v.PRG) - 1) >> 14 & 0x0f
+	switch (b.control >> 2) & 3 {
+	case 0, 1:
+		return outer | (bank&^1)<<14 | int(addr&0x7fff)
+	case 2:
+		if addr < 0xc000 {
+			return outer | int(addr&0x3fff)
+		}
+	case 3:
+		if addr >= 0xc000 {
+			bank = last
+		}
+	}
+	return outer | bank<<14 | int(addr&0x3fff)
+}
+
+func (b *vMMC1) chr(addr uint16) int {
+	if b.control&0x10 == 0 {
+		return int(b.chr0&0x1e)<<12 | int(addr)
+	}
+	if addr < 0x1000 {
+		return int(b.chr0)<<12 | int(addr&0x0fff)
+	}
+	return int(b.chr1)<<12 | int(addr&0x0fff)
+}
+
+func (b *vMMC1) wram(addr uint16) int {
+	if b.prgBank&0x10 != 0 {
+		return -1
+	}
+	switch len(b.v.WRAM) {
+	case 16 * 1024:
+		// SOROM
+		return int(b.chr0>>3&1)<<13 | int(addr&0x1fff)
+	case 32 * 1024:
+		// SXROM
+		return int(b.chr0>>2&3)<<13 | int(addr&0x1fff)
+	}
+	return int(addr & 0x1fff)
+}
+
+// vprotect is a board that can keep W-RAM readable but not writable.
+type vprotect interface {
+	protected() bool
+}
+
+// vconflict is a board whose ROM keeps driving the data bus while its
+// register is written, so the register gets the AND of both.
+type vconflict interface {
+	conflicts() bool
+}
+
+// vregs is a board with registers or RAM at $4020-$5FFF.
+type vregs interface {
+	readReg(addr uint16) (uint8, bool) // false: open bus
+	writeReg(addr uint16, data uint8)
+}
+
+// vm2 is a board that needs M2 to run, cycles at a time.
+type vm2 interface {
+	m2(cycles int)
+}
+
+// vlatch is a board that watches the PPU read CHR.
+type vlatch interface {
+	fetched(addr uint16)
+}
+
+// vAxROM is AOROM, or ANROM/AMROM with bus conflicts for NES 2.0 submapper 1.
+type vAxROM struct {
+	bank     uint8
+	conflict bool
+}
+
+func (b *vAxROM) prg(addr uint16) int           { return int(b.bank&7)<<15 | int(addr&0x7fff) }
+func (b *vAxROM) chr(addr uint16) int           { return int(addr) }
+func (b *vAxROM) wram(addr uint16) int          { return -1 }
+func (b *vAxROM) write(addr uint16, data uint8) { b.bank = data }
+func (b *vAxROM) conflicts() bool               { return b.conflict }
+
+// vUxROM has bus conflicts unless NES 2.0 submapper 2 says otherwise, as does vCNROM.
+type vUxROM struct {
+	v        *VirtualFC
+	bank     uint8
+	conflict bool
+}
+
+func (b *vUxROM) prg(addr uint16) int {
+	if addr >= 0xc000 {
+		return (len(b.v.PRG)>>14-1)<<14 | int(addr&0x3fff)
+	}
+	return int(b.bank&0x0f)<<14 | int(addr&0x3fff)
+}
+func (b *vUxROM) chr(addr uint16) int           { return int(addr) }
+func (b *vUxROM) wram(addr uint16) int          { return -1 }
+func (b *vUxROM) write(addr uint16, data uint8) { b.bank = data }
+func (b *vUxROM) conflicts() bool               { return b.conflict }
+
+type vCNROM struct {
+	bank     uint8
+	conflict bool
+}
+
+func (b *vCNROM) prg(addr uint16) int           { return int(addr & 0x7fff) }
+func (b *vCNROM) chr(addr uint16) int           { return int(b.bank)<<13 | int(addr) }
+func (b *vCNROM) wram(addr uint16) int          { return -1 }
+func (b *vCNROM) write(addr uint16, data uint8) { b.bank = data }
+func (b *vCNROM) conflicts() bool               { return b.conflict }
+
+type vColorDreams struct {
+	reg uint8
+}
+
+func (b *vColorDreams) prg(addr uint16) int           { return int(b.reg&3)<<15 | int(addr&0x7fff) }
+func (b *vColorDreams) chr(addr uint16) int           { return int(b.reg>>4)<<13 | int(addr) }
+func (b *vColorDreams) wram(addr uint16) int          { return -1 }
+func (b *vColorDreams) write(addr uint16, data uint8) { b.reg = data }
+func (b *vColorDreams) conflicts() bool               { return true }
+
+type vGxROM struct {
+	reg uint8
+}
+
+func (b *vGxROM) prg(addr uint16) int           { return int(b.reg>>4&3)<<15 | int(addr&0x7fff) }
+func (b *vGxROM) chr(addr uint16) int           { return int(b.reg&3)<<13 | int(addr) }
+func (b *vGxROM) wram(addr uint16) int          { return -1 }
+func (b *vGxROM) write(addr uint16, data uint8) { b.reg = data }
+func (b *vGxROM) conflicts() bool               { return true }
+
+// vCamerica is BF909x: the register is at $C000-$FFFF, and the ROM is
+// not enabled for writes. $8000-$9FFF is mirroring on BF9097 only.
+type vCamerica struct {
+	v    *VirtualFC
+	bank uint8
+}
+
+func (b *vCamerica) prg(addr uint16) int {
+	if addr >= 0xc000 {
+		return (len(b.v.PRG)>>14-1)<<14 | int(addr&0x3fff)
+	}
+	return int(b.bank&0x0f)<<14 | int(addr&0x3fff)
+}
+func (b *vCamerica) chr(addr uint16) int  { return int(addr) }
+func (b *vCamerica) wram(addr uint16) int { return -1 }
+func (b *vCamerica) write(addr uint16, data uint8) {
+	if addr >= 0xc000 {
+		b.bank = data
+	}
+}
+
+type vMMC3 struct {
+	v       *VirtualFC
+	sel     uint8
+	r       [8]uint8
+	ramCtrl uint8
+}
+
+func (b *vMMC3) write(addr uint16, data uint8) {
+	switch addr & 0xe001 {
+	case 0x8000:
+		b.sel = data
+	case 0x8001:
+		b.r[b.sel&7] = data
+	case 0xa001:
+		b.ramCtrl = data
+	}
+}
+
+func (b *vMMC3) prg(addr uint16) int {
+	last := len(b.v.PRG)>>13 - 1
+	var bank int
+	switch addr & 0xe000 {
+	case 0x8000:
+		bank = int(b.r[6])
+		if b.sel&0x40 != 0 {
+			bank = last - 1
+		}
+	case 0xa000:
+		bank = int(b.r[7])
+	case 0xc000:
+		bank = last - 1
+		if b.sel&0x40 != 0 {
+			bank = int(b.r[6])
+		}
+	case 0xe000:
+		bank = last
+	}
+	return bank<<13 | int(addr&0x1fff)
+}
+
+func (b *vMMC3) chr(addr uint16) int {
+	if b.sel&0x80 != 0 {
+		addr ^= 0x1000
+	}
+	if addr < 0x1000 {
+		return int(b.r[addr>>11]&0xfe)<<10 | int(addr&0x07ff)
+	}
+	return int(b.r[2+(addr-0x1000)>>10])<<10 | int(addr&0x03ff)
+}
+
+func (b *vMMC3) protected() bool { return b.ramCtrl&0x40 != 0 }
+
+func (b *vMMC3) wram(addr uint16) int {
+	if b.ramCtrl&0x80 == 0 {
+		return -1
+	}
+	return int(addr & 0x1fff)
+}
+
+// vMMC2 is MMC2, or MMC4 with 16KB PRG banks and PRG RAM. The latch of
+// a CHR half switches after the PPU reads $xFD8 or $xFE8: on MMC2 the
+// whole 8 bytes of the tile in the upper half, the first byte in the lower.
+type vMMC2 struct {
+	v       *VirtualFC
+	prgBank int
+	mmc4    bool
+	prgReg  uint8
+	chrReg  [4]uint8 // $0000 FD, FE, $1000 FD, FE
+	latch   [2]int   // 0:FD, 1:FE
+}
+
+func (b *vMMC2) write(addr uint16, data uint8) {
+	switch addr & 0xf000 {
+	case 0xa000:
+		b.prgReg = data & 0x0f
+	case 0xb000, 0xc000, 0xd000, 0xe000:
+		b.chrReg[(addr-0xb000)>>12] = data & 0x1f
+	}
+}
+
+func (b *vMMC2) prg(addr uint16) int {
+	if int(addr&0x7fff) < b.prgBank {
+		return int(b.prgReg)*b.prgBank | int(addr)&(b.prgBank-1)
+	}
+	// the rest is the end of PRG
+	return len(b.v.PRG) - 0x8000 + int(addr&0x7fff)
+}
+
+func (b *vMMC2) chr(addr uint16) int {
+	half := int(addr >> 12 & 1)
+	return int(b.chrReg[half*2+b.latch[half]])<<12 | int(addr&0x0fff)
+}
+
+func (b *vMMC2) fetched(addr uint16) {
+	half := int(addr >> 12 & 1)
+	tile := addr & 0x0ff8
+	if half == 0 && !b.mmc4 {
+		tile = addr & 0x0fff
+	}
+	switch tile {
+	case 0x0fd8:
+		b.latch[half] = 0
+	case 0x0fe8:
+		b.latch[half] = 1
+	}
+}
+
+func (b *vMMC2) wram(addr uint16) int {
+	if !b.mmc4 {
+		return -1
+	}
+	return int(addr & 0x1fff)
+}
+
+// vMMC5 is MMC5 in PRG mode 3 and CHR mode 3 only, which is what ExROM
+// sets. It ignores register writes until M2 has run for a while. The CHR
+// register set written last is the one PPU reads go through.
+type vMMC5 struct {
+	v        *VirtualFC
+	awake    bool
+	prgRAM   uint8
+	prgRegs  [4]uint8 // $5114-$5117
+	chrA     [8]int
+	chrB     [4]int
+	chrUpper uint8
+	lastB    bool
+	protect  [2]uint8
+	exMode   uint8
+	exram    [1024]uint8
+}
+
+func newVMMC5(v *VirtualFC) *vMMC5 {
+	b := &vMMC5{v: v, prgRegs: [4]uint8{0xff, 0xff, 0xff, 0xff}}
+	for i := range b.exram {
+		b.exram[i] = uint8(i * 7)
+	}
+	return b
+}
+
+func (b *vMMC5) m2(cycles int) {
+	if cycles >= 64 {
+		b.awake = true
+	}
+}
+
+func (b *vMMC5) write(addr uint16, data uint8) {}
+
+func (b *vMMC5) writeReg(addr uint16, data uint8) {
+	if addr >= 0x5c00 {
+		if b.exMode == 2 {
+			b.exram[addr&0x3ff] = data
+		}
+		return
+	}
+	if !b.awake {
+		return
+	}
+	switch {
+	case addr == 0x5102 || addr == 0x5103:
+		b.protect[addr-0x5102] = data & 3
+	case addr == 0x5104:
+		b.exMode = data & 3
+	case addr == 0x5113:
+		b.prgRAM = data & 7
+	case addr >= 0x5114 && addr <= 0x5117:
+		b.prgRegs[addr-0x5114] = data
+	case addr >= 0x5120 && addr <= 0x5127:
+		b.chrA[addr-0x5120] = int(b.chrUpper)<<8 | int(data)
+		b.lastB = false
+	case addr >= 0x5128 && addr <= 0x512b:
+		b.chrB[addr-0x5128] = int(b.chrUpper)<<8 | int(data)
+		b.lastB = true
+	case addr == 0x5130:
+		b.chrUpper = data & 3
+	}
+}
+
+func (b *vMMC5) readReg(addr uint16) (uint8, bool) {
+	if addr >= 0x5c00 && b.exMode >= 2 {
+		return b.exram[addr&0x3ff], true
+	}
+	return 0, false
+}
+
+func (b *vMMC5) prg(addr uint16) int {
+	reg := b.prgRegs[(addr&0x7fff)>>13]
+	if reg&0x80 == 0 && addr < 0xe000 {
+		return -1 // PRG RAM, not simulated
+	}
+	return int(reg&0x7f)<<13 | int(addr&0x1fff)
+}
+
+func (b *vMMC5) chr(addr uint16) int {
+	if b.lastB {
+		return b.chrB[addr>>10&3]<<10 | int(addr&0x03ff)
+	}
+	return b.chrA[addr>>10]<<10 | int(addr&0x03ff)
+}
+
+func (b *vMMC5) protected() bool { return b.protect != [2]uint8{2, 1} }
+
+func (b *vMMC5) wram(addr uint16) int {
+	if len(b.v.WRAM) == 16*1024 {
+		// ETROM: two 8KB chips, bit 2 selects
+		return int(b.prgRAM>>2&1)<<13 | int(addr&0x1fff)
+	}
+	return int(b.prgRAM)<<13 | int(addr&0x1fff)
+}
+
+// vVRC is a Konami VRC2, VRC4, VRC6 or VRC7 with a0 and a1, the masks of
+// the CPU address lines on the chip's register selects: a board for
+// submapper 0 connects the lines of both revisions. VRC6 is in CHR mode
+// 0 only. VRC4 powers up in PRG swap mode.
+type vVRC struct {
+	v        *VirtualFC
+	chip     int
+	a0, a1   uint16
+	chrShift uint
+	prgRegs  [3]uint8
+	chrRegs  [8]int
+	ctrl     uint8 // VRC4 $9002, VRC6 $B003, VRC7 $E000
+}
+
+func newVVRC24(v *VirtualFC) (*vVRC, error) {
+	b := &vVRC{v: v, chip: 4, ctrl: 0b10}
+	switch v.Mapper<<4 | v.Submapper {
+	case 21<<4 | 1:
+		b.a0, b.a1 = 1<<1, 1<<2
+	case 21<<4 | 2:
+		b.a0, b.a1 = 1<<6, 1<<7
+	case 21<<4 | 0:
+		b.a0, b.a1 = 1<<1|1<<6, 1<<2|1<<7
+	case 22<<4 | 0:
+		b.chip, b.a0, b.a1, b.chrShift = 2, 1<<1, 1<<0, 1
+	case 23<<4 | 1:
+		b.a0, b.a1 = 1<<0, 1<<1
+	case 23<<4 | 2:
+		b.a0, b.a1 = 1<<2, 1<<3
+	case 23<<4 | 3:
+		b.chip, b.a0, b.a1 = 2, 1<<0, 1<<1
+	case 23<<4 | 0:
+		b.a0, b.a1 = 1<<0|1<<2, 1<<1|1<<3
+	case 25<<4 | 1:
+		b.a0, b.a1 = 1<<1, 1<<0
+	case 25<<4 | 2:
+		b.a0, b.a1 = 1<<3, 1<<2
+	case 25<<4 | 3:
+		b.chip, b.a0, b.a1 = 2, 1<<1, 1<<0
+	case 25<<4 | 0:
+		b.a0, b.a1 = 1<<1|1<<3, 1<<0|1<<2
+	default:
+		return nil, fmt.Errorf("mapper %d.%d is not simulated", v.Mapper, v.Submapper)
+	}
+	return b, nil
+}
+
+func (b *vVRC) write(addr uint16, data uint8) {
+	reg := 0
+	if addr&b.a0 != 0 {
+		reg |= 1
+	}
+	if addr&b.a1 != 0 {
+		reg |= 2
+	}
+	block := addr & 0xf000
+	switch b.chip {
+	case 2, 4:
+		switch {
+		case block == 0x8000:
+			b.prgRegs[0] = data & 0x1f
+		case block == 0x9000 && reg == 2 && b.chip == 4:
+			b.ctrl = data
+		case block == 0xa000:
+			b.prgRegs[1] = data & 0x1f
+		case block >= 0xb000 && block <= 0xe000:
+			i := int(block-0xb000)>>11 | reg>>1
+			if reg&1 == 0 {
+				b.chrRegs[i] = b.chrRegs[i]&^0x0f | int(data&0x0f)
+			} else {
+				b.chrRegs[i] = b.chrRegs[i]&0x0f | int(data&0x1f)<<4
+			}
+		}
+	case 6:
+		switch {
+		case block == 0x8000:
+			b.prgRegs[0] = data & 0x0f
+		case block == 0xb000 && reg == 3:
+			b.ctrl = data
+		case block == 0xc000:
+			b.prgRegs[1] = data & 0x1f
+		case block == 0xd000 || block == 0xe000:
+			b.chrRegs[int(block-0xd000)>>10|reg] = int(data)
+		}
+	case 7:
+		switch {
+		case block == 0x8000:
+			b.prgRegs[reg&1] = data & 0x3f
+		case block == 0x9000 && reg&1 == 0:
+			b.prgRegs[2] = data & 0x3f
+		case block >= 0xa000 && block <= 0xd000:
+			b.chrRegs[int(block-0xa000)>>11|reg&1] = int(data)
+		case block == 0xe000 && reg&1 == 0:
+			b.ctrl = data
+		}
+	}
+}
+
+func (b *vVRC) prg(addr uint16) int {
+	last := len(b.v.PRG)>>13 - 1
+	var bank int
+	switch b.chip {
+	case 2, 4:
+		swap := b.chip == 4 && b.ctrl&0b10 != 0
+		switch addr & 0xe000 {
+		case 0x8000:
+			bank = int(b.prgRegs[0])
+			if swap {
+				bank = last - 1
+			}
+		case 0xa000:
+			bank = int(b.prgRegs[1])
+		case 0xc000:
+			bank = last - 1
+			if swap {
+				bank = int(b.prgRegs[0])
+			}
+		case 0xe000:
+			bank = last
+		}
+	case 6:
+		switch {
+		case addr < 0xc000:
+			return int(b.prgRegs[0])<<14 | int(addr&0x3fff)
+		case addr < 0xe000:
+			bank = int(b.prgRegs[1])
+		default:
+			bank = last
+		}
+	case 7:
+		bank = last
+		if addr < 0xe000 {
+			bank = int(b.prgRegs[(addr-0x8000)>>13])
+		}
+	}
+	return bank<<13 | int(addr&0x1fff)
+}
+
+func (b *vVRC) chr(addr uint16) int {
+	return b.chrRegs[addr>>10]>>b.chrShift<<10 | int(addr&0x03ff)
+}
+
+func (b *vVRC) wram(addr uint16) int {
+	enabled := false
+	switch b.chip {
+	case 4:
+		enabled = b.ctrl&0x01 != 0
+	case 6, 7:
+		enabled = b.ctrl&0x80 != 0
+	}
+	if !enabled {
+		return -1
+	}
+	return int(addr & 0x1fff)
+}
+
+// vFME7 is FME-7: $8000 selects a command, $A000 sets it. ROM banked
+// into $6000 by command 8 is not simulated, only the PRG RAM.
+type vFME7 struct {
+	v   *VirtualFC
+	cmd uint8
+	r   [16]uint8
+}
+
+func (b *vFME7) write(addr uint16, data uint8) {
+	switch addr & 0xe000 {
+	case 0x8000:
+		b.cmd = data & 0x0f
+	case 0xa000:
+		b.r[b.cmd] = data
+	}
+}
+
+func (b *vFME7) prg(addr uint16) int {
+	bank := len(b.v.PRG)>>13 - 1
+	if addr < 0xe000 {
+		bank = int(b.r[9+(addr-0x8000)>>13] & 0x3f)
+	}
+	return bank<<13 | int(addr&0x1fff)
+}
+
+func (b *vFME7) chr(addr uint16) int {
+	return int(b.r[addr>>10])<<10 | int(addr&0x03ff)
+}
+
+func (b *vFME7) wram(addr uint16) int {
+	if b.r[8]&0xc0 != 0xc0 {
+		return -1
+	}
+	return int(addr & 0x1fff)
+}
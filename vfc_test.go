@@ -0,0 +1,185 @@
+package FCflash
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testImage is an iNES image of mapper with prg 16KB and chr 8KB units
+// of random bytes from seed.
+func testImage(t *testing.T, mapper, prg, chr int, seed int64) []byte {
+	t.Helper()
+	h := []byte{'N', 'E', 'S', 0x1a, uint8(prg), uint8(chr), uint8(mapper&0x0f) << 4, uint8(mapper & 0xf0), 0, 0, 0, 0, 0, 0, 0, 0}
+	body := make([]byte, prg*16*1024+chr*8*1024)
+	rand.New(rand.NewSource(seed)).Read(body)
+	return append(h, body...)
+}
+
+// testVirtualFC puts img in a simulated reader.
+func testVirtualFC(t *testing.T, img []byte) (*VirtualFC, *Client) {
+	t.Helper()
+	v, err := NewVirtualFC(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, NewClient(v)
+}
+
+// readCPU reads n bytes of PRG at 0x8000|addr a packet at a time.
+func readCPU(t *testing.T, c *Client, addr uint16, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	for i := 0; i < n; i += PACKET_SIZE {
+		err := c.CPURead(addr+uint16(i), b[i:i+PACKET_SIZE])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+// readPPU reads n bytes of CHR at addr a packet at a time.
+func readPPU(t *testing.T, c *Client, addr uint16, n int) []byte {
+	t.Helper()
+	b := make([]byte, n)
+	for i := 0; i < n; i += PACKET_SIZE {
+		err := c.PPURead(addr+uint16(i), b[i:i+PACKET_SIZE])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return b
+}
+
+func TestVirtualNROM(t *testing.T) {
+	v, c := testVirtualFC(t, testImage(t, 0, 2, 1, 0))
+	if !bytes.Equal(readCPU(t, c, 0x0000, 0x8000), v.PRG) {
+		t.Errorf("PRG differs")
+	}
+	if !bytes.Equal(readPPU(t, c, 0x0000, 0x2000), v.CHR) {
+		t.Errorf("CHR differs")
+	}
+
+	// NROM-128 shows up twice
+	v, c = testVirtualFC(t, testImage(t, 0, 1, 1, 0))
+	if !bytes.Equal(readCPU(t, c, 0x4000, 0x4000), v.PRG) {
+		t.Errorf("NROM-128: $C000 differs from PRG")
+	}
+}
+
+func TestVirtualMMC1(t *testing.T) {
+	v, c := testVirtualFC(t, testImage(t, 1, 8, 2, 1))
+	// MMC1: 16KB at $8000, 4KB CHR banks
+	for _, w := range []struct {
+		addr uint16
+		data uint8
+	}{{0x8000, 0b11111}, {0xe000, 5}, {0xa000, 3}, {0xc000, 0}} {
+		err := c.CPUWrite5Bits(w.addr, w.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(readCPU(t, c, 0x0000, 0x4000), v.PRG[5*0x4000:6*0x4000]) {
+		t.Errorf("$8000 is not bank 5")
+	}
+	if !bytes.Equal(readCPU(t, c, 0x4000, 0x4000), v.PRG[7*0x4000:]) {
+		t.Errorf("$C000 is not the last bank")
+	}
+	if !bytes.Equal(readPPU(t, c, 0x0000, 0x1000), v.CHR[3*0x1000:4*0x1000]) {
+		t.Errorf("$0000 is not CHR bank 3")
+	}
+	if !bytes.Equal(readPPU(t, c, 0x1000, 0x1000), v.CHR[:0x1000]) {
+		t.Errorf("$1000 is not CHR bank 0")
+	}
+}
+
+func TestVirtualMMC3(t *testing.T) {
+	v, c := testVirtualFC(t, testImage(t, 4, 8, 8, 2))
+	for _, w := range []struct {
+		addr uint16
+		data uint8
+	}{{0x8000, 6}, {0x8001, 9}, {0x8000, 0}, {0x8001, 12}, {0xa001, 0x80}, {0x6000, 0x5a}} {
+		err := c.CPUWrite6502(w.addr, w.data)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(readCPU(t, c, 0x0000, 0x2000), v.PRG[9*0x2000:10*0x2000]) {
+		t.Errorf("$8000 is not R6")
+	}
+	if !bytes.Equal(readCPU(t, c, 0x6000, 0x2000), v.PRG[len(v.PRG)-0x2000:]) {
+		t.Errorf("$E000 is not the last bank")
+	}
+	if !bytes.Equal(readPPU(t, c, 0x0000, 0x0800), v.CHR[12*0x400:14*0x400]) {
+		t.Errorf("$0000 is not R0")
+	}
+	if v.WRAM[0] != 0x5a {
+		t.Errorf("W-RAM not written")
+	}
+}
+
+func TestVirtualFlash(t *testing.T) {
+	v, c := testVirtualFC(t, testImage(t, 0, 2, 1, 3))
+	old := append([]byte(nil), v.PRG...)
+	data := bytes.Repeat([]byte{0x12, 0x34}, PACKET_SIZE/2)
+
+	// the first packet of a sector erases it
+	err := c.CPUWriteFlash(0x1000, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v.PRG[0x1000:0x1400], data) {
+		t.Errorf("packet not programmed")
+	}
+	if !bytes.Equal(v.PRG[0x1400:0x2000], bytes.Repeat([]byte{0xff}, 0x0c00)) {
+		t.Errorf("rest of the sector not erased")
+	}
+	if !bytes.Equal(v.PRG[:0x1000], old[:0x1000]) || !bytes.Equal(v.PRG[0x2000:], old[0x2000:]) {
+		t.Errorf("other sectors changed")
+	}
+
+	// any other packet is programmed over what is there
+	err = c.CPUWriteFlash(0x1400, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v.PRG[0x1000:0x1400], data) || !bytes.Equal(v.PRG[0x1400:0x1800], data) {
+		t.Errorf("second packet")
+	}
+	if !bytes.Equal(readCPU(t, c, 0x1000, 0x0800), v.PRG[0x1000:0x1800]) {
+		t.Errorf("programmed PRG does not read back")
+	}
+}
+
+func TestNewVirtualFC(t *testing.T) {
+	img := testImage(t, 0, 2, 1, 0)
+	_, err := NewVirtualFC(img[:len(img)-1])
+	if err == nil {
+		t.Errorf("short image loaded")
+	}
+	_, err = NewVirtualFC(testImage(t, 0xff, 2, 1, 0))
+	if err == nil {
+		t.Errorf("mapper 255 is simulated")
+	}
+
+	name := filepath.Join(t.TempDir(), "nrom.nes")
+	err = os.WriteFile(name, img, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := OpenVirtual(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	err = NewClient(tr).CPURead(0, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(buf, img[16:32]) {
+		t.Errorf("OpenVirtual: % x", buf)
+	}
+}
@@ -0,0 +1,218 @@
+package FCflash
+
+import "bytes"
+
+// vflashChip describes an AMD-style flash chip for the simulators.
+type vflashChip struct {
+	manufacturer uint8
+	device       uint8
+	cmd1, cmd2   int // unlock addresses
+	mask         int // address bits decoded for commands
+	sector       int
+	size         int
+	byteMode     bool // a 16-bit chip on an 8-bit bus: IDs sit at even addresses
+}
+
+var (
+	sst39sf040 = vflashChip{0xbf, 0xb7, 0x5555, 0x2aaa, 0x7fff, 4 * 1024, 512 * 1024, false}
+	am29f016   = vflashChip{0x01, 0xad, 0x555, 0x2aa, 0x7ff, 64 * 1024, 2 * 1024 * 1024, false}
+	m29f160ft  = vflashChip{0x01, 0xd2, 0xaaa, 0x555, 0xfff, 64 * 1024, 2 * 1024 * 1024, true}
+	mx29f008   = vflashChip{0xc2, 0x81, 0x5555, 0x2aaa, 0x7fff, 64 * 1024, 1024 * 1024, false}
+	am29f040   = vflashChip{0x01, 0xa4, 0x555, 0x2aa, 0x7ff, 64 * 1024, 512 * 1024, false}
+)
+
+const (
+	vflashRead = iota
+	vflashUnlock1
+	vflashUnlock2
+	vflashProgram
+	vflashErase
+	vflashEraseUnlock1
+	vflashEraseUnlock2
+)
+
+// vflash is the command state machine of a flash chip over mem.
+// Programming and erasing finish instantly but DQ7/DQ6 report busy for
+// the next couple of reads so that status polling is exercised.
+// With timeLimit set nothing gets programmed and DQ5 reports a timeout
+// until the chip is reset.
+type vflash struct {
+	vflashChip
+	mem        []byte
+	state      int
+	autoselect bool
+	busy       int
+	busyData   uint8
+	toggle     uint8
+	timeLimit  bool
+	exceeded   bool
+	// hook sees every write first; it returns true if it took the write.
+	hook func(f *vflash, addr int, data uint8) bool
+}
+
+func newVFlash(mem []byte, chip vflashChip) *vflash {
+	return &vflash{vflashChip: chip, mem: mem}
+}
+
+func (f *vflash) read(addr int) uint8 {
+	if f.exceeded {
+		f.toggle ^= 0x40
+		return (^f.busyData & 0x80) | f.toggle | 0x20
+	}
+	if f.busy > 0 {
+		f.busy--
+		f.toggle ^= 0x40
+		return (^f.busyData & 0x80) | f.toggle
+	}
+	if f.autoselect {
+		if f.byteMode {
+			if addr&1 != 0 {
+				return 0
+			}
+			addr >>= 1
+		}
+		switch addr & 0xff {
+		case 0x00:
+			return f.manufacturer
+		case 0x01:
+			return f.device
+		}
+		return 0
+	}
+	if addr < 0 || len(f.mem) == 0 {
+		return 0xff
+	}
+	return f.mem[addr%len(f.mem)]
+}
+
+func (f *vflash) write(addr int, data uint8) {
+	a := addr & f.mask
+	if f.hook != nil && f.hook(f, addr, data) {
+		return
+	}
+	if data == 0xf0 && f.state != vflashProgram {
+		f.state = vflashRead
+		f.autoselect = false
+		f.exceeded = false
+		return
+	}
+
+	switch f.state {
+	case vflashRead, vflashErase:
+		if data == 0xaa && a == f.cmd1 {
+			if f.state == vflashErase {
+				f.state = vflashEraseUnlock1
+			} else {
+				f.state = vflashUnlock1
+			}
+			return
+		}
+	case vflashUnlock1, vflashEraseUnlock1:
+		if data == 0x55 && a == f.cmd2 {
+			f.state++
+			return
+		}
+	case vflashUnlock2:
+		if a == f.cmd1 {
+			switch data {
+			case 0xa0:
+				f.state = vflashProgram
+				return
+			case 0x80:
+				f.state = vflashErase
+				return
+			case 0x90:
+				f.state = vflashRead
+				f.autoselect = true
+				return
+			}
+		}
+	case vflashProgram:
+		if f.timeLimit {
+			f.exceeded, f.busyData = true, data
+			break
+		}
+		if len(f.mem) > 0 {
+			f.mem[addr%len(f.mem)] &= data
+		}
+		f.busy, f.busyData = 2, data
+	case vflashEraseUnlock2:
+		if f.timeLimit && (data == 0x10 || data == 0x30) {
+			f.exceeded, f.busyData = true, 0xff
+			break
+		}
+		if data == 0x10 && a == f.cmd1 {
+			for i := range f.mem {
+				f.mem[i] = 0xff
+			}
+			f.busy, f.busyData = 2, 0xff
+		}
+		if data == 0x30 && len(f.mem) > 0 {
+			start := (addr % len(f.mem)) &^ (f.sector - 1)
+			for i := start; i < start+f.sector && i < len(f.mem); i++ {
+				f.mem[i] = 0xff
+			}
+			f.busy, f.busyData = 2, 0xff
+		}
+	}
+	f.state = vflashRead
+}
+
+// veeprom is a 28C EEPROM with software data protection. The bytes loaded
+// since the last read are written in one cycle, which DQ6 and DQ7 report
+// for the next couple of reads. The SDP command bytes are not written.
+type veeprom struct {
+	mem       []byte
+	protected bool
+	unlocked  bool // AAH 55H A0H came before these bytes
+	cmd       []uint8
+	loaded    bool
+	busy      int
+	busyData  uint8
+	toggle    uint8
+}
+
+var (
+	veepromWrite   = []uint8{0xaa, 0x55, 0xa0}
+	veepromDisable = []uint8{0xaa, 0x55, 0x80, 0xaa, 0x55, 0x20}
+)
+
+func (e *veeprom) read(addr int) uint8 {
+	if e.loaded {
+		e.loaded, e.unlocked = false, false
+		e.busy = 2
+	}
+	if e.busy > 0 {
+		e.busy--
+		e.toggle ^= 0x40
+		return (^e.busyData & 0x80) | e.toggle
+	}
+	return e.mem[addr%len(e.mem)]
+}
+
+func (e *veeprom) write(addr int, data uint8) {
+	a := addr % len(e.mem)
+	// 5555H and 2AAAH as far as the chip decodes them
+	want := []int{0x5555, 0x2aaa, 0x5555, 0x5555, 0x2aaa, 0x5555}[len(e.cmd)%6] % len(e.mem)
+	cmd := append(e.cmd, data)
+	if a == want && (isPrefix(cmd, veepromWrite) || isPrefix(cmd, veepromDisable)) {
+		e.cmd = cmd
+		switch {
+		case bytes.Equal(cmd, veepromWrite):
+			e.protected, e.unlocked, e.cmd = true, true, nil
+		case bytes.Equal(cmd, veepromDisable):
+			e.protected, e.cmd = false, nil
+		}
+		return
+	}
+	e.cmd = nil
+	if e.protected && !e.unlocked {
+		return
+	}
+	e.mem[a] = data
+	e.loaded, e.busyData = true, data
+}
+
+func isPrefix(b, of []uint8) bool {
+	return len(b) <= len(of) && bytes.Equal(b, of[:len(b)])
+}
@@ -0,0 +1,463 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// vmbc is the MBC of a simulated GB cartridge.
+type vmbc interface {
+	// rom returns the ROM offset of 0000-7fff.
+	rom(addr uint16) int
+	// ram returns the RAM offset of a000-bfff, or -1 while disabled.
+	ram(addr uint16) int
+	write(addr uint16, data uint8)
+}
+
+var vgbFlashChips = map[string]vflashChip{
+	"am29f016":  am29f016,
+	"m29f160ft": m29f160ft,
+}
+
+// VirtualGB is a GB cartridge in an Arduino reader with the GB adapter,
+// answering the REQ_RAW_* requests like firmware/FCflash/FCflash.ino.
+// ROM is an AMD-style flash chip wired to the cart WR line, so
+// IsSupportedFlash and WriteFlash work on it.
+// A cart titled GBM_MENU_TITLE becomes a GB Memory cart with its
+// register window at 0120-013f.
+type VirtualGB struct {
+	*vport
+	CartType byte
+	ROM      []byte // the whole flash chip
+	RAM      []byte
+	Mapping  []byte // hidden mapping area of a GB Memory cart
+
+	mbc     vmbc
+	flash   *vflash
+	nibbles bool // MBC2 built-in RAM
+	gbm     *vgbm
+}
+
+// NewVirtualGB loads a GB/GBC image into a cart with the named flash chip,
+// am29f016 (default) or m29f160ft.
+func NewVirtualGB(image []byte, flash string) (*VirtualGB, error) {
+	if len(image) < 0x150 {
+		return nil, fmt.Errorf("short GB image: %d bytes", len(image))
+	}
+	title, _, cartType, _, ramSize, sum := parseHeader(image)
+	if image[0x014d] != sum {
+		return nil, errors.New("invalid header")
+	}
+
+	chip := am29f016
+	if flash != "" {
+		c, ok := vgbFlashChips[flash]
+		if !ok {
+			return nil, fmt.Errorf("unknown flash chip: %s", flash)
+		}
+		chip = c
+	}
+	v := &VirtualGB{CartType: cartType}
+	if title == GBM_MENU_TITLE {
+		chip = mx29f008
+		v.gbm = &vgbm{v: v}
+		v.Mapping = bytes.Repeat([]byte{0xff}, 128)
+		v.RAM = make([]byte, 128*1024)
+	} else if ramSize != 0 || cartType == 6 {
+		size, _, err := calcRamSize(cartType, ramSize)
+		if err != nil {
+			return nil, err
+		}
+		v.RAM = make([]byte, size)
+	}
+
+	size := chip.size
+	if len(image) > size {
+		size = len(image)
+	}
+	v.ROM = bytes.Repeat([]byte{0xff}, size)
+	copy(v.ROM, image)
+	v.flash = newVFlash(v.ROM, chip)
+
+	switch {
+	case v.gbm != nil:
+		v.mbc = &vMBC5{}
+		v.flash.hook = v.gbm.flashHook
+	case cartType == 0:
+		v.mbc = &vMBC0{}
+	case cartType < 5:
+		v.mbc = &vMBC1{lo: 1}
+	case cartType == 5 || cartType == 6:
+		v.mbc = &vMBC2{bank: 1}
+		v.nibbles = true
+	case cartType >= 0x0f && cartType <= 0x13:
+		v.mbc = &vMBC3{bank: 1}
+	case cartType >= 0x19 && cartType <= 0x1e:
+		v.mbc = &vMBC5{bank: 1}
+	default:
+		return nil, fmt.Errorf("cart type %02x is not simulated", cartType)
+	}
+	v.vport = newAVRPort(v)
+	return v, nil
+}
+
+func (v *VirtualGB) rom(addr uint16) int {
+	i := v.mbc.rom(addr)
+	if v.gbm != nil && !v.gbm.entire {
+		i &= 128*1024 - 1 // the menu
+	}
+	return i
+}
+
+func (v *VirtualGB) ram(addr uint16) int {
+	if len(v.RAM) == 0 {
+		return -1
+	}
+	i := v.mbc.ram(addr)
+	if i < 0 {
+		return -1
+	}
+	if v.gbm != nil {
+		if v.gbm.entire {
+			i &= 32*1024 - 1
+		} else {
+			i &= 8*1024 - 1
+		}
+	}
+	return i % len(v.RAM)
+}
+
+// read is one read cycle; cs is the /CS pin of the cart, which selects a000-bfff.
+func (v *VirtualGB) read(addr uint16, cs bool) uint8 {
+	if v.gbm != nil {
+		if d, ok := v.gbm.read(addr); ok {
+			return d
+		}
+	}
+	if addr < 0x8000 {
+		return v.flash.read(v.rom(addr))
+	}
+	if cs && addr >= 0xa000 && addr < 0xc000 {
+		if i := v.ram(addr); i >= 0 {
+			if v.nibbles {
+				return 0xf0 | v.RAM[i]
+			}
+			return v.RAM[i]
+		}
+	}
+	return 0xff
+}
+
+func (v *VirtualGB) write(addr uint16, data uint8, cs bool) {
+	if v.gbm != nil && v.gbm.write(addr, data) {
+		return
+	}
+	if addr < 0x8000 {
+		// the flash latches the address before the MBC does
+		if v.gbm == nil {
+			v.flash.write(v.rom(addr), data)
+		}
+		if v.gbm == nil || !v.gbm.mbcDisabled {
+			v.mbc.write(addr, data)
+		}
+		return
+	}
+	if cs && addr >= 0xa000 && addr < 0xc000 {
+		if i := v.ram(addr); i >= 0 {
+			if v.nibbles {
+				data &= 0x0f
+			}
+			v.RAM[i] = data
+		}
+	}
+}
+
+func (v *VirtualGB) request(m Message, payload []byte) []byte {
+	addr := m.Value
+	switch m.Request {
+	case REQ_RAW_READ, REQ_RAW_READ_WO_CS:
+		// only A0-A15 reach the cart
+		return v.readBytes(addr<<8, int(m.Length), m.Request == REQ_RAW_READ)
+	case REQ_RAW_READ_LO:
+		return v.readBytes(addr, int(m.Length), true)
+	case REQ_RAW_WRITE, REQ_RAW_WRITE_WO_CS:
+		v.writeBytes(addr<<8, payload, m.Request == REQ_RAW_WRITE)
+	case REQ_RAW_WRITE_LO, REQ_RAW_WRITE_LO_WO_CS:
+		v.writeBytes(addr, payload, m.Request == REQ_RAW_WRITE_LO)
+	case REQ_GBM_WRITE_REGS:
+		for i := 0; i+3 <= len(payload); i += 4 {
+			v.write(uint16(payload[i])<<8|uint16(payload[i+1]), payload[i+2], false)
+		}
+	case REQ_CPU_READ, REQ_PPU_READ:
+		return bytes.Repeat([]byte{0xff}, int(m.Length))
+	}
+	return nil
+}
+
+func (v *VirtualGB) readBytes(addr uint16, n int, cs bool) []byte {
+	reply := make([]byte, n)
+	for i := range reply {
+		reply[i] = v.read(addr+uint16(i), cs)
+	}
+	return reply
+}
+
+func (v *VirtualGB) writeBytes(addr uint16, data []byte, cs bool) {
+	for i, d := range data {
+		v.write(addr+uint16(i), d, cs)
+	}
+}
+
+// vgbm is the G-MMC1 of a GB Memory cart: commands latched into the
+// register window at 0120-013f run when A5 is written to 013f.
+type vgbm struct {
+	v           *VirtualGB
+	regs        [0x20]uint8
+	enabled     bool
+	writeStep   int
+	mbcDisabled bool
+	entire      bool
+	hidden      int // 0x77 flash commands seen
+}
+
+func (g *vgbm) read(addr uint16) (uint8, bool) {
+	if g.enabled && addr >= 0x0120 && addr < 0x0140 {
+		if addr == 0x0120 {
+			return 0x21, true
+		}
+		return 0xff, true
+	}
+	if g.hidden >= 2 && addr < 0x8000 {
+		i := g.v.rom(addr)
+		if i < len(g.v.Mapping) {
+			return g.v.Mapping[i], true
+		}
+		return 0xff, true
+	}
+	return 0, false
+}
+
+func (g *vgbm) write(addr uint16, data uint8) bool {
+	if addr < 0x0120 || addr >= 0x0140 {
+		return false
+	}
+	g.regs[addr-0x0120] = data
+	if addr == 0x013f && data == 0xa5 {
+		g.command()
+	}
+	return g.enabled
+}
+
+func (g *vgbm) command() {
+	cmd := g.regs[0]
+	if !g.enabled {
+		if cmd == CMD_09h_WAKEUP_AND_RE_ENABLE_FLASH_REGS && g.regs[1] == 0xaa && g.regs[2] == 0x55 {
+			g.enabled = true
+		}
+		return
+	}
+
+	switch cmd {
+	case CMD_08h_DISABLE_FLASH_REGS:
+		g.enabled = false
+	case CMD_0Ah_WRITE_ENABLE_STEP_1:
+		if g.regs[5] == 0x62 && g.regs[6] == 0x04 {
+			g.writeStep = 1
+		}
+	case CMD_02h_WRITE_ENABLE_STEP_2:
+		if g.writeStep == 1 {
+			g.writeStep = 2
+		}
+	case CMD_03h_UNDO_WRITE_STEP_2:
+		if g.writeStep == 2 {
+			g.writeStep = 1
+		}
+	case CMD_04h_MAP_ENTIRE_ROM:
+		g.entire = true
+	case CMD_05h_MAP_MENU:
+		g.entire = false
+	case CMD_10h_DISABLE_MBC_REGS:
+		g.mbcDisabled = true
+	case CMD_11h_RE_ENABLE_MBC_REGS:
+		g.mbcDisabled = false
+	case CMD_0Fh_WRITE_TO_FLASH:
+		if g.writeStep == 2 {
+			addr := uint16(g.regs[5])<<8 | uint16(g.regs[6])
+			g.v.flash.write(g.v.rom(addr), g.regs[7])
+		}
+	}
+	// CMD_C0h_MAP_SELECTED_GAME_WITHOUT_RESET is not simulated.
+}
+
+// flashHook opens the hidden mapping area after the 0x77 command was sent twice.
+func (g *vgbm) flashHook(f *vflash, addr int, data uint8) bool {
+	if data == 0xf0 && f.state != vflashProgram {
+		g.hidden = 0
+		return false
+	}
+	if f.state == vflashUnlock2 && addr&f.mask == f.cmd1 && data == 0x77 {
+		g.hidden++
+		f.state = vflashRead
+		return true
+	}
+	return false
+}
+
+type vMBC0 struct{}
+
+func (b *vMBC0) rom(addr uint16) int           { return int(addr & 0x7fff) }
+func (b *vMBC0) ram(addr uint16) int           { return int(addr & 0x1fff) }
+func (b *vMBC0) write(addr uint16, data uint8) {}
+
+type vMBC1 struct {
+	ramEnable bool
+	lo, hi    uint8
+	mode      uint8
+}
+
+func (b *vMBC1) write(addr uint16, data uint8) {
+	switch addr & 0x6000 {
+	case 0x0000:
+		b.ramEnable = data&0x0f == 0x0a
+	case 0x2000:
+		b.lo = data & 0x1f
+		if b.lo == 0 {
+			b.lo = 1
+		}
+	case 0x4000:
+		b.hi = data & 3
+	case 0x6000:
+		b.mode = data & 1
+	}
+}
+
+func (b *vMBC1) rom(addr uint16) int {
+	bank := int(b.hi)<<5 | int(b.lo)
+	if addr < 0x4000 {
+		bank = 0
+		if b.mode == 1 {
+			bank = int(b.hi) << 5
+		}
+	}
+	return bank<<14 | int(addr&0x3fff)
+}
+
+func (b *vMBC1) ram(addr uint16) int {
+	if !b.ramEnable {
+		return -1
+	}
+	bank := 0
+	if b.mode == 1 {
+		bank = int(b.hi)
+	}
+	return bank<<13 | int(addr&0x1fff)
+}
+
+// vMBC2 decodes its registers by A8 and has 512x4 bits of RAM.
+type vMBC2 struct {
+	ramEnable bool
+	bank      uint8
+}
+
+func (b *vMBC2) write(addr uint16, data uint8) {
+	if addr >= 0x4000 {
+		return
+	}
+	if addr&0x0100 == 0 {
+		b.ramEnable = data&0x0f == 0x0a
+		return
+	}
+	b.bank = data & 0x0f
+	if b.bank == 0 {
+		b.bank = 1
+	}
+}
+
+func (b *vMBC2) rom(addr uint16) int {
+	if addr < 0x4000 {
+		return int(addr)
+	}
+	return int(b.bank)<<14 | int(addr&0x3fff)
+}
+
+func (b *vMBC2) ram(addr uint16) int {
+	if !b.ramEnable {
+		return -1
+	}
+	return int(addr & 0x01ff)
+}
+
+// vMBC3 leaves the RTC registers unmapped.
+type vMBC3 struct {
+	ramEnable bool
+	bank      uint8
+	ramBank   uint8
+}
+
+func (b *vMBC3) write(addr uint16, data uint8) {
+	switch addr & 0x6000 {
+	case 0x0000:
+		b.ramEnable = data&0x0f == 0x0a
+	case 0x2000:
+		b.bank = data & 0x7f
+		if b.bank == 0 {
+			b.bank = 1
+		}
+	case 0x4000:
+		b.ramBank = data
+	}
+}
+
+func (b *vMBC3) rom(addr uint16) int {
+	if addr < 0x4000 {
+		return int(addr)
+	}
+	return int(b.bank)<<14 | int(addr&0x3fff)
+}
+
+func (b *vMBC3) ram(addr uint16) int {
+	if !b.ramEnable || b.ramBank > 3 {
+		return -1
+	}
+	return int(b.ramBank)<<13 | int(addr&0x1fff)
+}
+
+type vMBC5 struct {
+	ramEnable bool
+	bank      uint16
+	ramBank   uint8
+}
+
+func (b *vMBC5) write(addr uint16, data uint8) {
+	switch addr & 0x7000 {
+	case 0x0000, 0x1000:
+		b.ramEnable = data&0x0f == 0x0a
+	case 0x2000:
+		b.bank = b.bank&0x100 | uint16(data)
+	case 0x3000:
+		b.bank = uint16(data&1)<<8 | b.bank&0xff
+	case 0x4000, 0x5000:
+		b.ramBank = data & 0x0f
+	}
+}
+
+func (b *vMBC5) rom(addr uint16) int {
+	if addr < 0x4000 {
+		return int(addr)
+	}
+	return int(b.bank)<<14 | int(addr&0x3fff)
+}
+
+func (b *vMBC5) ram(addr uint16) int {
+	if !b.ramEnable {
+		return -1
+	}
+	return int(b.ramBank)<<13 | int(addr&0x1fff)
+}
+
+// ExceedTimeLimit makes every later program and erase of the flash fail,
+// reporting DQ5 until the chip is reset.
+func (v *VirtualGB) ExceedTimeLimit(on bool) {
+	v.flash.timeLimit = on
+}
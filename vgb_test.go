@@ -0,0 +1,160 @@
+package FCflash
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// testGBImage is a GB image of cartType with 32KB<<romSize of random
+// bytes from seed and a valid header checksum.
+func testGBImage(t *testing.T, cartType, romSize, ramSize byte, seed int64) []byte {
+	t.Helper()
+	img := make([]byte, 32*1024<<romSize)
+	rand.New(rand.NewSource(seed)).Read(img)
+	copy(img[0x0134:0x0144], "TEST\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00")
+	img[0x0147], img[0x0148], img[0x0149] = cartType, romSize, ramSize
+	_, _, _, _, _, sum := parseHeader(img)
+	img[0x014d] = sum
+	return img
+}
+
+func testVirtualGB(t *testing.T, img []byte, flash string) (*VirtualGB, *GB) {
+	t.Helper()
+	v, err := NewVirtualGB(img, flash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, NewGB(v)
+}
+
+func TestVirtualGBDumpROM(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cartType byte
+		romSize  byte
+	}{
+		{"MBC0", 0x00, 0},
+		{"MBC1", 0x01, 3},
+		{"MBC2", 0x05, 2},
+		{"MBC3", 0x13, 4},
+		{"MBC5", 0x19, 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			img := testGBImage(t, tc.cartType, tc.romSize, 0, int64(tc.cartType))
+			_, g := testVirtualGB(t, img, "")
+			var b bytes.Buffer
+			sum, err := g.DumpROM(&b, tc.cartType, tc.romSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b.Bytes(), img) {
+				t.Errorf("ROM differs")
+			}
+			want := uint32(0)
+			for i, d := range img {
+				if i != 0x014e && i != 0x014f {
+					want += uint32(d)
+				}
+			}
+			if sum != want {
+				t.Errorf("checksum %08x, want %08x", sum, want)
+			}
+		})
+	}
+}
+
+func TestVirtualGBDumpRAM(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		cartType byte
+		ramSize  byte
+	}{
+		{"MBC1 32KB", 0x03, 3},
+		{"MBC2", 0x06, 0},
+		{"MBC3 32KB", 0x13, 3},
+		{"MBC5 128KB", 0x1b, 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			v, g := testVirtualGB(t, testGBImage(t, tc.cartType, 2, tc.ramSize, 0), "")
+			rand.New(rand.NewSource(1)).Read(v.RAM)
+			want := append([]byte(nil), v.RAM...)
+			if v.nibbles {
+				for i := range want {
+					want[i] = 0xf0 | want[i]&0x0f
+					v.RAM[i] &= 0x0f
+				}
+			}
+
+			var b bytes.Buffer
+			size, err := g.DumpRAM(&b, tc.cartType, tc.ramSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int(size) != len(v.RAM) || !bytes.Equal(b.Bytes(), want) {
+				t.Errorf("RAM differs: %d bytes of %d", b.Len(), len(v.RAM))
+			}
+
+			_, err = g.ClearRAM(tc.cartType, tc.ramSize)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, d := range v.RAM {
+				if v.nibbles && d != 0x0d || !v.nibbles && d != 0xfd {
+					t.Fatalf("RAM[%d] = %02x after ClearRAM", i, d)
+				}
+			}
+		})
+	}
+}
+
+func TestVirtualGBFlash(t *testing.T) {
+	for _, tc := range []struct {
+		flash  string
+		device uint16
+	}{
+		{"am29f016", 0x01ad},
+		{"m29f160ft", 0x01d2},
+	} {
+		t.Run(tc.flash, func(t *testing.T) {
+			v, g := testVirtualGB(t, testGBImage(t, 0x19, 2, 0, 0), tc.flash)
+			device, err := g.IsSupportedFlash()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if device != tc.device {
+				t.Fatalf("device %04x, want %04x", device, tc.device)
+			}
+
+			// two banks of the first sector, the way tunag writes them
+			data := make([]byte, 32*1024)
+			rand.New(rand.NewSource(2)).Read(data)
+			for bank := 0; bank < 2; bank++ {
+				if bank > 0 {
+					g.WriteRegByte(0x2100, bank)
+				}
+				for i := 0; i < 0x4000; i += PACKET_SIZE {
+					addr := bank<<14 + i
+					err = g.WriteFlash(device, addr, data[addr:addr+PACKET_SIZE])
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			if !bytes.Equal(v.ROM[:len(data)], data) {
+				t.Errorf("flash differs")
+			}
+			for i, d := range v.ROM[len(data):0x10000] {
+				if d != 0xff {
+					t.Fatalf("ROM[%x] = %02x, want erased", len(data)+i, d)
+				}
+			}
+
+			v.ExceedTimeLimit(true)
+			err = g.WriteFlash(device, 0, data[:PACKET_SIZE])
+			if err == nil {
+				t.Errorf("WriteFlash past the time limit succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+)
+
+// mbedTarget is the cartridge side of firmware/FCflash.cpp.
+// mbedRequest returns the reply of a read request.
+type mbedTarget interface {
+	mbedRequest(m MbedMessage) []byte
+}
+
+func hasMbedReply(r MbedRequest) bool {
+	switch r {
+	case MBED_REQ_READ16, MBED_REQ_READ8_CS2, MBED_REQ_CPU_READ, MBED_REQ_PPU_READ:
+		return true
+	}
+	return false
+}
+
+// newMbedPort frames the byte stream like main() of firmware/FCflash.cpp:
+// 16-byte messages only, the write requests are stubs without payload.
+// Oversized reads get no reply.
+func newMbedPort(t mbedTarget) *vport {
+	p := &vport{}
+	p.handle = func(in []byte) int {
+		if len(in) < MBED_MESSAGE_SIZE {
+			return 0
+		}
+		var m MbedMessage
+		m.UnmarshalBinary(in[0:MBED_MESSAGE_SIZE])
+		if !hasMbedReply(m.Request) {
+			t.mbedRequest(m)
+		} else if m.Length <= MBED_PACKET_SIZE {
+			p.out.Write(t.mbedRequest(m))
+		}
+		return MBED_MESSAGE_SIZE
+	}
+	return p
+}
+
+// VirtualGBA is a GBA cartridge in the mbed board of firmware/FCflash.cpp.
+// Save is SRAM, or a flash save in read mode: the firmware cannot write
+// to CS2, so only the first 64KB of a 128KB flash is ever visible.
+type VirtualGBA struct {
+	*vport
+	ROM  []byte
+	Save []byte
+}
+
+// NewVirtualGBA loads a GBA image and its save. An empty save is 32KB of SRAM.
+func NewVirtualGBA(rom, save []byte) (*VirtualGBA, error) {
+	if len(rom) == 0 || len(rom) > 32*1024*1024 {
+		return nil, errors.New("not a GBA image")
+	}
+	v := &VirtualGBA{ROM: rom, Save: save}
+	if len(v.Save) == 0 {
+		v.Save = bytes.Repeat([]byte{0xff}, 32*1024)
+	}
+	v.vport = newMbedPort(v)
+	return v, nil
+}
+
+// read16 is one ROM read; beyond the image the bus returns the low half of the address.
+func (v *VirtualGBA) read16(addr24 uint32) uint16 {
+	i := int(addr24) << 1
+	if i+1 < len(v.ROM) {
+		return uint16(v.ROM[i]) | uint16(v.ROM[i+1])<<8
+	}
+	return uint16(addr24)
+}
+
+func (v *VirtualGBA) mbedRequest(m MbedMessage) []byte {
+	switch m.Request {
+	case MBED_REQ_READ16:
+		// the ROM latches A16-A23 once and counts A0-A15 by itself
+		addr24 := m.Value & 0xffffff
+		reply := make([]byte, m.Length)
+		for i := 0; i+1 < len(reply); i += 2 {
+			a := addr24&0xff0000 | (addr24+uint32(i>>1))&0xffff
+			w := v.read16(a)
+			reply[i], reply[i+1] = uint8(w), uint8(w>>8)
+		}
+		return reply
+	case MBED_REQ_READ8_CS2:
+		addr16 := uint16(m.Value)
+		reply := make([]byte, m.Length)
+		for i := range reply {
+			reply[i] = v.Save[int(addr16+uint16(i))%len(v.Save)]
+		}
+		return reply
+	case MBED_REQ_CPU_READ, MBED_REQ_PPU_READ:
+		return bytes.Repeat([]byte{0xff}, int(m.Length))
+	}
+	return nil
+}
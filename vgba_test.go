@@ -0,0 +1,106 @@
+package FCflash
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// mbedRequest sends m to a simulated mbed board and reads n bytes of reply.
+func mbedRequest(t *testing.T, tr Transport, m MbedMessage, n int) []byte {
+	t.Helper()
+	b, _ := m.MarshalBinary()
+	_, err := tr.Write(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		return nil
+	}
+	reply := make([]byte, n)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, err = tr.ReadFullContext(ctx, reply)
+	if err != nil {
+		t.Fatalf("%+v: %v", m, err)
+	}
+	return reply
+}
+
+func TestMbedMessageRoundTrip(t *testing.T) {
+	m := MbedMessage{Request: MBED_REQ_READ8_CS2, Value: 0x123456, Length: MBED_PACKET_SIZE}
+	b, err := m.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got MbedMessage
+	err = got.UnmarshalBinary(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != m {
+		t.Errorf("got %+v, want %+v", got, m)
+	}
+	if err = got.UnmarshalBinary(b[:8]); !errors.Is(err, ErrInvalidLength) {
+		t.Errorf("8 bytes: got %v, want ErrInvalidLength", err)
+	}
+}
+
+func TestVirtualGBA(t *testing.T) {
+	rom := make([]byte, 256*1024)
+	rand.New(rand.NewSource(0)).Read(rom)
+	save := make([]byte, 32*1024)
+	rand.New(rand.NewSource(1)).Read(save)
+	v, err := NewVirtualGBA(rom, save)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the whole ROM, 64KB at a time, like tunaa dumps it
+	var b bytes.Buffer
+	for addr24 := uint32(0); addr24 < uint32(len(rom)/2); addr24 += MBED_PACKET_SIZE / 2 {
+		b.Write(mbedRequest(t, v, MbedMessage{Request: MBED_REQ_READ16, Value: addr24, Length: MBED_PACKET_SIZE}, MBED_PACKET_SIZE))
+	}
+	if !bytes.Equal(b.Bytes(), rom) {
+		t.Errorf("ROM differs")
+	}
+
+	// past the end of the image the bus holds the address
+	reply := mbedRequest(t, v, MbedMessage{Request: MBED_REQ_READ16, Value: 0x123456, Length: 4}, 4)
+	if !bytes.Equal(reply, []byte{0x56, 0x34, 0x57, 0x34}) {
+		t.Errorf("open bus: % x", reply)
+	}
+
+	reply = mbedRequest(t, v, MbedMessage{Request: MBED_REQ_READ8_CS2, Value: 0x7f00, Length: 0x200}, 0x200)
+	if !bytes.Equal(reply[:0x100], save[0x7f00:]) || !bytes.Equal(reply[0x100:], save[:0x100]) {
+		t.Errorf("SRAM differs")
+	}
+
+	// an oversized read gets no reply, and the next request still does
+	mbedRequest(t, v, MbedMessage{Request: MBED_REQ_READ16, Length: MBED_PACKET_SIZE + 2}, 0)
+	reply = mbedRequest(t, v, MbedMessage{Request: MBED_REQ_READ16, Length: 2}, 2)
+	if !bytes.Equal(reply, rom[:2]) {
+		t.Errorf("after an oversized read: % x", reply)
+	}
+}
+
+func TestVirtualFCMbed(t *testing.T) {
+	v, err := NewVirtualFCMbed(testImage(t, 7, 8, 0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// AxROM: 32KB bank 2
+	mbedRequest(t, v, MbedMessage{Request: MBED_REQ_CPU_WRITE, Value: 0, Length: 2}, 0)
+	reply := mbedRequest(t, v, MbedMessage{Request: MBED_REQ_CPU_READ, Value: 0, Length: 0x8000}, 0x8000)
+	if !bytes.Equal(reply, v.PRG[2*0x8000:3*0x8000]) {
+		t.Errorf("PRG bank 2 differs")
+	}
+	v.CHR[0x1234] = 0xa5
+	reply = mbedRequest(t, v, MbedMessage{Request: MBED_REQ_PPU_READ, Value: 0x1234, Length: 1}, 1)
+	if reply[0] != 0xa5 {
+		t.Errorf("CHR RAM: %02x", reply[0])
+	}
+}
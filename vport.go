@@ -0,0 +1,193 @@
+package FCflash
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OpenVirtual loads a ROM image into a simulated reader chosen by its extension.
+// "<image>,<option>" passes an option to the simulator: the flash chip of
+// a GB cart, the save file of a GBA cart, or "mbed" to put an FC cart in
+// the mbed board.
+func OpenVirtual(fileName string) (Transport, error) {
+	option := ""
+	if i := strings.LastIndexByte(fileName, ','); i != -1 {
+		fileName, option = fileName[:i], fileName[i+1:]
+	}
+	image, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".nes":
+		if option == "mbed" {
+			return NewVirtualFCMbed(image)
+		}
+		v, err := NewVirtualFC(image)
+		if err != nil {
+			return nil, err
+		}
+		if option != "" {
+			err = v.InsertRaw(option)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return v, nil
+	case ".gb", ".gbc":
+		return NewVirtualGB(image, option)
+	case ".gba":
+		var save []byte
+		if option != "" {
+			save, err = os.ReadFile(option)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return NewVirtualGBA(image, save)
+	}
+	return nil, fmt.Errorf("no simulator for %s", fileName)
+}
+
+// vport is the serial end of a simulated reader.
+// Bytes written to it are handed to handle, which returns how many it consumed.
+type vport struct {
+	// Version and Caps are what the AVR firmware reports to REQ_ECHO.
+	// Requests outside Caps are ignored, as older builds do.
+	Version uint8
+	Caps    Caps
+
+	mu     sync.Mutex
+	in     []byte
+	out    bytes.Buffer
+	handle func(in []byte) int
+}
+
+func (p *vport) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.in = append(p.in, b...)
+	for len(p.in) > 0 {
+		n := p.handle(p.in)
+		if n == 0 {
+			break
+		}
+		p.in = p.in[n:]
+	}
+	return len(b), nil
+}
+
+// Read returns io.EOF rather than blocking when the device has nothing to say.
+func (p *vport) Read(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return p.out.Read(b)
+}
+
+func (p *vport) ReadFullContext(ctx context.Context, b []byte) (int, error) {
+	p.mu.Lock()
+	n, _ := p.out.Read(b)
+	p.mu.Unlock()
+	if n < len(b) {
+		ctx, cancel := context.WithDeadline(ctx, time.Now())
+		defer cancel()
+		<-ctx.Done()
+		return n, timeoutError(ctx, n, len(b))
+	}
+	return n, nil
+}
+
+func (p *vport) WriteContext(ctx context.Context, b []byte) (int, error) {
+	return p.Write(b)
+}
+
+func (p *vport) Drain(quiet time.Duration) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	n := p.out.Len()
+	p.out.Reset()
+	return n, nil
+}
+
+func (p *vport) Close() error {
+	return nil
+}
+
+// avrTarget is the cartridge side of firmware/FCflash/FCflash.ino.
+// request returns the reply of a read request.
+type avrTarget interface {
+	request(m Message, payload []byte) []byte
+}
+
+func hasPayload(r Request) bool {
+	switch r {
+	case REQ_PPU_WRITE, REQ_CPU_WRITE_EEP, REQ_PPU_WRITE_EEP, REQ_CPU_WRITE_FLASH,
+		REQ_RAW_WRITE, REQ_RAW_WRITE_LO, REQ_RAW_WRITE_WO_CS, REQ_RAW_WRITE_LO_WO_CS, REQ_RAW_WRITE_EEP,
+		REQ_RAW_WRITE_FLASH, REQ_RAW_PROGRAM_FLASH, REQ_GBM_WRITE_REGS:
+		return true
+	}
+	return false
+}
+
+func hasReply(r Request) bool {
+	switch r {
+	case REQ_CPU_READ_6502, REQ_CPU_READ, REQ_PPU_READ,
+		REQ_RAW_READ, REQ_RAW_READ_LO, REQ_RAW_READ_WO_CS:
+		return true
+	}
+	return false
+}
+
+// newAVRPort frames the byte stream like loop() does: 8-byte messages,
+// followed by Length bytes of payload for write requests.
+// Oversized requests are dropped without reading their payload.
+func newAVRPort(t avrTarget) *vport {
+	p := &vport{Version: FIRMWARE_VERSION, Caps: FIRMWARE_CAPS}
+	p.handle = func(in []byte) int {
+		if len(in) < MESSAGE_SIZE {
+			return 0
+		}
+		var m Message
+		m.UnmarshalBinary(in[0:MESSAGE_SIZE])
+
+		if m.Request == REQ_ECHO {
+			m._reserverd = p.Version
+			m.Index = Index(p.Caps)
+			reply, _ := m.MarshalBinary()
+			p.out.Write(reply)
+			return MESSAGE_SIZE
+		}
+		if p.Caps&m.Request.Cap() != m.Request.Cap() {
+			return MESSAGE_SIZE
+		}
+		if m.Length > PACKET_SIZE && (hasPayload(m.Request) || hasReply(m.Request)) {
+			return MESSAGE_SIZE
+		}
+
+		n := MESSAGE_SIZE
+		var payload []byte
+		if hasPayload(m.Request) {
+			n += int(m.Length)
+			if len(in) < n {
+				return 0
+			}
+			payload = in[MESSAGE_SIZE:n]
+		}
+		reply := t.request(m, payload)
+		if hasReply(m.Request) {
+			p.out.Write(reply)
+		}
+		return n
+	}
+	return p
+}
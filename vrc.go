@@ -0,0 +1,623 @@
+package FCflash
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// VRC is a Konami VRC2, VRC4, VRC6 or VRC7 board. The revisions of a
+// chip route different CPU address lines to its register selects, and
+// VRC2 and VRC4 share mapper numbers, so the board is told apart by
+// switching banks through each wiring, see DetectBoard.
+type VRC struct {
+	name  string
+	chip  int       // 4 for VRC2 and VRC4, 6 or 7
+	board *vrcBoard // nil until detected
+}
+
+func init() {
+	RegisterMapper(21, &VRC{name: "VRC4a/VRC4c", chip: 4})
+	RegisterMapper(22, &VRC{name: "VRC2a", chip: 4})
+	RegisterMapper(23, &VRC{name: "VRC2b/VRC4e/VRC4f", chip: 4})
+	RegisterMapper(24, &VRC{name: "VRC6a", chip: 6})
+	RegisterMapper(25, &VRC{name: "VRC2c/VRC4b/VRC4d", chip: 4})
+	RegisterMapper(26, &VRC{name: "VRC6b", chip: 6})
+	RegisterMapper(85, &VRC{name: "VRC7", chip: 7})
+}
+
+// vrcBoard is a board revision: the chip and the CPU address lines on its
+// register selects, named after the chip's own A0 and A1.
+type vrcBoard struct {
+	name      string
+	mapper    int
+	submapper int  // NES 2.0
+	chip      int  // 2, 4, 6 or 7
+	a0, a1    uint // VRC7 has a0 only
+	chrShift  uint // VRC2a ignores the lowest bit of its CHR banks
+}
+
+var vrcBoards = []vrcBoard{
+	{name: "VRC4a", mapper: 21, submapper: 1, chip: 4, a0: 1, a1: 2},
+	{name: "VRC4c", mapper: 21, submapper: 2, chip: 4, a0: 6, a1: 7},
+	{name: "VRC2a", mapper: 22, chip: 2, a0: 1, a1: 0, chrShift: 1},
+	{name: "VRC4f", mapper: 23, submapper: 1, chip: 4, a0: 0, a1: 1},
+	{name: "VRC4e", mapper: 23, submapper: 2, chip: 4, a0: 2, a1: 3},
+	{name: "VRC2b", mapper: 23, submapper: 3, chip: 2, a0: 0, a1: 1},
+	{name: "VRC6a", mapper: 24, chip: 6, a0: 0, a1: 1},
+	{name: "VRC4b", mapper: 25, submapper: 1, chip: 4, a0: 1, a1: 0},
+	{name: "VRC4d", mapper: 25, submapper: 2, chip: 4, a0: 3, a1: 2},
+	{name: "VRC2c", mapper: 25, submapper: 3, chip: 2, a0: 1, a1: 0},
+	{name: "VRC6b", mapper: 26, chip: 6, a0: 1, a1: 0},
+	{name: "VRC7b", mapper: 85, submapper: 1, chip: 7, a0: 3},
+	{name: "VRC7a", mapper: 85, submapper: 2, chip: 7, a0: 4},
+}
+
+func (v *VRC) Name() string {
+	return v.name
+}
+
+func (v *VRC) Layout() Layout {
+	switch v.chip {
+	case 6:
+		return Layout{PRGBank: 16 * 1024, CHRBank: 1 * 1024, MaxPRG: 16, MaxCHR: 32}
+	case 7:
+		return Layout{PRGBank: 8 * 1024, CHRBank: 1 * 1024, MaxPRG: 32, MaxCHR: 32}
+	}
+	return Layout{PRGBank: 8 * 1024, CHRBank: 1 * 1024, MaxPRG: 16, MaxCHR: 64}
+}
+
+// DetectBoard finds the revision of the board in the cartridge. The
+// Mapper it returns dumps through that wiring without probing again.
+func (v *VRC) DetectBoard(c *Client) (Mapper, int, int, error) {
+	boards, err := detectVRC(c, v.chip)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	b := boards[0]
+	var names []string
+	for _, o := range boards {
+		names = append(names, o.name)
+	}
+	if len(boards) > 1 {
+		// lines for both revisions: NES 2.0 leaves the submapper unspecified
+		b.submapper = 0
+	}
+	return &VRC{name: strings.Join(names, "/"), chip: v.chip, board: &b}, b.mapper, b.submapper, nil
+}
+
+func (v *VRC) detected(c *Client) (vrcBoard, error) {
+	if v.board != nil {
+		return *v.board, nil
+	}
+	boards, err := detectVRC(c, v.chip)
+	if err != nil {
+		return vrcBoard{}, err
+	}
+	return boards[0], nil
+}
+
+// reg is the CPU address of register n, 0-3, in the block at base.
+func (b vrcBoard) reg(base uint16, n int) uint16 {
+	return base | uint16(n&1)<<b.a0 | uint16(n>>1&1)<<b.a1
+}
+
+// setup turns the PRG swap mode off and the PRG RAM on or off.
+func (b vrcBoard) setup(c *Client, ram bool) error {
+	var addr uint16
+	var data uint8
+	switch b.chip {
+	case 2:
+		return nil
+	case 4:
+		// VRC4: $9002 PRG swap mode, PRG RAM enable
+		addr = b.reg(0x9000, 2)
+		if ram {
+			data = 0b01
+		}
+	case 6:
+		// VRC6: $B003 PRG RAM enable, CHR 1KB x8
+		addr = b.reg(0xB000, 3)
+		data = 0x20
+		if ram {
+			data |= 0x80
+		}
+	case 7:
+		// VRC7: $E000 PRG RAM enable, sound off
+		addr = 0xE000
+		data = 0x40
+		if ram {
+			data |= 0x80
+		}
+	}
+	return c.CPUWrite6502(addr, data)
+}
+
+// mapPRG switches the 16KB at offset o into $8000-$BFFF.
+func (b vrcBoard) mapPRG(c *Client, o int) error {
+	if b.chip == 6 {
+		// VRC6: PRG ROM $8000:$8000-$BFFF 16KB
+		return c.CPUWrite6502(0x8000, uint8(o>>14))
+	}
+	// VRC2/VRC4/VRC7: PRG ROM $8000:$8000-$9FFF, $A000 (VRC7: $8010/$8008):$A000-$BFFF
+	second := uint16(0xA000)
+	if b.chip == 7 {
+		second = b.reg(0x8000, 1)
+	}
+	err := c.CPUWrite6502(0x8000, uint8(o>>13))
+	if err != nil {
+		return err
+	}
+	return c.CPUWrite6502(second, uint8(o>>13+1))
+}
+
+// mapCHR switches the 8KB at offset o into $0000-$1FFF, 1KB a register.
+func (b vrcBoard) mapCHR(c *Client, o int) error {
+	for i := 0; i < 8; i++ {
+		bank := o>>10 + i
+		var err error
+		switch b.chip {
+		case 2, 4:
+			// VRC2/VRC4: CHR ROM $B000-$E003, low and high nibbles
+			base := uint16(0xB000 + i/2*0x1000)
+			v := bank << b.chrShift
+			err = c.CPUWrite6502(b.reg(base, i%2*2), uint8(v&0x0f))
+			if err != nil {
+				return err
+			}
+			err = c.CPUWrite6502(b.reg(base, i%2*2+1), uint8(v>>4))
+		case 6:
+			// VRC6: CHR ROM $D000-$D003, $E000-$E003
+			err = c.CPUWrite6502(b.reg(uint16(0xD000+i/4*0x1000), i%4), uint8(bank))
+		case 7:
+			// VRC7: CHR ROM $A000, $A010/$A008 ... $D010/$D008
+			err = c.CPUWrite6502(b.reg(uint16(0xA000+i/2*0x1000), i%2), uint8(bank))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *VRC) DumpPRG(c *Client, w io.Writer, prg int) error {
+	b, err := v.detected(c)
+	if err != nil {
+		return err
+	}
+	err = b.setup(c, false)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, func(p *Pipeline) error {
+		for o := 0; o < prg*16*1024; o += 0x4000 {
+			err := b.mapPRG(c, o)
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < 0x4000; i += PACKET_SIZE {
+				err = p.CPURead(uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (v *VRC) DumpCHR(c *Client, w io.Writer, chr int) error {
+	b, err := v.detected(c)
+	if err != nil {
+		return err
+	}
+	err = b.setup(c, false)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, func(p *Pipeline) error {
+		for o := 0; o < chr*8*1024; o += 0x2000 {
+			err := b.mapCHR(c, o)
+			if err != nil {
+				return err
+			}
+
+			for i := 0; i < 0x2000; i += PACKET_SIZE {
+				err = p.PPURead(uint16(i), PACKET_SIZE)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (v *VRC) SamplePRG(c *Client, w io.Writer, offsets []int, n int) error {
+	b, err := v.detected(c)
+	if err != nil {
+		return err
+	}
+	err = b.setup(c, false)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			err := b.mapPRG(c, o&^0x3fff)
+			if err != nil {
+				return err
+			}
+			err = p.CPURead(uint16(o&0x3fff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (v *VRC) SampleCHR(c *Client, w io.Writer, offsets []int, n int) error {
+	b, err := v.detected(c)
+	if err != nil {
+		return err
+	}
+	err = b.setup(c, false)
+	if err != nil {
+		return err
+	}
+	return pipe(c, w, func(p *Pipeline) error {
+		for _, o := range offsets {
+			err := b.mapCHR(c, o&^0x1fff)
+			if err != nil {
+				return err
+			}
+			err = p.PPURead(uint16(o&0x1fff), n)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// DumpRAM reads the 8KB PRG RAM, enabled for the while on VRC4, VRC6 and
+// VRC7. VRC2 has no control over it.
+func (v *VRC) DumpRAM(c *Client, w io.Writer, size int) error {
+	err := checkRAM(v, size, 8*1024)
+	if err != nil {
+		return err
+	}
+	b, err := v.detected(c)
+	if err != nil {
+		return err
+	}
+	err = b.setup(c, true)
+	if err != nil {
+		return err
+	}
+	err = pipe(c, w, dumpWRAM)
+	if err != nil {
+		return err
+	}
+	return b.setup(c, false)
+}
+
+// WriteRAM restores the 8KB PRG RAM from r.
+func (v *VRC) WriteRAM(c *Client, r io.ReaderAt, size int) error {
+	err := checkRAM(v, size, 8*1024)
+	if err != nil {
+		return err
+	}
+	b, err := v.detected(c)
+	if err != nil {
+		return err
+	}
+	err = b.setup(c, true)
+	if err != nil {
+		return err
+	}
+	err = writeWRAM(c, r, 0)
+	if err != nil {
+		return err
+	}
+	fmt.Println("")
+	return b.setup(c, false)
+}
+
+// vrcProbe reads a packet of PRG at 0x8000|addr, or of CHR at addr.
+type vrcProbe struct {
+	c *Client
+}
+
+func (p vrcProbe) prg(addr uint16) ([]byte, error) {
+	buf := make([]uint8, PACKET_SIZE)
+	return buf, p.c.CPURead(addr, buf)
+}
+
+func (p vrcProbe) chr(addr uint16) ([]byte, error) {
+	buf := make([]uint8, PACKET_SIZE)
+	return buf, p.c.PPURead(addr, buf)
+}
+
+// writes writes data to each addr in order.
+func (p vrcProbe) writes(data uint8, addrs ...uint16) error {
+	for _, a := range addrs {
+		err := p.c.CPUWrite6502(a, data)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// detectVRC returns the revisions of chip whose wiring the cartridge
+// answers to, more than one if the board connects the lines of both.
+// Older firmware leaves A1-A7 low, which selects the same register every time.
+func detectVRC(c *Client, chip int) ([]vrcBoard, error) {
+	if c.Caps()&CAP_CPU_WRITE_ADDR == 0 {
+		return nil, fmt.Errorf("VRC%d: register select lines: %w", chip, ErrUnsupported)
+	}
+	var boards []vrcBoard
+	var err error
+	switch chip {
+	case 4:
+		boards, err = detectVRC24(vrcProbe{c})
+	case 6:
+		boards, err = detectVRC6(vrcProbe{c})
+	case 7:
+		boards, err = detectVRC7(vrcProbe{c})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(boards) == 0 {
+		return nil, fmt.Errorf("%w: no VRC%d wiring switches banks", ErrUnknownMapper, chip)
+	}
+
+	// a board with the lines of two revisions can pass for a third under
+	// another number, VRC4b/VRC4d for VRC4a: the number most of them have
+	count := map[int]int{}
+	best := boards[0].mapper
+	for _, b := range boards {
+		count[b.mapper]++
+		if count[b.mapper] > count[best] {
+			best = b.mapper
+		}
+	}
+	var same []vrcBoard
+	for _, b := range boards {
+		if b.mapper == best {
+			same = append(same, b)
+		}
+	}
+	return same, nil
+}
+
+// detectVRC24 finds the line on the chip's A0 by the high nibble of CHR
+// bank 0 at $B000, then tells VRC4 by its PRG swap mode at $9000 and the
+// chip's A1, and VRC2a from VRC2c by the lowest bit of the CHR bank.
+func detectVRC24(p vrcProbe) ([]vrcBoard, error) {
+	lines := []uint{0, 1, 2, 3, 6, 7}
+	chrRegs := []uint16{0xB000}
+	for _, l := range lines {
+		chrRegs = append(chrRegs, 0xB000|1<<l)
+	}
+
+	// CHR bank 0 at $0000, then which line gets to its high nibble:
+	// a line the board leaves unconnected writes the low one, bank 1
+	err := p.writes(0, chrRegs...)
+	if err != nil {
+		return nil, err
+	}
+	bank0, err := p.chr(0x0000)
+	if err != nil {
+		return nil, err
+	}
+	err = p.writes(1, 0xB000)
+	if err != nil {
+		return nil, err
+	}
+	bank1, err := p.chr(0x0000)
+	if err != nil {
+		return nil, err
+	}
+	err = p.writes(0, 0xB000)
+	if err != nil {
+		return nil, err
+	}
+	a0 := map[uint]bool{}
+	for _, l := range lines {
+		err = p.writes(1, 0xB000|1<<l)
+		if err != nil {
+			return nil, err
+		}
+		got, err := p.chr(0x0000)
+		if err != nil {
+			return nil, err
+		}
+		a0[l] = !bytes.Equal(got, bank0) && !bytes.Equal(got, bank1)
+		err = p.writes(0, 0xB000|1<<l)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// VRC4: in swap mode $C000 is the bank at $8000 and $8000 the second-last
+	var swapRegs []uint16
+	for _, b := range vrcBoards {
+		if b.chip == 4 {
+			swapRegs = append(swapRegs, b.reg(0x9000, 2))
+		}
+	}
+	err = p.writes(0, swapRegs...)
+	if err != nil {
+		return nil, err
+	}
+	var bank []byte
+	for b := uint8(0); b < 4; b++ {
+		err = p.writes(b, 0x8000)
+		if err != nil {
+			return nil, err
+		}
+		bank, err = p.prg(0x0000)
+		if err != nil {
+			return nil, err
+		}
+		fixed, err := p.prg(0x4000)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(bank, fixed) {
+			break
+		}
+	}
+	var boards []vrcBoard
+	for _, b := range vrcBoards {
+		if b.chip != 4 || !a0[b.a0] {
+			continue
+		}
+		err = p.writes(0b10, b.reg(0x9000, 2))
+		if err != nil {
+			return nil, err
+		}
+		got, err := p.prg(0x4000)
+		if err != nil {
+			return nil, err
+		}
+		err = p.writes(0, b.reg(0x9000, 2))
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(got, bank) {
+			boards = append(boards, b)
+		}
+	}
+	if len(boards) > 0 {
+		return boards, nil
+	}
+
+	// VRC2a reads bank 1 as bank 0
+	shifted := bytes.Equal(bank1, bank0)
+	for _, b := range vrcBoards {
+		if b.chip == 2 && a0[b.a0] && (b.chrShift != 0) == shifted {
+			return []vrcBoard{b}, nil
+		}
+	}
+	return nil, nil
+}
+
+// detectVRC6 sets CHR bank 0 to a bank unlike the one there and sees
+// whether $D001 switches it in at $0400 (VRC6a) or at $0800 (VRC6b).
+func detectVRC6(p vrcProbe) ([]vrcBoard, error) {
+	// VRC6: $B003 CHR 1KB x8, either wiring
+	err := p.writes(0x20, 0xB003)
+	if err != nil {
+		return nil, err
+	}
+	err = p.writes(0, 0xD000, 0xD001, 0xD002, 0xD003)
+	if err != nil {
+		return nil, err
+	}
+	bank0, err := p.chr(0x0000)
+	if err != nil {
+		return nil, err
+	}
+	var bank []byte
+	var b uint8
+	for b = 1; b < 8; b++ {
+		err = p.writes(b, 0xD000)
+		if err != nil {
+			return nil, err
+		}
+		bank, err = p.chr(0x0000)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(bank, bank0) {
+			break
+		}
+	}
+
+	err = p.writes(b, 0xD001)
+	if err != nil {
+		return nil, err
+	}
+	var boards []vrcBoard
+	for _, o := range vrcBoards {
+		if o.chip != 6 {
+			continue
+		}
+		// CHR 1 is the chip's register 1
+		got, err := p.chr(uint16(1) << (10 + o.a0))
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(got, bank) {
+			boards = append(boards, o)
+		}
+	}
+	return boards, nil
+}
+
+// detectVRC7 sets the bank at $8000 and sees whether $8010 (VRC7a) or
+// $8008 (VRC7b) switches it in at $A000. On the other revision the write
+// lands on $8000 with the same bank.
+func detectVRC7(p vrcProbe) ([]vrcBoard, error) {
+	// bank 1 at $A000 and b at $8000, whichever the wiring
+	reset := func(b uint8) error {
+		err := p.writes(1, 0x8008, 0x8010)
+		if err != nil {
+			return err
+		}
+		return p.writes(b, 0x8000)
+	}
+	err := reset(0)
+	if err != nil {
+		return nil, err
+	}
+	other, err := p.prg(0x2000)
+	if err != nil {
+		return nil, err
+	}
+	var bank []byte
+	var b uint8
+	for b = 0; b < 8; b++ {
+		err = p.writes(b, 0x8000)
+		if err != nil {
+			return nil, err
+		}
+		bank, err = p.prg(0x0000)
+		if err != nil {
+			return nil, err
+		}
+		if !bytes.Equal(bank, other) {
+			break
+		}
+	}
+
+	var boards []vrcBoard
+	for _, o := range vrcBoards {
+		if o.chip != 7 {
+			continue
+		}
+		err = reset(b)
+		if err != nil {
+			return nil, err
+		}
+		err = p.writes(b, o.reg(0x8000, 1))
+		if err != nil {
+			return nil, err
+		}
+		got, err := p.prg(0x2000)
+		if err != nil {
+			return nil, err
+		}
+		if bytes.Equal(got, bank) {
+			boards = append(boards, o)
+		}
+	}
+	return boards, nil
+}
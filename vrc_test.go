@@ -0,0 +1,98 @@
+package FCflash
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+
+	"github.com/ysh86/FCflash/ines"
+)
+
+func TestVRCDetectBoard(t *testing.T) {
+	for _, b := range vrcBoards {
+		t.Run(b.name, func(t *testing.T) {
+			h := ines.Header{NES2: true, Mapper: b.mapper, Submapper: b.submapper, PRGROM: 128 * 1024, CHRROM: 128 * 1024}
+			fc, v := testHandshakeFC(t, testHeaderImage(t, h, int64(b.mapper)))
+			mapper, submapper, err := fc.DetectBoard()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mapper != b.mapper || submapper != b.submapper {
+				t.Errorf("detected %d.%d (%s), want %d.%d", mapper, submapper, fc.Mapper.Name(), b.mapper, b.submapper)
+			}
+			testDump(t, fc, v, 8, 16)
+		})
+	}
+}
+
+// TestVRCBothLines has boards that connect the lines of two revisions,
+// as the iNES mapper numbers without a submapper are simulated.
+func TestVRCBothLines(t *testing.T) {
+	for _, tc := range []struct {
+		mapper int
+		name   string
+	}{
+		{21, "VRC4a/VRC4c"},
+		{23, "VRC4f/VRC4e"},
+		{25, "VRC4b/VRC4d"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			h := ines.Header{Mapper: tc.mapper, PRGROM: 256 * 1024, CHRROM: 256 * 1024}
+			fc, v := testHandshakeFC(t, testHeaderImage(t, h, 0))
+			mapper, submapper, err := fc.DetectBoard()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if mapper != tc.mapper || submapper != 0 {
+				t.Errorf("detected %d.%d, want %d.0", mapper, submapper, tc.mapper)
+			}
+			if fc.Mapper.Name() != tc.name {
+				t.Errorf("detected %s, want %s", fc.Mapper.Name(), tc.name)
+			}
+			testDump(t, fc, v, 16, 32)
+		})
+	}
+}
+
+func TestVRCSaveRAM(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		h    ines.Header
+	}{
+		{"VRC4", ines.Header{NES2: true, Mapper: 21, Submapper: 1, PRGROM: 256 * 1024, CHRROM: 256 * 1024, PRGNVRAM: 8 * 1024}},
+		{"VRC6", ines.Header{NES2: true, Mapper: 26, PRGROM: 256 * 1024, CHRROM: 256 * 1024, PRGNVRAM: 8 * 1024}},
+		{"VRC7", ines.Header{NES2: true, Mapper: 85, Submapper: 2, PRGROM: 512 * 1024, CHRROM: 128 * 1024, PRGNVRAM: 8 * 1024}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fc, v := testHandshakeFC(t, testHeaderImage(t, tc.h, 0))
+			sav := make([]byte, 8*1024)
+			rand.New(rand.NewSource(1)).Read(sav)
+			err := fc.WriteRAM(bytes.NewReader(sav), len(sav))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(v.WRAM, sav) {
+				t.Errorf("W-RAM differs from the .sav")
+			}
+			var b bytes.Buffer
+			err = fc.DumpRAM(&b, len(sav))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(b.Bytes(), sav) {
+				t.Errorf("dump differs from the .sav")
+			}
+		})
+	}
+}
+
+func TestVRCLegacyFirmware(t *testing.T) {
+	h := ines.Header{NES2: true, Mapper: 21, Submapper: 1, PRGROM: 128 * 1024, CHRROM: 128 * 1024}
+	// no Handshake: the caps of the unversioned firmware
+	fc, _ := testFC(t, testHeaderImage(t, h, 0))
+	_, _, err := fc.DetectBoard()
+	if !errors.Is(err, ErrUnsupported) {
+		t.Errorf("got %v, want ErrUnsupported", err)
+	}
+}